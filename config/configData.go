@@ -15,26 +15,147 @@ Data - Read configuration data from the JSON configuration file.
 Note any undefined values are defaulted to constants defined below
 */
 type Data struct {
-	Port               int
-	DefaultLogFileName string
-	ConfigName         string
-	Redirections       map[string]string
-	ContentTypes       map[string]string
-	ContentTypeCharset string
-	LoggerLevels       map[string]string
-	PanicResponseCode  int
-	StaticPaths        map[string]map[string]string
-	TemplatePaths      map[string]string
-	TemplateData       map[string]map[string]string
-	ScriptData         map[string]*ScriptData
+	Port                 int
+	DefaultLogFileName   string
+	ConfigName           string
+	Redirections         map[string]string
+	ContentTypes         map[string]string
+	ContentTypeCharset   string
+	LoggerLevels         map[string]string
+	ModuleLevels         map[string]string
+	PanicResponseCode    int
+	StaticPaths          map[string]map[string]string
+	TemplatePaths        map[string]string
+	TemplateData         map[string]map[string]string
+	ScriptData           map[string]*ScriptData
+	ErrorHistorySize     int
+	TimeoutResponseCode  int
+	RequestTimeoutMillis int
+	RouteTimeoutsMillis  map[string]int
+	MaxBodySize          int64
+	RouteMaxBodySizes    map[string]int64
+	RateLimits           map[string]*RateLimitData
+	Authentication       *AuthenticationData
+	Sessions             *SessionData
+	CSRF                 *CSRFData
+	IPFilters            map[string]*IPFilterData
+	TrustedProxies       []string
+	WebSocketOrigins     []string
+	Upload               *UploadData
+	SchemaPath           string
+	RouteSchemas         map[string]map[string]string
+	AccessLog            *AccessLogData
+	LogRotation          *LogRotationData
 }
 
 /*
-ScriptData - For a goven OS define the scriptpath and the script data
+ScriptData - For a goven OS define the scriptpath and the script data.
+Roles (optional) defines the roles required to run a script by script name
 */
 type ScriptData struct {
-	Path string
-	Data map[string][]string
+	Path  string
+	Data  map[string][]string
+	Roles map[string][]string
+}
+
+/*
+RateLimitData - A token bucket rate limit for a route prefix.
+If KeyHeader is defined then clients are identified by the header value (an API key) instead of the IP address.
+The key must be a valid API key (see AuthenticationData.APIKeys) otherwise the IP address is used.
+*/
+type RateLimitData struct {
+	RequestsPerSecond float64
+	Burst             int
+	KeyHeader         string
+}
+
+/*
+AuthenticationData - Authentication configuration.
+Rules map a route prefix to the accepted schemes (Basic, ApiKey, Bearer).
+UserFile contains name:bcryptHash lines for Basic authentication.
+APIKeys maps a static API key to a principal name. APIKeyRoles maps the principal name to it's roles.
+AdminRoles are the roles required for the admin routes (for example /stop).
+JWTSecret is used to validate HS256 signed bearer tokens.
+*/
+type AuthenticationData struct {
+	Realm        string
+	UserFile     string
+	APIKeyHeader string
+	APIKeys      map[string]string
+	APIKeyRoles  map[string][]string
+	AdminRoles   []string
+	JWTSecret    string
+	Rules        map[string][]string
+}
+
+/*
+SessionData - Cookie based session configuration.
+If Path is defined sessions are stored as files in that directory otherwise they are held in memory.
+Secret is used to sign the session cookie. SameSite is Lax, Strict or None.
+*/
+type SessionData struct {
+	CookieName             string
+	Secret                 string
+	Path                   string
+	IdleTimeoutMinutes     int
+	AbsoluteTimeoutMinutes int
+	Secure                 bool
+	SameSite               string
+}
+
+/*
+CSRFData - Cross Site Request Forgery protection configuration. Empty names use the defaults.
+ExemptPrefixes are route prefixes that are not checked (Bearer authenticated requests are always exempt).
+*/
+type CSRFData struct {
+	CookieName     string
+	HeaderName     string
+	FieldName      string
+	Secure         bool
+	ExemptPrefixes []string
+}
+
+/*
+UploadData - File upload configuration. Files are saved to the static path for StaticName.
+AllowedExtensions must be defined. An empty list rejects ALL files.
+An empty AllowedContentTypes list allows any. A MaxFileSize or MaxFiles of 0 uses the default.
+*/
+type UploadData struct {
+	StaticName          string
+	MaxFileSize         int64
+	MaxFiles            int
+	AllowedExtensions   []string
+	AllowedContentTypes []string
+}
+
+/*
+AccessLogData - Write one access log record per request.
+Format is common, combined, json or a template containing ${token} values.
+If FileName is defined the records are written to that file otherwise to the ACCESS log level.
+*/
+type AccessLogData struct {
+	Format   string
+	FileName string
+}
+
+/*
+LogRotationData - Rotation of the log files. Rotate when a file exceeds MaxSizeMB (0 is no limit) and/or Daily at midnight.
+Keep is the number of rotated files to keep (0 keeps all). Compress gzips the rotated files.
+*/
+type LogRotationData struct {
+	MaxSizeMB int
+	Daily     bool
+	Keep      int
+	Compress  bool
+}
+
+/*
+IPFilterData - Allowed and denied networks (CIDR or IP address) for a route prefix.
+TrustedProxies in Data defines the proxies that are trusted to set X-Forwarded-For. They are also used by the rate limits.
+*/
+type IPFilterData struct {
+	Allow []string
+	Deny  []string
 }
 
 /*
@@ -59,15 +180,33 @@ func LoadConfigData(configFileName string) error {
 	}
 
 	configDataInstance = &Data{
-		Port:               8080,
-		ContentTypeCharset: "utf-8",
-		ContentTypes:       make(map[string]string),
-		StaticPaths:        make(map[string]map[string]string),
-		Redirections:       make(map[string]string),
-		LoggerLevels:       make(map[string]string),
-		TemplatePaths:      make(map[string]string),
-		TemplateData:       make(map[string]map[string]string),
-		ScriptData:         make(map[string]*ScriptData),
+		Port:                 8080,
+		ContentTypeCharset:   "utf-8",
+		ContentTypes:         make(map[string]string),
+		StaticPaths:          make(map[string]map[string]string),
+		Redirections:         make(map[string]string),
+		LoggerLevels:         make(map[string]string),
+		ModuleLevels:         make(map[string]string),
+		TemplatePaths:        make(map[string]string),
+		TemplateData:         make(map[string]map[string]string),
+		ScriptData:           make(map[string]*ScriptData),
+		ErrorHistorySize:     10,
+		TimeoutResponseCode:  504,
+		RequestTimeoutMillis: 0,
+		RouteTimeoutsMillis:  make(map[string]int),
+		MaxBodySize:          0,
+		RouteMaxBodySizes:    make(map[string]int64),
+		RateLimits:           make(map[string]*RateLimitData),
+		Authentication:       nil,
+		Sessions:             nil,
+		CSRF:                 nil,
+		IPFilters:            make(map[string]*IPFilterData),
+		TrustedProxies:       []string{},
+		Upload:               nil,
+		SchemaPath:           "",
+		RouteSchemas:         make(map[string]map[string]string),
+		AccessLog:            nil,
+		LogRotation:          nil,
 	}
 
 	/*
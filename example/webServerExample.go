@@ -1,14 +1,16 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/stuartdd/webServerBase/config"
 	"github.com/stuartdd/webServerBase/largefile"
@@ -49,6 +51,27 @@ func main() {
 		Initialiase the logs. Log name is in the config data. If not defined default to sysout
 	*/
 	logging.CreateLogWithFilenameAndAppID(configData.DefaultLogFileName, exec+":"+strconv.Itoa(configData.Port), 1, configData.LoggerLevels)
+	/*
+		Rotate the log files if required. SIGHUP re-opens the log files (for external log rotation tools)
+	*/
+	if configData.LogRotation != nil {
+		logging.SetLogRotation(&logging.LogRotation{
+			MaxSizeMB: configData.LogRotation.MaxSizeMB,
+			Daily:     configData.LogRotation.Daily,
+			Keep:      configData.LogRotation.Keep,
+			Compress:  configData.LogRotation.Compress,
+		})
+	}
+	logging.ReopenLogFilesOnSIGHUP()
+	/*
+		Module levels override the logger levels for a module. For example {"Template": "DEBUG"}
+	*/
+	if len(configData.ModuleLevels) > 0 {
+		err := logging.SetModuleLevels(configData.ModuleLevels)
+		if err != nil {
+			logging.NewLogger("ServerMain").Fatal(err)
+		}
+	}
 	/*
 		Stack the defered processes (Last in First out)
 	*/
@@ -106,23 +129,120 @@ func RunWithConfig(configData *config.Data, executableName string) {
 		Set the http status code returned if a panic is thrown by any od the handlers
 	*/
 	serverInstance.SetPanicStatusCode(configData.PanicResponseCode)
+	/*
+		Set the number of errors retained in the status statistics (returned by /status)
+	*/
+	serverInstance.SetErrorHistorySize(configData.ErrorHistorySize)
+	/*
+		Write one access log record per request in the configured format
+	*/
+	if configData.AccessLog != nil {
+		serverInstance.SetAccessLog(servermain.NewAccessLog(configData.AccessLog.Format, configData.AccessLog.FileName))
+	}
+	/*
+		Set the maximum request body sizes. A route limit overrides the global limit for that route.
+	*/
+	serverInstance.SetMaxBodySize(configData.MaxBodySize)
+	for route, maxBytes := range configData.RouteMaxBodySizes {
+		serverInstance.SetRouteMaxBodySize(route, maxBytes)
+	}
+	/*
+		Set the request timeouts. A route timeout overrides the global timeout for that route.
+	*/
+	serverInstance.SetTimeoutStatusCode(configData.TimeoutResponseCode)
+	serverInstance.SetRequestTimeout(time.Duration(configData.RequestTimeoutMillis) * time.Millisecond)
+	for route, millis := range configData.RouteTimeoutsMillis {
+		serverInstance.SetRouteTimeout(route, time.Duration(millis)*time.Millisecond)
+	}
 
 	scriptData := config.GetConfigDataInstance().GetScriptDataForOS()
 	serverInstance.SetOsScriptsData(scriptData.Path, scriptData.Data)
+	serverInstance.SetOsScriptRoles(scriptData.Roles)
 	/*
 		A before handler is executed before ALL requests are passed to Mapped handlers
 		You can add multiple before handlers. These are usefull for access control and other global checks
 		If the before handler vetos the request then the Mapped handlers are not called
 	*/
 	serverInstance.AddBeforeHandler(filterBefore)
+	/*
+		Allow or deny requests by client IP address per route prefix. This is checked before the url mapping is found.
+	*/
+	if len(configData.IPFilters) > 0 {
+		ipFilter := servermain.NewIPFilter()
+		ipFilter.SetTrustedProxies(configData.TrustedProxies)
+		for prefix, filter := range configData.IPFilters {
+			ipFilter.AddIPRule(prefix, filter.Allow, filter.Deny)
+		}
+		serverInstance.SetIPFilter(ipFilter)
+	}
+	/*
+		The authenticator is created first so the rate limiter can validate API keys
+	*/
+	var authenticator *servermain.Authenticator
+	if configData.Authentication != nil {
+		authenticator = createAuthenticator(configData.Authentication)
+	}
+	/*
+		Rate limit requests per route prefix. This is a before handler so it runs before the mapped handlers.
+	*/
+	if len(configData.RateLimits) > 0 {
+		rateLimiter := servermain.NewRateLimiter()
+		rateLimiter.SetTrustedProxies(configData.TrustedProxies)
+		for prefix, limit := range configData.RateLimits {
+			rateLimiter.AddRateLimit(prefix, limit.RequestsPerSecond, limit.Burst, limit.KeyHeader)
+		}
+		/*
+			A key header is only used to identify the client if it is a valid API key
+		*/
+		if authenticator != nil {
+			rateLimiter.SetKeyValidator(authenticator.IsAPIKey)
+		}
+		serverInstance.AddBeforeHandler(rateLimiter.BeforeHandler)
+	}
+	/*
+		Authenticate requests per route prefix. Added after the rate limiter so password guessing is also rate limited.
+		If admin roles are defined then the principal must have one of them to stop the server.
+	*/
+	adminRoles := []string{}
+	if authenticator != nil {
+		serverInstance.AddBeforeHandler(authenticator.BeforeHandler)
+		adminRoles = configData.Authentication.AdminRoles
+	}
+	/*
+		Cookie based sessions. Handlers use RequestHandlerHelper.GetSession() and template data providers use GetSessionFromRequest
+	*/
+	if configData.Sessions != nil {
+		serverInstance.AddBeforeHandler(createSessionManager(configData.Sessions).BeforeHandler)
+	}
+	/*
+		CSRF protection for POST, PUT and DELETE. Templates can include the token using {{.csrfToken}}
+	*/
+	if configData.CSRF != nil {
+		serverInstance.AddBeforeHandler(createCSRFProtection(configData.CSRF).BeforeHandler)
+	}
 	/*
 		Add a function for all URL mappings. A ? matches ANY value. A * indicates any value after the match
 		E.G. /x/y/* will activate for x/y/1/d/3/4/5/
 		E.G. /x/?/y wil activate for /x/1/y
 	*/
-	serverInstance.AddMappedHandler("/stop", http.MethodGet, servermain.StopServerInstance)
-	serverInstance.AddMappedHandlerWithNames("/stop/?", http.MethodGet, servermain.StopServerInstance, []string{"seconds"})
+	serverInstance.AddMappedHandler("/stop", http.MethodGet, servermain.StopServerInstance, adminRoles...)
+	serverInstance.AddMappedHandlerWithNames("/stop/?", http.MethodGet, servermain.StopServerInstance, []string{"seconds"}, adminRoles...)
 	serverInstance.AddMappedHandler("/status", http.MethodGet, servermain.StatusHandler)
+	/*
+		Log levels can only be changed by an authenticated admin. Not mapped if there are no admin roles
+	*/
+	if len(adminRoles) > 0 {
+		serverInstance.AddMappedHandler("/admin/log/levels", "GET,PUT", servermain.LogLevelsHandler, adminRoles...)
+	}
+	serverInstance.AddMappedHandler("/status/events", http.MethodGet, statusEventsHandler)
+	/*
+		WebSocket connections are accepted from the same host and from the origins in the config
+	*/
+	serverInstance.SetWebSocketOrigins(configData.WebSocketOrigins)
+	serverInstance.AddWebSocketHandler("/echo", echoWebSocketHandler)
+	serverInstance.AddMappedHandler("/metrics", http.MethodGet, servermain.MetricsHandler)
+	serverInstance.AddMappedHandler("/health/live", http.MethodGet, servermain.HealthLiveHandler)
+	serverInstance.AddMappedHandler("/health/ready", http.MethodGet, servermain.HealthReadyHandler)
 	serverInstance.AddMappedHandler("/static/*", http.MethodGet, servermain.DefaultStaticFileHandler)
 	serverInstance.AddMappedHandlerWithNames("/script/?", http.MethodGet, servermain.DefaultOSScriptHandler, []string{"script"})
 	serverInstance.AddMappedHandlerWithNames("/site/?", http.MethodGet, servermain.DefaultTemplateFileHandler, []string{"template"})
@@ -131,6 +251,29 @@ func RunWithConfig(configData *config.Data, executableName string) {
 	serverInstance.AddMappedHandlerWithNames("/path/?/file/?", http.MethodPost, fileSaveHandler, []string{"path", "filename"})
 	serverInstance.AddMappedHandlerWithNames("/path/?/file/?/ext/?", http.MethodPost, fileSaveHandler, []string{"path", "filename", "ext"})
 	serverInstance.AddMappedHandlerWithNames("/large/?/file/?/ext/?/page/?", http.MethodPost, fileLargeHandler, []string{"path", "filename", "ext", "page"})
+	if configData.Upload != nil {
+		upload := createFileUploadHandler(configData.Upload)
+		serverInstance.AddMappedHandler("/upload", http.MethodPost, upload.Handler)
+		/*
+			Limit the upload body for ALL handlers unless a smaller limit is configured
+		*/
+		maxBodySize := serverInstance.GetMaxBodySize("/upload")
+		if maxBodySize == 0 || maxBodySize > upload.GetMaxBodySize() {
+			serverInstance.SetRouteMaxBodySize("/upload", upload.GetMaxBodySize())
+		}
+	}
+	/*
+		Validate request bodies against JSON schemas. For example "routeSchemas" : {"/path/?/file/?":{"POST":"file"}}
+		loads the schema file.json from the schema path.
+	*/
+	if configData.SchemaPath != "" {
+		serverInstance.SetSchemaPath(configData.SchemaPath)
+		for route, methods := range configData.RouteSchemas {
+			for method, schemaName := range methods {
+				serverInstance.SetRouteSchema(route, method, schemaName)
+			}
+		}
+	}
 
 	/*
 		An after handler is executed after ALL requests have been handled
@@ -186,13 +329,73 @@ func fileSaveHandler(r *http.Request, response *servermain.Response) {
 	pathName := h.GetNamedURLPart("path", "")     // Not optional
 	ext := h.GetNamedURLPart("ext", "txt")        // Optional. Default value txt
 	fullFile := filepath.Join(h.GetStaticPathForName(pathName).FilePath, fileName+"."+ext)
-	err := ioutil.WriteFile(fullFile, h.GetBody(), 0644)
+	/*
+		Stream the body straight to the file. If the body is too large remove the partial file.
+	*/
+	file, err := os.Create(fullFile)
 	if err != nil {
+		panicapi.ThrowError(400, panicapi.SCWriteFile, fmt.Sprintf("fileSaveHandler: static path [%s], file [%s] could not create file", pathName, fileName), err.Error())
+	}
+	body := h.GetBodyReader()
+	defer body.Close()
+	_, err = io.Copy(file, body)
+	file.Close()
+	if err != nil {
+		os.Remove(fullFile)
+		if err == servermain.ErrRequestBodyTooLarge {
+			panicapi.ThrowError(413, panicapi.SCBodyTooLarge, "Request body too large", err.Error())
+		}
 		panicapi.ThrowError(400, panicapi.SCWriteFile, fmt.Sprintf("fileSaveHandler: static path [%s], file [%s] could not write file", pathName, fileName), err.Error())
 	}
 	response.SetResponse(201, "{\"Created\":\"OK\"}", "application/json")
 }
 
+/*
+statusEventsHandler (example function) - push the server status to the browser every 5 seconds as server sent events
+	var source = new EventSource("/status/events");
+	source.addEventListener("status", function(e) { console.log(e.data); });
+*/
+func statusEventsHandler(r *http.Request, response *servermain.Response) {
+	server := response.GetWrappedServer()
+	response.ServerSentEvents(r, func(events *servermain.EventStream) error {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			data, err := json.Marshal(server.GetStatusData())
+			if err != nil {
+				return err
+			}
+			err = events.Send("status", "", string(data))
+			if err != nil {
+				return nil
+			}
+			select {
+			case <-events.Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+/*
+echoWebSocketHandler (example function) - return each message to the sender until the connection is closed
+	var socket = new WebSocket("ws://localhost:8080/echo");
+	socket.onmessage = function(e) { console.log(e.data); };
+*/
+func echoWebSocketHandler(conn *servermain.WebSocketConn) {
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		err = conn.WriteMessage(messageType, data)
+		if err != nil {
+			return
+		}
+	}
+}
+
 /*
 qubeHandler (example function) - return the qube of the number. E.G. qube of 5 "/calc/qube/5"
 */
@@ -272,6 +475,15 @@ func templateDataProvider(r *http.Request, templateName string, data interface{}
 				}
 			}
 		}
+		/*
+			Add the session values (if there is a session) prefixed with session_
+		*/
+		session := servermain.GetSessionFromRequest(r)
+		if session != nil {
+			for name, value := range session.GetValues() {
+				v["session_"+name] = value
+			}
+		}
 		/*
 			Add the template name in for good measure!
 		*/
@@ -279,6 +491,99 @@ func templateDataProvider(r *http.Request, templateName string, data interface{}
 	}
 }
 
+/*
+createFileUploadHandler (example function) - Create a file upload handler from the configuration data
+*/
+func createFileUploadHandler(uploadData *config.UploadData) *servermain.FileUploadHandler {
+	upload := servermain.NewFileUploadHandler(uploadData.StaticName)
+	if uploadData.MaxFileSize > 0 {
+		upload.SetMaxFileSize(uploadData.MaxFileSize)
+	}
+	if uploadData.MaxFiles > 0 {
+		upload.SetMaxFiles(uploadData.MaxFiles)
+	}
+	upload.SetAllowedExtensions(uploadData.AllowedExtensions...)
+	upload.SetAllowedContentTypes(uploadData.AllowedContentTypes...)
+	return upload
+}
+
+/*
+createCSRFProtection (example function) - Create CSRF protection from the configuration data
+*/
+func createCSRFProtection(csrfData *config.CSRFData) *servermain.CSRFProtection {
+	csrf := servermain.NewCSRFProtection()
+	if csrfData.CookieName != "" {
+		csrf.SetCookieName(csrfData.CookieName)
+	}
+	if csrfData.HeaderName != "" {
+		csrf.SetHeaderName(csrfData.HeaderName)
+	}
+	if csrfData.FieldName != "" {
+		csrf.SetFieldName(csrfData.FieldName)
+	}
+	csrf.SetSecure(csrfData.Secure)
+	for _, prefix := range csrfData.ExemptPrefixes {
+		csrf.AddExemptPrefix(prefix)
+	}
+	return csrf
+}
+
+/*
+createSessionManager (example function) - Create a session manager from the configuration data
+*/
+func createSessionManager(sessionData *config.SessionData) *servermain.SessionManager {
+	var store servermain.SessionStore
+	if sessionData.Path != "" {
+		store = servermain.NewFileSessionStore(sessionData.Path)
+	} else {
+		store = servermain.NewMemorySessionStore()
+	}
+	sessionManager := servermain.NewSessionManager(store, []byte(sessionData.Secret))
+	if sessionData.CookieName != "" {
+		sessionManager.SetCookieName(sessionData.CookieName)
+	}
+	if sessionData.IdleTimeoutMinutes > 0 {
+		sessionManager.SetIdleTimeout(time.Duration(sessionData.IdleTimeoutMinutes) * time.Minute)
+	}
+	if sessionData.AbsoluteTimeoutMinutes > 0 {
+		sessionManager.SetAbsoluteTimeout(time.Duration(sessionData.AbsoluteTimeoutMinutes) * time.Minute)
+	}
+	sessionManager.SetSecure(sessionData.Secure)
+	switch strings.ToLower(sessionData.SameSite) {
+	case "strict":
+		sessionManager.SetSameSite(http.SameSiteStrictMode)
+	case "none":
+		sessionManager.SetSameSite(http.SameSiteNoneMode)
+	}
+	return sessionManager
+}
+
+/*
+createAuthenticator (example function) - Create an authenticator from the configuration data
+*/
+func createAuthenticator(authData *config.AuthenticationData) *servermain.Authenticator {
+	authenticator := servermain.NewAuthenticator(authData.Realm)
+	if authData.UserFile != "" {
+		err := authenticator.LoadUserFile(authData.UserFile)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if authData.APIKeyHeader != "" {
+		authenticator.SetAPIKeyHeader(authData.APIKeyHeader)
+	}
+	for key, name := range authData.APIKeys {
+		authenticator.AddAPIKey(key, name, authData.APIKeyRoles[name]...)
+	}
+	if authData.JWTSecret != "" {
+		authenticator.SetJWTSecret([]byte(authData.JWTSecret))
+	}
+	for prefix, schemes := range authData.Rules {
+		authenticator.AddAuthRule(prefix, schemes...)
+	}
+	return authenticator
+}
+
 /************************************************
 End of handlers section
 
@@ -64,6 +64,9 @@ func TestServer(t *testing.T) {
 		Test GET functions
 	*/
 	test.AssertStringContains(t, "", sendGet(t, 200, "status", headers("json", "")), "\"State\":\"RUNNING\"", "\"Executable\":\"TestExe\"", "\"Panics\":0")
+	test.AssertStringContains(t, "", sendGet(t, 200, "health/live", headers("json", "")), "\"Status\":\"UP\"", "\"Checks\":{}")
+	test.AssertStringContains(t, "", sendGet(t, 200, "health/ready", headers("json", "")), "\"Status\":\"UP\"", "\"State\":\"RUNNING\"", "\"templates\":{\"Status\":\"UP\"}", "\"staticPaths\":{\"Status\":\"UP\"}", "\"osScripts\":{\"Status\":\"UP\"}")
+	test.AssertStringContains(t, "", sendGet(t, 404, "admin/log/levels", headers("json", "")), "\"Status\":404")
 	test.AssertStringContains(t, "", sendGet(t, 404, "not-fo", headers("json", "")), "\"Status\":404", "\"Code\":"+strconv.Itoa(panicapi.SCPathNotFound), "GET URL:/not-fo")
 	/*
 		Test GET functions with calc
@@ -87,6 +90,21 @@ func TestServer(t *testing.T) {
 	*/
 	prc := configData.PanicResponseCode
 	test.AssertStringContains(t, "DIV By Zero", sendGet(t, prc, "calc/10/div/0", headers("json", "")), "\"Status\":"+strconv.Itoa(prc), "\"Code\":"+strconv.Itoa(panicapi.SCRuntimeError), "integer divide by zero", "Internal Server Error")
+	/*
+		Test the statistics recorded the panic against the route
+	*/
+	test.AssertStringContains(t, "Statistics", sendGet(t, 200, "status", headers("json", "")), "\"Panics\":1", "\"GET /calc/?/div/?\":{", "\"5xx\":1", "\"Route\":\"/calc/?/div/?\",\"Status\":"+strconv.Itoa(prc))
+	/*
+		Test the metrics are in the Prometheus text format
+	*/
+	test.AssertStringContains(t, "Metrics", sendGet(t, 200, "metrics", headers("txt", "")),
+		"# TYPE webserver_http_requests_total counter",
+		"webserver_http_requests_total{route=\"/calc/?/div/?\",method=\"GET\",status=\"200\"} 3",
+		"webserver_http_request_duration_seconds_bucket{route=\"/calc/?/div/?\",method=\"GET\",status=\"200\",le=\"+Inf\"} 3",
+		"webserver_http_requests_in_flight 1",
+		"webserver_panics_total 1",
+		"webserver_template_render_duration_seconds_count{template=\"index1.html\"} 1",
+		"webserver_os_script_executions_total{script=\"list\",result=\"ok\"} 2")
 
 }
 
@@ -162,6 +180,8 @@ func deleteFile(t *testing.T, name string) {
 
 func stopServer(t *testing.T) {
 	test.AssertStringContains(t, "", sendGet(t, 200, "stop", nil), "\"State\":\"STOPPING\"", "\"Executable\":\"TestExe\"", "\"Panics\":1")
+	test.AssertStringContains(t, "", sendGet(t, 503, "health/ready", headers("json", "")), "\"Status\":\"DOWN\"", "\"State\":\"STOPPING\"")
+	test.AssertStringContains(t, "", sendGet(t, 200, "health/live", headers("json", "")), "\"Status\":\"UP\"", "\"State\":\"STOPPING\"")
 	testLog.LogDebug("SHUT DOWN STARTED")
 }
 
@@ -1,6 +1,7 @@
 package exec
 
 import (
+	"context"
 	"errors"
 	"io/ioutil"
 	"os"
@@ -24,17 +25,24 @@ type CmdStatus struct {
 RunAndWait - run a command on the OS
 */
 func RunAndWait(path string, name string, data map[string]string, args ...string) *CmdStatus {
-	return run(path, name, data, args...)
+	return run(context.Background(), path, name, data, args...)
+}
+
+/*
+RunAndWaitWithContext - run a command on the OS. The command is killed if the context is cancelled (or times out) before it completes
+*/
+func RunAndWaitWithContext(ctx context.Context, path string, name string, data map[string]string, args ...string) *CmdStatus {
+	return run(ctx, path, name, data, args...)
 }
 
 /*
 RunAndCallback - run a command on the OS and call back when complete
 */
 func RunAndCallback(callback func(status *CmdStatus), path string, name string, data map[string]string, args ...string) {
-	go callback(run(path, name, data, args...))
+	go callback(run(context.Background(), path, name, data, args...))
 }
 
-func run(path string, name string, data map[string]string, args ...string) *CmdStatus {
+func run(ctx context.Context, path string, name string, data map[string]string, args ...string) *CmdStatus {
 	state := &CmdStatus{
 		Stdout:  "",
 		Stderr:  "",
@@ -47,7 +55,7 @@ func run(path string, name string, data map[string]string, args ...string) *CmdS
 		zData[index] = substitution.DoSubstitution(value, data, '$')
 	}
 
-	cmd := exec.Command(name, zData...)
+	cmd := exec.CommandContext(ctx, name, zData...)
 	if path != "" {
 		if _, err := os.Stat(path); os.IsNotExist(err) {
 			return captureError(state, errors.New("Path ["+path+"] does not exist"))
@@ -77,6 +85,9 @@ func run(path string, name string, data map[string]string, args ...string) *CmdS
 
 	err = cmd.Wait()
 	if err != nil {
+		if ctx.Err() != nil {
+			err = errors.New(err.Error() + ": " + ctx.Err().Error())
+		}
 		return captureError(state, err)
 	}
 
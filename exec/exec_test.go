@@ -1,6 +1,7 @@
 package exec
 
 import (
+	"context"
 	"runtime"
 	"strconv"
 	"testing"
@@ -40,6 +41,19 @@ func TestRunOk(t *testing.T) {
 	test.AssertStringEquals(t, "", "stdout", x.Stdout)
 }
 
+func TestRunWithContextTimeout(t *testing.T) {
+	if testOS != "windows" {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		x := RunAndWaitWithContext(ctx, "", "sleep", nil, "5")
+		test.AssertError(t, "", x.Err)
+		test.AssertIntNotEqual(t, "", x.RetCode, 0)
+		test.AssertErrorTextContains(t, "", x.Err, "context deadline exceeded")
+		test.AssertBoolTrue(t, "Should be killed before it completes", time.Since(start) < 2*time.Second)
+	}
+}
+
 func TestRunDIR(t *testing.T) {
 	if testOS == "windows" {
 		x := RunAndWait("", "cmd", nil, "/C", "dir", "c:\\Program Files")
@@ -1,3 +1,8 @@
 module github.com/stuartdd/webServerBase
 
 go 1.13
+
+require (
+	golang.org/x/crypto v0.9.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,178 @@
+package logging
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/stuartdd/webServerBase/substitution"
+)
+
+/*
+GetLogLevelNames returns the names of ALL the log levels (INFO, DEBUG, WARN, ACCESS, ERROR, FATAL)
+*/
+func GetLogLevelNames() []string {
+	names := make([]string, 0, len(logLevelDataMapKnownState))
+	for name := range logLevelDataMapKnownState {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return logLevelDataMapKnownState[names[i]].index < logLevelDataMapKnownState[names[j]].index
+	})
+	return names
+}
+
+/*
+SetLogLevel activate, deactivate or redirect a log level while the application is running.
+
+The value is the same as the config values for CreateLogWithFilenameAndAppID. For example
+OFF, SYSOUT, SYSERR, DEFAULT, JSON:SYSOUT or a file name. Log files that are no longer used by
+any level are closed.
+
+This is thread safe. A log line that another go routine started before the change is written using the previous
+definition. If that definition used a file that is closed by the change (no level uses it now) the line is discarded.
+*/
+func SetLogLevel(name string, value string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	loggerLevelTypeIndex := GetLogLevelTypeIndexForLevelName(name)
+	if loggerLevelTypeIndex == NotFound {
+		return invalidLevelNameError(name)
+	}
+	err := setLogLevel(loggerLevelTypeIndex, value)
+	if err != nil {
+		return err
+	}
+	return levelsChanged()
+}
+
+/*
+SetLogLevels change the output of ALL the named levels (see SetLogLevel). Used when the values come from a client (for example an admin endpoint).
+
+A value must be OFF, SYSOUT, SYSERR or DEFAULT (with or without a JSON: prefix) or the name of a log file that is already open.
+New files are NOT created. Every name and value is validated before any level is changed.
+*/
+func SetLogLevels(values map[string]string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for name, value := range values {
+		if GetLogLevelTypeIndexForLevelName(name) == NotFound {
+			return invalidLevelNameError(name)
+		}
+		if !isKnownLogOutput(value) {
+			return newError("The Log level '" + name + "' value '" + value + "' is not OFF, SYSOUT, SYSERR, DEFAULT or an open log file")
+		}
+	}
+	for name, value := range values {
+		err := setLogLevel(GetLogLevelTypeIndexForLevelName(name), value)
+		if err != nil {
+			return err
+		}
+	}
+	return levelsChanged()
+}
+
+/*
+isKnownLogOutput returns true if the value does not create a new file. Must be called with the mutex locked!
+*/
+func isKnownLogOutput(value string) bool {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(strings.ToUpper(value), jsonPrefix) {
+		value = strings.TrimSpace(value[len(jsonPrefix):])
+	}
+	switch strings.ToUpper(value) {
+	case offName, "", systemOutName, systemErrName, defaultName:
+		/*
+			DEFAULT may open the configured default log file. That is not a client supplied name
+		*/
+		return true
+	}
+	return isOpenLogFile(value)
+}
+
+/*
+isOpenLogFile returns true if the file name (before or after substitution) is already in use by a level
+*/
+func isOpenLogFile(fileName string) bool {
+	logFileName := substitution.DoSubstitution(fileName, map[string]string{"ID": logApplicationID}, '$')
+	if _, ok := logLevelFileMap[strings.TrimSpace(strings.ToUpper(logFileName))]; ok {
+		return true
+	}
+	absFileName, err := filepath.Abs(logFileName)
+	if err != nil {
+		return false
+	}
+	for _, lfd := range logLevelFileMap {
+		if lfd.fileName == absFileName {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+setLogLevel replace the level with a new instance configured with the value. Must be called with the mutex locked!
+*/
+func setLogLevel(loggerLevelTypeIndex LoggerLevelTypeIndex, value string) error {
+	current := levelData(loggerLevelTypeIndex)
+	/*
+		Configure a new instance so the current one is never changed
+	*/
+	lld := &logLevelData{
+		paddedName:   current.paddedName,
+		index:        current.index,
+		note:         current.note,
+		active:       false,
+		isErrorLevel: current.isErrorLevel,
+	}
+	err := activateLogLevel(lld, value)
+	if err != nil {
+		return err
+	}
+	levelsMutex.Lock()
+	levels := make([]*logLevelData, len(logLevelDataIndexList))
+	copy(levels, logLevelDataIndexList)
+	levels[loggerLevelTypeIndex] = lld
+	logLevelDataIndexList = levels
+	levelsMutex.Unlock()
+	return nil
+}
+
+/*
+levelsChanged updates the module levels and closes unused files. Must be called with the mutex locked!
+*/
+func levelsChanged() error {
+	/*
+		Modules with their own levels may use the level that changed
+	*/
+	err := resolveModuleLevels()
+	closeUnusedLogFiles()
+	return err
+}
+
+/*
+closeUnusedLogFiles closes files that are not used by any level (global or module). Must be called with the mutex locked!
+A write to a closed file (from a go routine that read the previous level definition) returns os.ErrClosed and the line is discarded.
+*/
+func closeUnusedLogFiles() {
+	levelsMutex.RLock()
+	used := make(map[*logLevelFileData]bool)
+	for _, lld := range logLevelDataIndexList {
+		used[lld.file] = true
+	}
+	for _, module := range logDataModules {
+		for _, lld := range module.levels {
+			used[lld.file] = true
+		}
+	}
+	levelsMutex.RUnlock()
+	for key, lfd := range logLevelFileMap {
+		if !used[lfd] {
+			if lfd.logFile != nil {
+				lfd.logFile.Close()
+				lfd.logFile = nil
+			}
+			delete(logLevelFileMap, key)
+		}
+	}
+}
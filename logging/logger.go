@@ -21,6 +21,7 @@ const systemErrName = "SYSERR"
 const systemOutName = "SYSOUT"
 const defaultName = "DEFAULT"
 const offName = "OFF"
+const jsonPrefix = "JSON:"
 
 /*
 LoggerLevelTypeIndex ENUM for log levels. Used to index in to lists
@@ -71,18 +72,26 @@ type logLevelData struct {
 	isErrorLevel bool                 // Is it or error or fatal log as these are active by default
 	logger       *log.Logger          // The actual (wrapped) logger imported via "log"
 	file         *logLevelFileData    // If the is a file associated with the log
+	json         bool                 // Write each line as a JSON object
 }
 
 /*
-Map - Key is the Log level name, value is the definition (configuration) of that log
-This is populated from logLevelDataMapKnownState map in
+List - Indexed by LoggerLevelTypeIndex, value is the definition (configuration) of that log
+This is populated from logLevelDataMapKnownState map in startFromKnownState.
+
+A logLevelData is NOT changed once it is in the list. It is replaced (see SetLogLevel) so
+the log functions can read it via levelData without holding a lock while they write.
 */
-var logLevelDataMap map[string]*logLevelData
 var logLevelDataIndexList []*logLevelData
+var levelsMutex = &sync.RWMutex{}
 
 type logLevelFileData struct {
-	fileName string   // The file name from the config data (used in map logLevelFileMap).
-	logFile  *os.File // The actual file reference
+	fileName        string    // The file name from the config data (used in map logLevelFileMap).
+	logFile         *os.File  // The actual file reference
+	size            int64     // The size of the file. Used for rotation
+	openedAt        time.Time // When the file was opened. Used for daily rotation
+	compressQueue   []string  // Rotated files waiting to be compressed. The first is being compressed
+	compressRunning bool      // True if the go routine that compresses the queue is running
 }
 
 /*
@@ -96,8 +105,9 @@ LoggerDataReference contains a ref to the single (named) logger instance and the
 Created via NewLogger
 */
 type LoggerDataReference struct {
-	loggerModuleName string // The name of the model (used in map logDataModules)
-	loggerPrefix     string // Cached prefix for log lines throught this model
+	loggerModuleName string          // The name of the model (used in map logDataModules)
+	loggerPrefix     string          // Cached prefix for log lines throught this model
+	levels           []*logLevelData // The levels for this module (see SetModuleLevels). nil uses the global levels
 }
 
 /*
@@ -130,7 +140,7 @@ CreateTestLogger - creates a logger for testing
 */
 func CreateTestLogger(id string) *LoggerDataReference {
 	levels := make(map[string]string)
-	for name := range logLevelDataMap {
+	for name := range logLevelDataMapKnownState {
 		levels[name] = systemOutName
 	}
 	CreateLogWithFilenameAndAppID("", "TestLogger", -1, levels)
@@ -153,7 +163,7 @@ func CreateLogWithFilenameAndAppID(defaultLogFileNameIn string, applicationID st
 	defaultLogFileName = defaultLogFileNameIn
 	logApplicationID = applicationID
 	fatalRC = fatalRCIn
-	startFromKnownState()
+	levels := startFromKnownState()
 	/*
 		Validate and Activate each log level.
 		We MUST activate Error and Fatal so add them to the inINPUTput list if not already defined
@@ -164,7 +174,8 @@ func CreateLogWithFilenameAndAppID(defaultLogFileNameIn string, applicationID st
 	if logLevelActivationData[fatalName] == "" {
 		logLevelActivationData[fatalName] = defaultName
 	}
-	err := validateAndActivateLogLevels(logLevelActivationData)
+	err := validateAndActivateLogLevels(logLevelActivationData, levels)
+	publishLogLevels(levels)
 	if err != nil {
 		fmt.Printf("FALLBACK:Logging is in Fallback mode) Create Failed with error: %s\n" + err.Error())
 		return err
@@ -184,7 +195,7 @@ func IsFallback() bool {
 GetLogLevelTypeIndexForLevelName get the index for the level name
 */
 func GetLogLevelTypeIndexForLevelName(name string) LoggerLevelTypeIndex {
-	value := logLevelDataMap[strings.ToUpper(strings.TrimSpace(name))]
+	value := logLevelDataMapKnownState[strings.ToUpper(strings.TrimSpace(name))]
 	if value == nil {
 		return NotFound
 	}
@@ -195,10 +206,11 @@ func GetLogLevelTypeIndexForLevelName(name string) LoggerLevelTypeIndex {
 GetLogLevelFileNameForLevelName get the file name for the level name
 */
 func GetLogLevelFileNameForLevelName(name string) string {
-	value, ok := logLevelDataMap[strings.ToUpper(strings.TrimSpace(name))]
-	if !ok {
+	loggerLevelTypeIndex := GetLogLevelTypeIndexForLevelName(name)
+	if loggerLevelTypeIndex == NotFound {
 		return ""
 	}
+	value := levelData(loggerLevelTypeIndex)
 	if value.file == nil {
 		return ""
 	}
@@ -209,10 +221,14 @@ func GetLogLevelFileNameForLevelName(name string) string {
 CloseLog close ALL the log files
 */
 func CloseLog() {
-	for _, value := range logLevelDataMap {
+	levelsMutex.Lock()
+	defer levelsMutex.Unlock()
+	for index, value := range logLevelDataIndexList {
 		if value.file != nil {
 			value.file.logFile.Close()
-			value.active = false
+			closed := *value
+			closed.active = false
+			logLevelDataIndexList[index] = &closed
 		}
 	}
 }
@@ -232,52 +248,62 @@ func NewLogger(moduleName string) *LoggerDataReference {
 	ldRef := &LoggerDataReference{
 		loggerModuleName: moduleName,
 		loggerPrefix:     "",
+		levels:           nil,
 	}
 	logDataModules[moduleName] = ldRef
 	updateLoggerPrefixesForAllModules()
+	if _, ok := logModuleLevels[moduleName]; ok {
+		mutex.Lock()
+		err := resolveModuleLevels()
+		mutex.Unlock()
+		if err != nil {
+			LogDirectToSystemError("Logging: Module "+moduleName+" levels failed: "+err.Error(), false)
+		}
+	}
 	return ldRef
 }
 
 /*
 IsDebug return true is the debug log function is enabled
+For a specific module (see SetModuleLevels) use logger.IsDebug()
 */
 func IsDebug() bool {
-	return logLevelDataIndexList[DebugLevel].active
+	return levelData(DebugLevel).active
 }
 
 /*
 IsAccess return true is the access log function is enabled
 */
 func IsAccess() bool {
-	return logLevelDataIndexList[AccessLevel].active
+	return levelData(AccessLevel).active
 }
 
 /*
 IsInfo return true is the info log function is enabled
 */
 func IsInfo() bool {
-	return logLevelDataIndexList[InfoLevel].active
+	return levelData(InfoLevel).active
 }
 
 /*
 IsError return true is the error log function is enabled
 */
 func IsError() bool {
-	return logLevelDataIndexList[ErrorLevel].active
+	return levelData(ErrorLevel).active
 }
 
 /*
 IsFatal return true is the fatal log function is enabled
 */
 func IsFatal() bool {
-	return logLevelDataIndexList[FatalLevel].active
+	return levelData(FatalLevel).active
 }
 
 /*
 IsWarn return true is the warn log function is enabled
 */
 func IsWarn() bool {
-	return logLevelDataIndexList[WarnLevel].active
+	return levelData(WarnLevel).active
 }
 
 /*
@@ -291,8 +317,13 @@ func (p *LoggerDataReference) Fatal(err error) {
 	if fallBack {
 		fmt.Printf("FALLBACK:FATAL: type[%T] %s\n", err, err.Error())
 	} else {
-		if logLevelDataIndexList[FatalLevel].active && isEnabled() {
-			logLevelDataIndexList[FatalLevel].logger.Printf(p.loggerPrefix+"[%s] %T %s", logLevelDataIndexList[FatalLevel].paddedName, err, err.Error())
+		lld := p.levelData(FatalLevel)
+		if lld.active && isEnabled() {
+			if lld.json {
+				p.write(FatalLevel, fmt.Sprintf("%T %s", err, err.Error()), nil)
+			} else {
+				lld.logger.Printf(p.loggerPrefix+"[%s] %T %s", lld.paddedName, err, err.Error())
+			}
 		} else {
 			fmt.Printf("FATAL: type[%T] %s\n", err, err.Error())
 		}
@@ -312,8 +343,8 @@ func (p *LoggerDataReference) LogErrorf(format string, v ...interface{}) {
 		fmt.Printf("FALLBACK:ERROR: "+format+"\n", v...)
 		return
 	}
-	if logLevelDataIndexList[ErrorLevel].active && isEnabled() {
-		logLevelDataIndexList[ErrorLevel].logger.Printf(p.loggerPrefix+logLevelDataIndexList[ErrorLevel].paddedName+format, v...)
+	if p.levelData(ErrorLevel).active && isEnabled() {
+		p.write(ErrorLevel, fmt.Sprintf(format, v...), nil)
 	}
 }
 
@@ -327,8 +358,8 @@ func (p *LoggerDataReference) LogError(message error) {
 		fmt.Println("FALLBACK:ERROR: " + message.Error())
 		return
 	}
-	if logLevelDataIndexList[ErrorLevel].active && isEnabled() {
-		logLevelDataIndexList[ErrorLevel].logger.Print(p.loggerPrefix + logLevelDataIndexList[ErrorLevel].paddedName + message.Error())
+	if p.levelData(ErrorLevel).active && isEnabled() {
+		p.write(ErrorLevel, message.Error(), nil)
 	}
 }
 
@@ -342,8 +373,8 @@ func (p *LoggerDataReference) LogInfof(format string, v ...interface{}) {
 		fmt.Printf("FALLBACK:INFO: "+format+"\n", v...)
 		return
 	}
-	if logLevelDataIndexList[InfoLevel].active && isEnabled() {
-		logLevelDataIndexList[InfoLevel].logger.Printf(p.loggerPrefix+logLevelDataIndexList[InfoLevel].paddedName+format, v...)
+	if p.levelData(InfoLevel).active && isEnabled() {
+		p.write(InfoLevel, fmt.Sprintf(format, v...), nil)
 	}
 }
 
@@ -357,8 +388,8 @@ func (p *LoggerDataReference) LogInfo(message string) {
 		fmt.Println("FALLBACK:INFO: " + message)
 		return
 	}
-	if logLevelDataIndexList[InfoLevel].active && isEnabled() {
-		logLevelDataIndexList[InfoLevel].logger.Print(p.loggerPrefix + logLevelDataIndexList[InfoLevel].paddedName + message)
+	if p.levelData(InfoLevel).active && isEnabled() {
+		p.write(InfoLevel, message, nil)
 	}
 }
 
@@ -372,8 +403,8 @@ func (p *LoggerDataReference) LogAccessf(format string, v ...interface{}) {
 		fmt.Printf("FALLBACK:ACCESS: "+format+"\n", v...)
 		return
 	}
-	if logLevelDataIndexList[AccessLevel].active && isEnabled() {
-		logLevelDataIndexList[AccessLevel].logger.Printf(p.loggerPrefix+logLevelDataIndexList[AccessLevel].paddedName+format, v...)
+	if p.levelData(AccessLevel).active && isEnabled() {
+		p.write(AccessLevel, fmt.Sprintf(format, v...), nil)
 	}
 }
 
@@ -387,8 +418,8 @@ func (p *LoggerDataReference) LogAccess(message string) {
 		fmt.Println("FALLBACK:ACCESS: " + message)
 		return
 	}
-	if logLevelDataIndexList[AccessLevel].active && isEnabled() {
-		logLevelDataIndexList[AccessLevel].logger.Print(p.loggerPrefix + logLevelDataIndexList[AccessLevel].paddedName + message)
+	if p.levelData(AccessLevel).active && isEnabled() {
+		p.write(AccessLevel, message, nil)
 	}
 }
 
@@ -402,8 +433,8 @@ func (p *LoggerDataReference) LogWarnf(format string, v ...interface{}) {
 		fmt.Printf("FALLBACK:WARN: "+format+"\n", v...)
 		return
 	}
-	if logLevelDataIndexList[WarnLevel].active && isEnabled() {
-		logLevelDataIndexList[WarnLevel].logger.Printf(p.loggerPrefix+logLevelDataIndexList[WarnLevel].paddedName+format, v...)
+	if p.levelData(WarnLevel).active && isEnabled() {
+		p.write(WarnLevel, fmt.Sprintf(format, v...), nil)
 	}
 }
 
@@ -417,8 +448,8 @@ func (p *LoggerDataReference) LogWarn(message string) {
 		fmt.Println("FALLBACK:WARN: " + message)
 		return
 	}
-	if logLevelDataIndexList[WarnLevel].active && isEnabled() {
-		logLevelDataIndexList[WarnLevel].logger.Print(p.loggerPrefix + logLevelDataIndexList[WarnLevel].paddedName + message)
+	if p.levelData(WarnLevel).active && isEnabled() {
+		p.write(WarnLevel, message, nil)
 	}
 }
 
@@ -432,8 +463,8 @@ func (p *LoggerDataReference) LogDebugf(format string, v ...interface{}) {
 		fmt.Printf("FALLBACK:DEBUG: "+format+"\n", v...)
 		return
 	}
-	if logLevelDataIndexList[DebugLevel].active && isEnabled() {
-		logLevelDataIndexList[DebugLevel].logger.Printf(p.loggerPrefix+logLevelDataIndexList[DebugLevel].paddedName+format, v...)
+	if p.levelData(DebugLevel).active && isEnabled() {
+		p.write(DebugLevel, fmt.Sprintf(format, v...), nil)
 	}
 }
 
@@ -447,8 +478,8 @@ func (p *LoggerDataReference) LogDebug(message string) {
 		fmt.Println("FALLBACK:DEBUG: " + message)
 		return
 	}
-	if logLevelDataIndexList[DebugLevel].active && isEnabled() {
-		logLevelDataIndexList[DebugLevel].logger.Print(p.loggerPrefix + logLevelDataIndexList[DebugLevel].paddedName + message)
+	if p.levelData(DebugLevel).active && isEnabled() {
+		p.write(DebugLevel, message, nil)
 	}
 }
 
@@ -456,19 +487,38 @@ func (p *LoggerDataReference) LogDebug(message string) {
 LogErrorWithStackTrace - Log an error with a prefix and a stack trace. Each line of the stacktrace has the prefix.
 */
 func (p *LoggerDataReference) LogErrorWithStackTrace(txid string, prefix string, message string) {
+	p.LogErrorWithStack(txid, prefix, message, debug.Stack())
+}
+
+/*
+LogErrorWithStack - Log an error with a prefix and a stack trace that was captured earlier using debug.Stack.
+For example in the go routine that panicked. Each line of the stacktrace has the prefix.
+*/
+func (p *LoggerDataReference) LogErrorWithStack(txid string, prefix string, message string, stackTrace []byte) {
 	if fallBack {
-		fmt.Println("FALLBACK:ERROR: " + prefix + " " + message + "\n" + string(debug.Stack()))
+		fmt.Println("FALLBACK:ERROR: " + prefix + " " + message + "\n" + string(stackTrace))
 		return
 	}
-	prefix = "ID: " + txid + " " + prefix
-	if logLevelDataIndexList[ErrorLevel].active && isEnabled() {
-		logLevelDataIndexList[ErrorLevel].logger.Print(p.loggerPrefix + logLevelDataIndexList[ErrorLevel].paddedName + prefix + " " + message)
-		st := string(debug.Stack())
+	if p.levelData(ErrorLevel).active && isEnabled() {
+		stack := []string{}
+		st := string(stackTrace)
 		for count, line := range strings.Split(strings.TrimSuffix(st, "\n"), "\n") {
 			if count > 6 && count <= 18 {
-				logLevelDataIndexList[ErrorLevel].logger.Print(p.loggerPrefix + logLevelDataIndexList[ErrorLevel].paddedName + prefix + " " + line)
+				stack = append(stack, line)
 			}
 		}
+		/*
+			In JSON mode the stack trace is a field of a single record
+		*/
+		if p.levelData(ErrorLevel).json {
+			p.write(ErrorLevel, prefix+" "+message, Fields{"txid": txid, "stack": stack})
+			return
+		}
+		prefix = "ID: " + txid + " " + prefix
+		p.write(ErrorLevel, prefix+" "+message, nil)
+		for _, line := range stack {
+			p.write(ErrorLevel, prefix+" "+line, nil)
+		}
 	}
 }
 
@@ -478,28 +528,42 @@ LoggerLevelDataString return the state of a log level as a string. UIsefull for
 func LoggerLevelDataString(name string) string {
 	loggerLevelTypeIndex := GetLogLevelTypeIndexForLevelName(name)
 	if loggerLevelTypeIndex != NotFound {
-		lld := logLevelDataIndexList[loggerLevelTypeIndex]
-		errorLevel := "NO"
-		if lld.isErrorLevel {
-			errorLevel = "YES"
-		}
-		if lld.active {
-			active := name + ":Active note[" + lld.note + "] error[" + errorLevel + "]:"
-			if lld.file == nil {
-				return active + "Out=Console:"
-			}
-			active = active + "Out=:" + filepath.Base(lld.file.fileName)
-			if lld.file.logFile == nil {
-				return active + ":Closed"
-			}
-			return active + ":Open"
+		return levelDataString(name, levelData(loggerLevelTypeIndex))
+	}
+	return name + ":Not Found"
+}
 
-		}
-		return name + ":In-Active note[" + lld.note + "] error[" + errorLevel + "]"
+/*
+LoggerLevelDataString return the state of a log level for this module as a string (see SetModuleLevels)
+*/
+func (p *LoggerDataReference) LoggerLevelDataString(name string) string {
+	loggerLevelTypeIndex := GetLogLevelTypeIndexForLevelName(name)
+	if loggerLevelTypeIndex != NotFound {
+		return levelDataString(name, p.levelData(loggerLevelTypeIndex))
 	}
 	return name + ":Not Found"
 }
 
+func levelDataString(name string, lld *logLevelData) string {
+	errorLevel := "NO"
+	if lld.isErrorLevel {
+		errorLevel = "YES"
+	}
+	if lld.active {
+		active := name + ":Active note[" + lld.note + "] error[" + errorLevel + "]:"
+		if lld.file == nil {
+			return active + "Out=Console:"
+		}
+		active = active + "Out=:" + filepath.Base(lld.file.fileName)
+		if lld.file.logFile == nil {
+			return active + ":Closed"
+		}
+		return active + ":Open"
+
+	}
+	return name + ":In-Active note[" + lld.note + "] error[" + errorLevel + "]"
+}
+
 /*
 LogDirectToSystemError - Log to the syserr channel. Optionally ad a stach trace!
 */
@@ -515,15 +579,18 @@ func LogDirectToSystemError(msg string, withTrace bool) {
 	l.Println(b.String())
 }
 
-func startFromKnownState() {
+/*
+startFromKnownState returns a new list of (in-active) levels. See publishLogLevels.
+*/
+func startFromKnownState() []*logLevelData {
 	/*
 		Make sure the lists and maps are empty first
 	*/
 	CloseLog()
+	clearModuleLevels()
 	logDataModules = make(map[string]*LoggerDataReference)
 	logLevelFileMap = make(map[string]*logLevelFileData)
-	logLevelDataMap = make(map[string]*logLevelData)
-	logLevelDataIndexList = []*logLevelData{}
+	logRotation = nil
 	/*
 		Find the longest name
 	*/
@@ -534,10 +601,20 @@ func startFromKnownState() {
 		}
 	}
 	/*
-		Create each logLevelData from each logLevelDataKnownState
+		Create a list of empty (nil) values the right size.
+	*/
+	levels := make([]*logLevelData, len(logLevelDataMapKnownState))
+	/*
+		Create each logLevelData from each logLevelDataKnownState and insert it in the correct slot. Duplicate entries will cause a panic
+
+		Note they may not be in the right sequence (it depends on values in logLevelDataMapKnownState)
+			so we need to use the value.index to make sure order is maintained!
 	*/
 	for name, value := range logLevelDataMapKnownState {
-		logLevelDataMap[name] = &logLevelData{
+		if levels[value.index] != nil {
+			panic("Duplicate index value in logLevelDataMapKnownState[" + name + "]")
+		}
+		levels[value.index] = &logLevelData{
 			paddedName:   padName(name, longest),
 			index:        value.index,
 			note:         value.note,
@@ -545,25 +622,25 @@ func startFromKnownState() {
 			isErrorLevel: value.isErrorLevel,
 		}
 	}
-	/*
-		Create a list of empty (nil) values the right size.
-	*/
-	for i := 0; i < len(logLevelDataMap); i++ {
-		logLevelDataIndexList = append(logLevelDataIndexList, nil)
-	}
-	/*
-		Insert each logLevelData in the correct slot. Duplicate entries will cause a panic
+	return levels
+}
 
-		Note they may not be in the right sequence (it depends on values in logLevelDataMapKnownState)
-			so we need to use the value.index from the logLevelData to make sure order is maintained!
-	*/
-	for name, value := range logLevelDataMap {
-		if logLevelDataIndexList[value.index] == nil {
-			logLevelDataIndexList[value.index] = value
-		} else {
-			panic("Duplicate index value in logLevelDataMapKnownState[" + name + "]")
-		}
-	}
+/*
+publishLogLevels replaces ALL the levels used by the log functions
+*/
+func publishLogLevels(levels []*logLevelData) {
+	levelsMutex.Lock()
+	defer levelsMutex.Unlock()
+	logLevelDataIndexList = levels
+}
+
+/*
+levelData returns the current definition of a level. It must not be changed!
+*/
+func levelData(index LoggerLevelTypeIndex) *logLevelData {
+	levelsMutex.RLock()
+	defer levelsMutex.RUnlock()
+	return logLevelDataIndexList[index]
 }
 
 func isEnabled() bool {
@@ -587,7 +664,7 @@ func padName(name string, longest int) string {
 /*
 	Validate and Activate each log level.
 */
-func validateAndActivateLogLevels(values map[string]string) error {
+func validateAndActivateLogLevels(values map[string]string, levels []*logLevelData) error {
 	/*
 		For each log level definition
 	*/
@@ -596,78 +673,99 @@ func validateAndActivateLogLevels(values map[string]string) error {
 			check the name is valid
 		*/
 		loggerLevelTypeIndex := GetLogLevelTypeIndexForLevelName(key)
-		if loggerLevelTypeIndex != NotFound {
+		if loggerLevelTypeIndex == NotFound {
+			return invalidLevelNameError(key)
+		}
+		err := activateLogLevel(levels[loggerLevelTypeIndex], value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+activateLogLevel configure a level according to the value (case insensitive). For example OFF, SYSOUT, SYSERR, DEFAULT or a file name.
+*/
+func activateLogLevel(loggerLevelDataValue *logLevelData, value string) error {
+	/*
+		A JSON: prefix selects JSON output for the level. For example "JSON:SYSOUT" or "JSON:app.log"
+	*/
+	jsonFormat := false
+	if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(value)), jsonPrefix) {
+		jsonFormat = true
+		value = strings.TrimSpace(value)[len(jsonPrefix):]
+	}
+	/*
+		Note the value can be a file name!
+	*/
+	valueUC := strings.TrimSpace(strings.ToUpper(value))
+	switch valueUC {
+	case offName, "":
+		loggerLevelDataValue.active = false
+		loggerLevelDataValue.note = valueUC
+		break
+	case systemOutName:
+		loggerLevelDataValue.logger = log.New(os.Stdout, "", logDataFlags)
+		loggerLevelDataValue.active = true
+		loggerLevelDataValue.note = valueUC
+		break
+	case systemErrName:
+		loggerLevelDataValue.logger = log.New(os.Stderr, "", logDataFlags)
+		loggerLevelDataValue.active = true
+		loggerLevelDataValue.note = valueUC
+		break
+	case defaultName:
+		/*
+			For default we use the the default file name
+		*/
+		if defaultLogFileName == "" {
 			/*
-				Configure the level according to the value in the map (case insensitive).
-				Note the value can be a file name!
+				If default file name is undefined then choose stderr or stdout according to isErrorLevel
 			*/
-			loggerLevelDataValue := logLevelDataIndexList[loggerLevelTypeIndex]
-			valueUC := strings.TrimSpace(strings.ToUpper(value))
-			switch valueUC {
-			case offName, "":
-				loggerLevelDataValue.active = false
-				loggerLevelDataValue.note = valueUC
-				break
-			case systemOutName:
-				loggerLevelDataValue.logger = log.New(os.Stdout, "", logDataFlags)
-				loggerLevelDataValue.active = true
-				loggerLevelDataValue.note = valueUC
-				break
-			case systemErrName:
+			if loggerLevelDataValue.isErrorLevel {
 				loggerLevelDataValue.logger = log.New(os.Stderr, "", logDataFlags)
-				loggerLevelDataValue.active = true
-				loggerLevelDataValue.note = valueUC
-				break
-			case defaultName:
-				/*
-					For default we use the the default file name
-				*/
-				if defaultLogFileName == "" {
-					/*
-						If default file name is undefined then choose stderr or stdout according to isErrorLevel
-					*/
-					if logLevelDataIndexList[GetLogLevelTypeIndexForLevelName(key)].isErrorLevel {
-						loggerLevelDataValue.logger = log.New(os.Stderr, "", logDataFlags)
-						loggerLevelDataValue.note = systemErrName
-					} else {
-						if logLevelDataIndexList[GetLogLevelTypeIndexForLevelName(key)].isErrorLevel {
-							loggerLevelDataValue.logger = log.New(os.Stdout, "", logDataFlags)
-							loggerLevelDataValue.note = systemOutName
-						}
-					}
-				} else {
-					logFileData, err := getLogLevelFileDataForFilename(defaultLogFileName)
-					if err != nil {
-						return err
-					}
-					loggerLevelDataValue.file = logFileData
-					loggerLevelDataValue.logger = log.New(logFileData.logFile, "", logDataFlags)
-					loggerLevelDataValue.note = valueUC
-				}
-				loggerLevelDataValue.active = true
-				break
-			default:
-				logFileData, err := getLogLevelFileDataForFilename(value)
-				if err != nil {
-					return err
-				}
-				loggerLevelDataValue.file = logFileData
-				loggerLevelDataValue.logger = log.New(logFileData.logFile, "", logDataFlags)
-				loggerLevelDataValue.active = true
-				loggerLevelDataValue.note = "FILE"
+				loggerLevelDataValue.note = systemErrName
+			} else {
+				loggerLevelDataValue.logger = log.New(os.Stdout, "", logDataFlags)
+				loggerLevelDataValue.note = systemOutName
 			}
 		} else {
-			var b bytes.Buffer
-			for name := range logLevelDataMap {
-				b.WriteString(name)
-				b.WriteString(", ")
+			logFileData, err := getLogLevelFileDataForFilename(defaultLogFileName)
+			if err != nil {
+				return err
 			}
-			return newError("The Log level name '" + key + "' is not a valid log level. Valid values are:" + b.String()[0:b.Len()-2])
+			loggerLevelDataValue.file = logFileData
+			loggerLevelDataValue.logger = log.New(logFileData, "", logDataFlags)
+			loggerLevelDataValue.note = valueUC
+		}
+		loggerLevelDataValue.active = true
+		break
+	default:
+		logFileData, err := getLogLevelFileDataForFilename(value)
+		if err != nil {
+			return err
 		}
+		loggerLevelDataValue.file = logFileData
+		loggerLevelDataValue.logger = log.New(logFileData, "", logDataFlags)
+		loggerLevelDataValue.active = true
+		loggerLevelDataValue.note = "FILE"
+	}
+	/*
+		JSON lines contain their own timestamp so the log.Logger flags are not used
+	*/
+	loggerLevelDataValue.json = jsonFormat && loggerLevelDataValue.active
+	if loggerLevelDataValue.json && loggerLevelDataValue.logger != nil {
+		loggerLevelDataValue.logger.SetFlags(0)
+		loggerLevelDataValue.note = jsonPrefix + loggerLevelDataValue.note
 	}
 	return nil
 }
 
+func invalidLevelNameError(name string) error {
+	return newError("The Log level name '" + name + "' is not a valid log level. Valid values are:" + strings.Join(GetLogLevelNames(), ", "))
+}
+
 func updateLoggerPrefixesForAllModules() {
 	longestName := 0
 	for _, value := range logDataModules {
@@ -697,14 +795,21 @@ func getLogLevelFileDataForFilename(logFileNameUnresolved string) (*logLevelFile
 	if err != nil {
 		return nil, newError("applicationID " + logApplicationID + ". Log file " + logFileName + " is not a valid file path: " + err.Error())
 	}
-	f, err := os.OpenFile(absFileName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	/*
+		The log.Logger for each level writes via logLevelFileData so the file can be rotated (see rotation.go)
+	*/
+	lfd := &logLevelFileData{
+		fileName:        absFileName,
+		logFile:         nil,
+		size:            0,
+		openedAt:        time.Time{},
+		compressQueue:   []string{},
+		compressRunning: false,
+	}
+	err = lfd.open()
 	if err != nil {
 		return nil, newError("applicationID " + logApplicationID + ". Log file " + logFileName + " could NOT be Created or Opened: " + err.Error())
 	}
-	lfd := &logLevelFileData{
-		fileName: absFileName,
-		logFile:  f,
-	}
 	logLevelFileMap[nameUcTrim] = lfd
 	return lfd, nil
 }
@@ -1,14 +1,18 @@
 package logging
 
 import (
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"runtime/debug"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stuartdd/webServerBase/test"
 )
@@ -310,3 +314,350 @@ func checkPanicIsThrown(t *testing.T, desc string) {
 	}
 	t.Fatalf("\nTEST did not panic:\nThe test MUST panic with error message containing:'%s'", desc)
 }
+
+func TestJSONLogLevels(t *testing.T) {
+	levels := make(map[string]string)
+	levels["INFO"] = "json:js.log"
+	levels["WARN"] = "js.log"
+	levels["ERROR"] = "JSON:js.log"
+	CreateLogWithFilenameAndAppID("", "AppID", -1, levels)
+	fileName := GetLogLevelFileNameForLevelName("INFO")
+	defer test.AssertFileRemoved(t, "", fileName)
+	defer CloseLog()
+
+	test.AssertStringEquals(t, "", "INFO:Active note[JSON:FILE] error[NO]:Out=:js.log:Open", LoggerLevelDataString("INFO"))
+	test.AssertStringEquals(t, "", "WARN:Active note[FILE] error[NO]:Out=:js.log:Open", LoggerLevelDataString("WARN"))
+	tj := NewLogger("TJ")
+	tj.LogInfof("Info %d", 1)
+	tj.LogInfoFields("With fields", Fields{"user": "bob", "count": 2})
+	tj.LogWarnFields("Text fields", Fields{"user": "bob smith", "count": 2})
+	tj.LogErrorWithStackTrace("TX1", "!!!", "Failed")
+
+	content, err := ioutil.ReadFile(fileName)
+	test.AssertErrorIsNil(t, "", err)
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	test.AssertIntEqual(t, "One line per record", len(lines), 4)
+	test.AssertStringContains(t, "", lines[0], "\"level\":\"INFO\",\"app\":\"AppID\",\"module\":\"TJ\",\"msg\":\"Info 1\"}")
+	test.AssertStringContains(t, "", lines[1], "\"msg\":\"With fields\",\"fields\":{\"count\":2,\"user\":\"bob\"}}")
+	test.AssertStringContains(t, "", lines[2], "AppID TJ [-]   WARN Text fields count=2 user=\"bob smith\"")
+	test.AssertStringContains(t, "", lines[3], "\"level\":\"ERROR\"", "\"msg\":\"!!! Failed\"", "\"txid\":\"TX1\"", "\"stack\":[")
+	record := make(map[string]interface{})
+	err = json.Unmarshal([]byte(lines[1]), &record)
+	test.AssertErrorIsNil(t, "", err)
+	_, err = time.Parse(time.RFC3339Nano, record["time"].(string))
+	test.AssertErrorIsNil(t, "", err)
+}
+
+func TestRotateBySizeSharedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	test.AssertErrorIsNil(t, "", err)
+	defer os.RemoveAll(dir)
+	levels := make(map[string]string)
+	levels["INFO"] = filepath.Join(dir, "r.log")
+	levels["WARN"] = filepath.Join(dir, "r.log")
+	CreateLogWithFilenameAndAppID("", "AppID", -1, levels)
+	defer CloseLog()
+	SetLogRotation(&LogRotation{MaxSizeMB: 1, Keep: 2})
+
+	tr := NewLogger("TR")
+	line := strings.Repeat("x", 400*1024)
+	for i := 0; i < 8; i++ {
+		if i%2 == 0 {
+			tr.LogInfo(line)
+		} else {
+			tr.LogWarn(line)
+		}
+	}
+	tr.LogWarn("LAST")
+	rotated, _ := filepath.Glob(filepath.Join(dir, "r.*.log"))
+	test.AssertIntEqual(t, "Keep 2", len(rotated), 2)
+	/*
+		The newest files are kept. All rotations happen in the same second so the names have a count suffix.
+	*/
+	for _, name := range rotated {
+		test.AssertBoolTrue(t, "Newest kept: "+name, strings.Contains(name, "-1.log") || strings.Contains(name, "-2.log"))
+	}
+	info, err := os.Stat(filepath.Join(dir, "r.log"))
+	test.AssertErrorIsNil(t, "", err)
+	test.AssertBoolTrue(t, "Current file within limit", info.Size() <= 1024*1024)
+	test.AssertFileContains(t, "", filepath.Join(dir, "r.log"), "WARN LAST")
+}
+
+func TestRotateDailyCompressed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	test.AssertErrorIsNil(t, "", err)
+	defer os.RemoveAll(dir)
+	yesterday := time.Now().AddDate(0, 0, -1)
+	timeNow = func() time.Time { return yesterday }
+	defer func() { timeNow = time.Now }()
+
+	levels := make(map[string]string)
+	levels["INFO"] = filepath.Join(dir, "d.log")
+	CreateLogWithFilenameAndAppID("", "AppID", -1, levels)
+	defer CloseLog()
+	SetLogRotation(&LogRotation{Daily: true, Compress: true})
+
+	td := NewLogger("TD")
+	td.LogInfo("YESTERDAY")
+	td.LogInfo("STILL YESTERDAY")
+	timeNow = time.Now
+	td.LogInfo("TODAY")
+	compressing.Wait()
+
+	zipName := filepath.Join(dir, "d."+yesterday.Format(rotatedNameFormat)+".log.gz")
+	test.AssertFileExists(t, "", zipName)
+	test.AssertFileNotExists(t, "", strings.TrimSuffix(zipName, ".gz"))
+	f, err := os.Open(zipName)
+	test.AssertErrorIsNil(t, "", err)
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	test.AssertErrorIsNil(t, "", err)
+	content, err := ioutil.ReadAll(zr)
+	test.AssertErrorIsNil(t, "", err)
+	test.AssertStringContains(t, "", string(content), "INFO YESTERDAY", "INFO STILL YESTERDAY")
+	test.AssertFileContains(t, "", filepath.Join(dir, "d.log"), "INFO TODAY")
+	test.AssertFileDoesNotContain(t, "", filepath.Join(dir, "d.log"), "YESTERDAY")
+}
+
+func TestRotateCompressedKeep(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	test.AssertErrorIsNil(t, "", err)
+	defer os.RemoveAll(dir)
+	start := time.Now().AddDate(0, 0, -5)
+	day := start
+	timeNow = func() time.Time { return day }
+	defer func() { timeNow = time.Now }()
+
+	levels := make(map[string]string)
+	levels["INFO"] = filepath.Join(dir, "k.log")
+	CreateLogWithFilenameAndAppID("", "AppID", -1, levels)
+	defer CloseLog()
+	SetLogRotation(&LogRotation{Daily: true, Compress: true, Keep: 1})
+	/*
+		Rotate every day. Files are compressed and removed in order so only the newest compressed file remains
+	*/
+	tk := NewLogger("TK")
+	for i := 0; i <= 5; i++ {
+		day = start.AddDate(0, 0, i)
+		tk.LogInfof("DAY %d", i)
+	}
+	compressing.Wait()
+
+	lfd := &logLevelFileData{fileName: filepath.Join(dir, "k.log")}
+	rotated := lfd.listRotatedFiles()
+	test.AssertIntEqual(t, "", len(rotated), 1)
+	test.AssertStringEquals(t, "", filepath.Base(rotated[0]), "k."+start.AddDate(0, 0, 4).Format(rotatedNameFormat)+".log.gz")
+	test.AssertFileContains(t, "", filepath.Join(dir, "k.log"), "INFO DAY 5")
+}
+
+func TestReopenLogFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reopen")
+	test.AssertErrorIsNil(t, "", err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "o.log")
+	levels := make(map[string]string)
+	levels["INFO"] = fileName
+	CreateLogWithFilenameAndAppID("", "AppID", -1, levels)
+	defer CloseLog()
+
+	to := NewLogger("TO")
+	to.LogInfo("BEFORE")
+	os.Rename(fileName, fileName+".1")
+	to.LogInfo("MOVED")
+	test.AssertErrorIsNil(t, "", ReopenLogFiles())
+	to.LogInfo("AFTER")
+	test.AssertFileContains(t, "", fileName+".1", "INFO BEFORE", "INFO MOVED")
+	test.AssertFileContains(t, "", fileName, "INFO AFTER")
+	test.AssertFileDoesNotContain(t, "", fileName, "BEFORE", "MOVED")
+}
+
+func TestSetLogLevelAtRuntime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "levels")
+	test.AssertErrorIsNil(t, "", err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "l.log")
+	CreateLogWithFilenameAndAppID("", "AppID", -1, make(map[string]string))
+	defer CloseLog()
+	tl := NewLogger("TL")
+
+	test.AssertBoolFalse(t, "IsDebug", IsDebug())
+	test.AssertErrorIsNil(t, "", SetLogLevel("debug", fileName))
+	test.AssertBoolTrue(t, "IsDebug", IsDebug())
+	test.AssertStringEquals(t, "", "DEBUG:Active note[FILE] error[NO]:Out=:l.log:Open", LoggerLevelDataString("DEBUG"))
+	tl.LogDebug("ON")
+	previous := levelData(GetLogLevelTypeIndexForLevelName("DEBUG"))
+
+	test.AssertErrorIsNil(t, "", SetLogLevel("DEBUG", "OFF"))
+	test.AssertBoolFalse(t, "IsDebug", IsDebug())
+	test.AssertStringEquals(t, "", "DEBUG:In-Active note[OFF] error[NO]", LoggerLevelDataString("DEBUG"))
+	test.AssertStringEquals(t, "File closed", "", GetLogLevelFileNameForLevelName("DEBUG"))
+	tl.LogDebug("OFF")
+	/*
+		A line written using the previous definition after the file is closed is discarded
+	*/
+	_, err = previous.file.Write([]byte("DEBUG LATE\n"))
+	test.AssertBoolTrue(t, "Closed", err == os.ErrClosed)
+	test.AssertFileContains(t, "", fileName, "DEBUG ON")
+	test.AssertFileDoesNotContain(t, "", fileName, "DEBUG OFF")
+	test.AssertFileDoesNotContain(t, "", fileName, "DEBUG LATE")
+
+	test.AssertErrorIsNil(t, "", SetLogLevel("ERROR", "DEFAULT"))
+	test.AssertStringEquals(t, "", "ERROR:Active note[SYSERR] error[YES]:Out=Console:", LoggerLevelDataString("ERROR"))
+	test.AssertErrorIsNil(t, "", SetLogLevel("INFO", "DEFAULT"))
+	test.AssertStringEquals(t, "", "INFO:Active note[SYSOUT] error[NO]:Out=Console:", LoggerLevelDataString("INFO"))
+
+	test.AssertError(t, "Invalid name", SetLogLevel("DEBUGd", "SYSOUT"))
+	test.AssertError(t, "Invalid file", SetLogLevel("DEBUG", "noextension"))
+	test.AssertStringEquals(t, "Unchanged", "DEBUG:In-Active note[OFF] error[NO]", LoggerLevelDataString("DEBUG"))
+	test.AssertStringEquals(t, "", "INFO, DEBUG, WARN, ACCESS, ERROR, FATAL", strings.Join(GetLogLevelNames(), ", "))
+}
+
+func TestSetLogLevelWhileLogging(t *testing.T) {
+	dir, err := ioutil.TempDir("", "levels")
+	test.AssertErrorIsNil(t, "", err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "c.log")
+	CreateLogWithFilenameAndAppID("", "AppID", -1, make(map[string]string))
+	defer CloseLog()
+	tc := NewLogger("TC")
+
+	done := make(chan bool)
+	for i := 0; i < 4; i++ {
+		go func(id int) {
+			for j := 0; j < 200; j++ {
+				if IsWarn() {
+					tc.LogWarnf("Writer %d line %d", id, j)
+				}
+			}
+			done <- true
+		}(i)
+	}
+	for j := 0; j < 50; j++ {
+		if j%2 == 0 {
+			test.AssertErrorIsNil(t, "", SetLogLevel("WARN", fileName))
+		} else {
+			test.AssertErrorIsNil(t, "", SetLogLevel("WARN", "OFF"))
+		}
+	}
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+	test.AssertBoolFalse(t, "IsWarn", IsWarn())
+}
+
+func TestModuleLevels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "modules")
+	test.AssertErrorIsNil(t, "", err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "m.log")
+	levels := make(map[string]string)
+	levels["INFO"] = "DEFAULT"
+	CreateLogWithFilenameAndAppID(fileName, "AppID", -1, levels)
+	defer CloseLog()
+	tm := NewLogger("TM")
+	ts := NewLogger("TS")
+	test.AssertErrorIsNil(t, "", SetModuleLevels(map[string]string{"TM": "DEBUG", "TS": " -info ", "TN": "DEBUG,-WARN"}))
+	tn := NewLogger("TN")
+
+	test.AssertBoolFalse(t, "Global IsDebug", IsDebug())
+	test.AssertBoolTrue(t, "Global IsInfo", IsInfo())
+	test.AssertBoolTrue(t, "TM IsDebug", tm.IsDebug())
+	test.AssertBoolTrue(t, "TM IsInfo", tm.IsInfo())
+	test.AssertBoolFalse(t, "TS IsDebug", ts.IsDebug())
+	test.AssertBoolFalse(t, "TS IsInfo", ts.IsInfo())
+	test.AssertBoolTrue(t, "TN created after SetModuleLevels", tn.IsDebug())
+	test.AssertBoolTrue(t, "TS IsError", ts.IsError())
+	test.AssertStringEquals(t, "", "DEBUG:Active note[DEFAULT] error[NO]:Out=:m.log:Open", tm.LoggerLevelDataString("DEBUG"))
+	test.AssertStringEquals(t, "", "INFO:In-Active note[OFF] error[NO]", ts.LoggerLevelDataString("INFO"))
+	test.AssertStringEquals(t, "", "DEBUG:In-Active note[OFF] error[NO]", LoggerLevelDataString("DEBUG"))
+
+	tm.LogDebug("TM-DEBUG")
+	tm.LogInfo("TM-INFO")
+	ts.LogDebug("TS-DEBUG")
+	ts.LogInfo("TS-INFO")
+	tn.LogDebugFields("TN-DEBUG", Fields{"n": 1})
+	test.AssertFileContains(t, "", fileName, "DEBUG TM-DEBUG", "INFO TM-INFO", "DEBUG TN-DEBUG n=1")
+	test.AssertFileDoesNotContain(t, "", fileName, "TS-DEBUG", "TS-INFO")
+
+	/*
+		Modules follow changes to the global levels unless they switch the level off
+	*/
+	test.AssertErrorIsNil(t, "", SetLogLevel("DEBUG", "SYSOUT"))
+	test.AssertStringEquals(t, "", "DEBUG:Active note[SYSOUT] error[NO]:Out=Console:", tm.LoggerLevelDataString("DEBUG"))
+	test.AssertBoolTrue(t, "TS IsDebug", ts.IsDebug())
+	test.AssertErrorIsNil(t, "", SetLogLevel("WARN", "SYSOUT"))
+	test.AssertBoolFalse(t, "TN IsWarn", tn.IsWarn())
+
+	test.AssertError(t, "Invalid level", SetModuleLevels(map[string]string{"TM": "DEBUG,TRACE"}))
+	test.AssertBoolTrue(t, "Unchanged", tm.IsDebug())
+	test.AssertErrorIsNil(t, "", SetModuleLevels(nil))
+	test.AssertBoolTrue(t, "TS follows global", ts.IsInfo())
+	test.AssertBoolTrue(t, "TN follows global", tn.IsWarn())
+}
+
+func TestSetLogLevelsOnlyKnownOutputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "levels")
+	test.AssertErrorIsNil(t, "", err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "k.log")
+	levels := make(map[string]string)
+	levels["WARN"] = fileName
+	CreateLogWithFilenameAndAppID("", "AppID", -1, levels)
+	defer CloseLog()
+
+	test.AssertError(t, "New file", SetLogLevels(map[string]string{"INFO": "SYSOUT", "DEBUG": filepath.Join(dir, "new.log")}))
+	test.AssertFileNotExists(t, "", filepath.Join(dir, "new.log"))
+	test.AssertBoolFalse(t, "Nothing applied", IsInfo())
+	test.AssertError(t, "Invalid name", SetLogLevels(map[string]string{"INFO": "SYSOUT", "TRACE": "OFF"}))
+	test.AssertBoolFalse(t, "Nothing applied", IsInfo())
+
+	test.AssertErrorIsNil(t, "", SetLogLevels(map[string]string{"INFO": "json:sysout", "DEBUG": fileName, "WARN": "OFF"}))
+	test.AssertBoolTrue(t, "IsInfo", IsInfo())
+	test.AssertStringEquals(t, "", "DEBUG:Active note[FILE] error[NO]:Out=:k.log:Open", LoggerLevelDataString("DEBUG"))
+	test.AssertStringEquals(t, "", "WARN:In-Active note[OFF] error[NO]", LoggerLevelDataString("WARN"))
+}
+
+func TestRotateIgnoresOtherFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	test.AssertErrorIsNil(t, "", err)
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"app.access.log", "app.access.20240101-000000.log", "app.20240101-000000.log", "app.20240102-000000-1.log.gz", "app.notes.log"} {
+		test.AssertErrorIsNil(t, "", ioutil.WriteFile(filepath.Join(dir, name), []byte("x"), 0644))
+	}
+	lfd := &logLevelFileData{fileName: filepath.Join(dir, "app.log")}
+	rotated := lfd.listRotatedFiles()
+	test.AssertIntEqual(t, "", len(rotated), 2)
+	test.AssertStringEquals(t, "", filepath.Base(rotated[0]), "app.20240101-000000.log")
+	test.AssertStringEquals(t, "", filepath.Base(rotated[1]), "app.20240102-000000-1.log.gz")
+	lfd.removeOldFiles(1)
+	test.AssertFileExists(t, "Other level live file", filepath.Join(dir, "app.access.log"))
+	test.AssertFileExists(t, "Other level rotated file", filepath.Join(dir, "app.access.20240101-000000.log"))
+	test.AssertFileExists(t, "", filepath.Join(dir, "app.notes.log"))
+	test.AssertFileNotExists(t, "Oldest removed", filepath.Join(dir, "app.20240101-000000.log"))
+}
+
+func TestOpenLogFileReopenAndRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logfile")
+	test.AssertErrorIsNil(t, "", err)
+	defer os.RemoveAll(dir)
+	CreateLogWithFilenameAndAppID("", "AppID", -1, make(map[string]string))
+	defer CloseLog()
+	fileName := filepath.Join(dir, "access.log")
+	logFile, err := OpenLogFile(fileName)
+	test.AssertErrorIsNil(t, "", err)
+	defer logFile.Close()
+
+	logFile.Write([]byte("BEFORE\n"))
+	os.Rename(fileName, fileName+".1")
+	test.AssertErrorIsNil(t, "", ReopenLogFiles())
+	logFile.Write([]byte("AFTER\n"))
+	test.AssertFileContains(t, "", fileName+".1", "BEFORE")
+	test.AssertFileContains(t, "", fileName, "AFTER")
+	test.AssertFileDoesNotContain(t, "", fileName, "BEFORE")
+
+	SetLogRotation(&LogRotation{MaxSizeMB: 1})
+	defer SetLogRotation(nil)
+	logFile.Write([]byte(strings.Repeat("x", 1024*1024)))
+	rotated, _ := filepath.Glob(filepath.Join(dir, "access.*.log"))
+	test.AssertIntEqual(t, "Rotated", len(rotated), 1)
+}
@@ -0,0 +1,166 @@
+package logging
+
+import (
+	"strings"
+)
+
+/*
+logModuleLevels - Key is the module name, value is the levels switched on (true) or off (false) for that module.
+Levels not in the value follow the global levels. See SetModuleLevels.
+*/
+var logModuleLevels map[string]map[LoggerLevelTypeIndex]bool
+
+/*
+SetModuleLevels override the global levels for specific modules (see NewLogger). Call after CreateLogWithFilenameAndAppID.
+
+Key is the module name. Value is a comma separated list of level names. A name switches the level on for
+the module. A name prefixed with '-' switches it off. For example:
+
+	{"Template": "DEBUG", "ServerMain": "-DEBUG,-ACCESS"}
+
+A level switched on for a module that is OFF globally is written to the DEFAULT output.
+All other levels follow the global levels, including changes made by SetLogLevel.
+nil or an empty map removes ALL module levels.
+*/
+func SetModuleLevels(moduleLevels map[string]string) error {
+	parsed := make(map[string]map[LoggerLevelTypeIndex]bool)
+	for moduleName, value := range moduleLevels {
+		levels := make(map[LoggerLevelTypeIndex]bool)
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			active := !strings.HasPrefix(name, "-")
+			loggerLevelTypeIndex := GetLogLevelTypeIndexForLevelName(strings.TrimPrefix(name, "-"))
+			if loggerLevelTypeIndex == NotFound {
+				return newError("Module '" + moduleName + "'. " + invalidLevelNameError(strings.TrimPrefix(name, "-")).Error())
+			}
+			levels[loggerLevelTypeIndex] = active
+		}
+		parsed[moduleName] = levels
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	logModuleLevels = parsed
+	err := resolveModuleLevels()
+	closeUnusedLogFiles()
+	return err
+}
+
+/*
+levelData returns the current definition of a level for the module. It must not be changed!
+*/
+func (p *LoggerDataReference) levelData(index LoggerLevelTypeIndex) *logLevelData {
+	levelsMutex.RLock()
+	defer levelsMutex.RUnlock()
+	if p.levels != nil {
+		return p.levels[index]
+	}
+	return logLevelDataIndexList[index]
+}
+
+/*
+IsDebug return true is the debug log function is enabled for this module
+*/
+func (p *LoggerDataReference) IsDebug() bool {
+	return p.levelData(DebugLevel).active
+}
+
+/*
+IsAccess return true is the access log function is enabled for this module
+*/
+func (p *LoggerDataReference) IsAccess() bool {
+	return p.levelData(AccessLevel).active
+}
+
+/*
+IsInfo return true is the info log function is enabled for this module
+*/
+func (p *LoggerDataReference) IsInfo() bool {
+	return p.levelData(InfoLevel).active
+}
+
+/*
+IsError return true is the error log function is enabled for this module
+*/
+func (p *LoggerDataReference) IsError() bool {
+	return p.levelData(ErrorLevel).active
+}
+
+/*
+IsFatal return true is the fatal log function is enabled for this module
+*/
+func (p *LoggerDataReference) IsFatal() bool {
+	return p.levelData(FatalLevel).active
+}
+
+/*
+IsWarn return true is the warn log function is enabled for this module
+*/
+func (p *LoggerDataReference) IsWarn() bool {
+	return p.levelData(WarnLevel).active
+}
+
+/*
+resolveModuleLevels sets the levels for each module from the global levels and the module levels.
+Must be called with the mutex locked!
+*/
+func resolveModuleLevels() error {
+	levelsMutex.Lock()
+	defer levelsMutex.Unlock()
+	defaults := make([]*logLevelData, len(logLevelDataIndexList))
+	for moduleName, module := range logDataModules {
+		overrides, ok := logModuleLevels[moduleName]
+		if !ok {
+			module.levels = nil
+			continue
+		}
+		levels := make([]*logLevelData, len(logLevelDataIndexList))
+		for i, global := range logLevelDataIndexList {
+			active, found := overrides[LoggerLevelTypeIndex(i)]
+			switch {
+			case !found || active == global.active:
+				levels[i] = global
+			case active:
+				if defaults[i] == nil {
+					lld := &logLevelData{
+						paddedName:   global.paddedName,
+						index:        global.index,
+						note:         global.note,
+						active:       false,
+						isErrorLevel: global.isErrorLevel,
+					}
+					err := activateLogLevel(lld, defaultName)
+					if err != nil {
+						return err
+					}
+					defaults[i] = lld
+				}
+				levels[i] = defaults[i]
+			default:
+				levels[i] = &logLevelData{
+					paddedName:   global.paddedName,
+					index:        global.index,
+					note:         offName,
+					active:       false,
+					isErrorLevel: global.isErrorLevel,
+				}
+			}
+		}
+		module.levels = levels
+	}
+	return nil
+}
+
+/*
+clearModuleLevels removes ALL module levels. Modules created before CreateLogWithFilenameAndAppID use the global levels.
+*/
+func clearModuleLevels() {
+	levelsMutex.Lock()
+	defer levelsMutex.Unlock()
+	for _, module := range logDataModules {
+		module.levels = nil
+	}
+	logModuleLevels = nil
+}
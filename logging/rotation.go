@@ -0,0 +1,406 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/*
+LogRotation - Rotation of ALL the log files. See SetLogRotation.
+
+MaxSizeMB - Rotate when a file would exceed this size in Mega Bytes. 0 is no size limit.
+Daily     - Rotate on the first write after midnight (local time).
+Keep      - The number of rotated files to keep. Older files are removed. 0 keeps all of them.
+Compress  - gzip the rotated files.
+*/
+type LogRotation struct {
+	MaxSizeMB int
+	Daily     bool
+	Keep      int
+	Compress  bool
+}
+
+/*
+rotatedNameFormat - The time stamp added to a rotated file name. The time the file was opened is used
+so a daily file is named for the day it contains.
+*/
+const rotatedNameFormat = "20060102-150405"
+
+/*
+logRotation is nil if rotation is not defined
+*/
+var logRotation *LogRotation
+
+/*
+timeNow can be replaced for testing
+*/
+var timeNow = time.Now
+
+/*
+compressing is used to wait for rotated files to be compressed and the old files removed
+*/
+var compressing sync.WaitGroup
+
+var sighupOnce sync.Once
+
+/*
+SetLogRotation define the rotation for ALL log files. Call after CreateLogWithFilenameAndAppID. nil stops rotation.
+
+Levels that share a file rotate together.
+*/
+func SetLogRotation(rotation *LogRotation) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	logRotation = rotation
+}
+
+/*
+ReopenLogFiles close and reopen ALL the log files. Use this when an external tool (for example logrotate)
+has renamed the files. See ReopenLogFilesOnSIGHUP.
+*/
+func ReopenLogFiles() error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	var lastErr error
+	files := []*logLevelFileData{}
+	for _, lfd := range logLevelFileMap {
+		files = append(files, lfd)
+	}
+	for lfd := range openLogFiles {
+		files = append(files, lfd)
+	}
+	for _, lfd := range files {
+		if lfd.logFile != nil {
+			lfd.logFile.Close()
+		}
+		err := lfd.open()
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+/*
+LogFile - A file written by the application (for example an access log) that is rotated (see SetLogRotation)
+and re-opened (see ReopenLogFiles) with the log files. Created by OpenLogFile.
+*/
+type LogFile struct {
+	lfd *logLevelFileData
+}
+
+/*
+openLogFiles - The files opened by OpenLogFile that have not been closed
+*/
+var openLogFiles = make(map[*logLevelFileData]bool)
+
+/*
+OpenLogFile open a file for append that is rotated and re-opened with the log files.
+*/
+func OpenLogFile(fileName string) (*LogFile, error) {
+	absFileName, err := filepath.Abs(fileName)
+	if err != nil {
+		return nil, newError("Log file " + fileName + " is not a valid file path: " + err.Error())
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	lfd := &logLevelFileData{
+		fileName:        absFileName,
+		logFile:         nil,
+		size:            0,
+		openedAt:        time.Time{},
+		compressQueue:   []string{},
+		compressRunning: false,
+	}
+	err = lfd.open()
+	if err != nil {
+		return nil, newError("Log file " + fileName + " could NOT be Created or Opened: " + err.Error())
+	}
+	openLogFiles[lfd] = true
+	return &LogFile{lfd: lfd}, nil
+}
+
+/*
+Write implements io.Writer. Rotates the file if required.
+*/
+func (p *LogFile) Write(b []byte) (int, error) {
+	return p.lfd.Write(b)
+}
+
+/*
+Close the file. It is no longer rotated or re-opened.
+*/
+func (p *LogFile) Close() error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	delete(openLogFiles, p.lfd)
+	if p.lfd.logFile == nil {
+		return nil
+	}
+	err := p.lfd.logFile.Close()
+	p.lfd.logFile = nil
+	return err
+}
+
+/*
+GetFileName returns the absolute file name
+*/
+func (p *LogFile) GetFileName() string {
+	return p.lfd.fileName
+}
+
+/*
+ReopenLogFilesOnSIGHUP call ReopenLogFiles when the process receives SIGHUP. Only the first call has any effect.
+*/
+func ReopenLogFilesOnSIGHUP() {
+	sighupOnce.Do(func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGHUP)
+		go func() {
+			for range signals {
+				err := ReopenLogFiles()
+				if err != nil {
+					LogDirectToSystemError("Logging: Reopen log files on SIGHUP failed: "+err.Error(), false)
+				}
+			}
+		}()
+	})
+}
+
+/*
+Write implements io.Writer for the log.Logger of each level using the file. Rotates the file if required.
+*/
+func (p *logLevelFileData) Write(b []byte) (int, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if p.logFile == nil {
+		return 0, os.ErrClosed
+	}
+	if p.rotationRequired(int64(len(b))) {
+		err := p.rotate()
+		if err != nil {
+			LogDirectToSystemError("Logging: Rotate log file "+p.fileName+" failed: "+err.Error(), false)
+		}
+	}
+	n, err := p.logFile.Write(b)
+	p.size = p.size + int64(n)
+	return n, err
+}
+
+/*
+open the file for append. Must be called with the mutex locked!
+*/
+func (p *logLevelFileData) open() error {
+	f, err := os.OpenFile(p.fileName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		p.logFile = nil
+		return err
+	}
+	p.logFile = f
+	p.size = 0
+	p.openedAt = timeNow()
+	info, err := f.Stat()
+	if err == nil {
+		p.size = info.Size()
+		/*
+			Use the modified time of an existing file so a restart after midnight still rotates yesterday's file
+		*/
+		if p.size > 0 {
+			p.openedAt = info.ModTime()
+		}
+	}
+	return nil
+}
+
+/*
+rotationRequired must be called with the mutex locked!
+*/
+func (p *logLevelFileData) rotationRequired(writeSize int64) bool {
+	if logRotation == nil || p.size == 0 {
+		return false
+	}
+	if logRotation.MaxSizeMB > 0 && p.size+writeSize > int64(logRotation.MaxSizeMB)*1024*1024 {
+		return true
+	}
+	if logRotation.Daily {
+		y1, m1, d1 := p.openedAt.Date()
+		y2, m2, d2 := timeNow().Date()
+		return y1 != y2 || m1 != m2 || d1 != d2
+	}
+	return false
+}
+
+/*
+rotate renames the file and opens a new one. Must be called with the mutex locked!
+*/
+func (p *logLevelFileData) rotate() error {
+	p.logFile.Close()
+	rotatedName := p.rotatedFileName()
+	err := os.Rename(p.fileName, rotatedName)
+	openErr := p.open()
+	if err != nil {
+		return err
+	}
+	if openErr != nil {
+		return openErr
+	}
+	if logRotation.Compress {
+		compressing.Add(1)
+		p.compressQueue = append(p.compressQueue, rotatedName)
+		if !p.compressRunning {
+			p.compressRunning = true
+			go p.compressRotatedFiles()
+		}
+		return nil
+	}
+	p.removeOldFiles(logRotation.Keep)
+	return nil
+}
+
+/*
+compressRotatedFiles compresses the queued rotated files in order and removes the old files after each one.
+Only one of these runs for each file so compression and removal of the rotated files are never done at the same time.
+
+The mutex is NOT held while a file is compressed so logging is not blocked. Old files are removed with the mutex locked.
+*/
+func (p *logLevelFileData) compressRotatedFiles() {
+	for {
+		mutex.Lock()
+		if len(p.compressQueue) == 0 {
+			p.compressRunning = false
+			mutex.Unlock()
+			return
+		}
+		rotatedName := p.compressQueue[0]
+		mutex.Unlock()
+		err := compressLogFile(rotatedName)
+		if err != nil {
+			LogDirectToSystemError("Logging: Compress log file "+rotatedName+" failed: "+err.Error(), false)
+		}
+		mutex.Lock()
+		p.compressQueue = p.compressQueue[1:]
+		if logRotation != nil {
+			p.removeOldFiles(logRotation.Keep)
+		}
+		mutex.Unlock()
+		compressing.Done()
+	}
+}
+
+/*
+rotatedFileName returns a name that does not exist. For example app.log is rotated to app.20190716-000000.log
+*/
+func (p *logLevelFileData) rotatedFileName() string {
+	ext := filepath.Ext(p.fileName)
+	base := strings.TrimSuffix(p.fileName, ext) + "." + p.openedAt.Format(rotatedNameFormat)
+	name := base + ext
+	for count := 1; fileExists(name) || fileExists(name+".gz"); count++ {
+		name = fmt.Sprintf("%s-%d%s", base, count, ext)
+	}
+	return name
+}
+
+/*
+removeOldFiles removes the oldest rotated files so only keep remain. 0 keeps all of them.
+Files waiting to be compressed are not removed. They are removed after they are compressed. Must be called with the mutex locked!
+*/
+func (p *logLevelFileData) removeOldFiles(keep int) {
+	if keep <= 0 {
+		return
+	}
+	queued := make(map[string]bool)
+	for _, name := range p.compressQueue {
+		queued[name] = true
+		queued[name+".gz"] = true
+	}
+	rotated := p.listRotatedFiles()
+	for i := 0; i < len(rotated)-keep; i++ {
+		if !queued[rotated[i]] {
+			os.Remove(rotated[i])
+		}
+	}
+}
+
+/*
+listRotatedFiles returns the rotated files (compressed or not) oldest first
+*/
+func (p *logLevelFileData) listRotatedFiles() []string {
+	ext := filepath.Ext(p.fileName)
+	base := strings.TrimSuffix(p.fileName, ext)
+	matches, err := filepath.Glob(base + ".*")
+	if err != nil {
+		return []string{}
+	}
+	/*
+		Only names created by rotatedFileName. Other files (for example app.access.log when rotating app.log) must not match
+	*/
+	rotatedName := regexp.MustCompile("^" + regexp.QuoteMeta(base+".") + `(\d{8}-\d{6}(-\d+)?)` + regexp.QuoteMeta(ext) + `(\.gz)?$`)
+	rotated := []string{}
+	keys := make(map[string]string)
+	for _, match := range matches {
+		parts := rotatedName.FindStringSubmatch(match)
+		if parts != nil {
+			rotated = append(rotated, match)
+			keys[match] = rotatedSortKey(parts[1])
+		}
+	}
+	sort.Slice(rotated, func(i, j int) bool {
+		return keys[rotated[i]] < keys[rotated[j]]
+	})
+	return rotated
+}
+
+/*
+rotatedSortKey returns a key so that '20190716-000000' sorts before '20190716-000000-1'
+*/
+func rotatedSortKey(stamp string) string {
+	count := 0
+	if len(stamp) > len(rotatedNameFormat) && stamp[len(rotatedNameFormat)] == '-' {
+		count, _ = strconv.Atoi(stamp[len(rotatedNameFormat)+1:])
+		stamp = stamp[:len(rotatedNameFormat)]
+	}
+	return fmt.Sprintf("%s-%06d", stamp, count)
+}
+
+func compressLogFile(fileName string) error {
+	in, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(fileName+".gz", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(fileName + ".gz")
+		return err
+	}
+	in.Close()
+	return os.Remove(fileName)
+}
+
+func fileExists(fileName string) bool {
+	_, err := os.Stat(fileName)
+	return err == nil
+}
@@ -0,0 +1,151 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Fields - Key value pairs added to a log line. See LogInfoFields.
+
+In JSON mode the fields are written as a 'fields' object. In text mode they are appended to the message as key=value.
+*/
+type Fields map[string]interface{}
+
+/*
+jsonTimeFormat - RFC3339 with microseconds (the same precision as the text log lines)
+*/
+const jsonTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+/*
+jsonLogLine - The JSON format of a log line
+*/
+type jsonLogLine struct {
+	Time        string `json:"time"`
+	Level       string `json:"level"`
+	Application string `json:"app"`
+	Module      string `json:"module"`
+	Message     string `json:"msg"`
+	Fields      Fields `json:"fields,omitempty"`
+}
+
+/*
+LogInfoFields logs a message with key value fields at INFO level
+Does nothing if logging is disabled or level is inactive
+Fallback mode is true when logger configuration failed. It logs to the console
+*/
+func (p *LoggerDataReference) LogInfoFields(message string, fields Fields) {
+	p.logFields(InfoLevel, "INFO", message, fields)
+}
+
+/*
+LogDebugFields logs a message with key value fields at DEBUG level
+Does nothing if logging is disabled or level is inactive
+Fallback mode is true when logger configuration failed. It logs to the console
+*/
+func (p *LoggerDataReference) LogDebugFields(message string, fields Fields) {
+	p.logFields(DebugLevel, "DEBUG", message, fields)
+}
+
+/*
+LogWarnFields logs a message with key value fields at WARN level
+Does nothing if logging is disabled or level is inactive
+Fallback mode is true when logger configuration failed. It logs to the console
+*/
+func (p *LoggerDataReference) LogWarnFields(message string, fields Fields) {
+	p.logFields(WarnLevel, "WARN", message, fields)
+}
+
+/*
+LogAccessFields logs a message with key value fields at ACCESS level
+Does nothing if logging is disabled or level is inactive
+Fallback mode is true when logger configuration failed. It logs to the console
+*/
+func (p *LoggerDataReference) LogAccessFields(message string, fields Fields) {
+	p.logFields(AccessLevel, "ACCESS", message, fields)
+}
+
+/*
+LogErrorFields logs a message with key value fields at ERROR level
+Does nothing if logging is disabled or level is inactive
+Fallback mode is true when logger configuration failed. It logs to the console
+*/
+func (p *LoggerDataReference) LogErrorFields(message string, fields Fields) {
+	p.logFields(ErrorLevel, "ERROR", message, fields)
+}
+
+func (p *LoggerDataReference) logFields(index LoggerLevelTypeIndex, name string, message string, fields Fields) {
+	if fallBack {
+		fmt.Println("FALLBACK:" + name + ": " + message + textFields(fields))
+		return
+	}
+	if p.levelData(index).active && isEnabled() {
+		p.write(index, message, fields)
+	}
+}
+
+/*
+write a log line to the logger for the level. The caller must check that the level is active.
+*/
+func (p *LoggerDataReference) write(index LoggerLevelTypeIndex, message string, fields Fields) {
+	lld := p.levelData(index)
+	if !lld.active {
+		/*
+			The level was switched off (see SetLogLevel) after the caller checked it
+		*/
+		return
+	}
+	if lld.json {
+		lld.logger.Print(p.jsonLine(lld, message, fields))
+		return
+	}
+	lld.logger.Print(p.loggerPrefix + lld.paddedName + message + textFields(fields))
+}
+
+func (p *LoggerDataReference) jsonLine(lld *logLevelData, message string, fields Fields) string {
+	line := &jsonLogLine{
+		Time:        time.Now().Format(jsonTimeFormat),
+		Level:       strings.TrimSpace(lld.paddedName),
+		Application: logApplicationID,
+		Module:      p.loggerModuleName,
+		Message:     message,
+		Fields:      fields,
+	}
+	encoded, err := json.Marshal(line)
+	if err != nil {
+		/*
+			A field value could not be encoded. Log the fields as text so the line is not lost.
+		*/
+		line.Message = message + textFields(fields)
+		line.Fields = Fields{"encodingError": err.Error()}
+		encoded, _ = json.Marshal(line)
+	}
+	return string(encoded)
+}
+
+/*
+textFields returns the fields as ' key=value' in key order. Values containing spaces or quotes are quoted.
+*/
+func textFields(fields Fields) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	for _, key := range keys {
+		value := fmt.Sprintf("%v", fields[key])
+		if value == "" || strings.ContainsAny(value, " \"=\t\n") {
+			value = strconv.Quote(value)
+		}
+		sb.WriteString(" " + key + "=" + value)
+	}
+	return sb.String()
+}
@@ -32,6 +32,26 @@ const (
 	SCScriptError
 	SCOpenFileError
 	SCUnhandledPanic
+	SCRequestTimeout
+	SCRateLimited
+	SCUnauthorized
+	SCForbidden
+	SCAccessDenied
+	SCSessionError
+	SCCSRFInvalid
+	SCIPDenied
+	SCBodyTooLarge
+	SCBodyAlreadyRead
+	SCInvalidFormRequest
+	SCUploadRejected
+	SCInvalidXMLRequest
+	SCInvalidYAMLRequest
+	SCUnsupportedMediaType
+	SCSchemaValidation
+	SCNotAcceptable
+	SCResponseEncoding
+	SCWebSocketHandshake
+	SCLogLevel
 	SCMax
 )
 
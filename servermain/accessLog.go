@@ -0,0 +1,270 @@
+package servermain
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stuartdd/webServerBase/logging"
+	"github.com/stuartdd/webServerBase/substitution"
+)
+
+/*
+Access log formats. See NewAccessLog.
+*/
+const (
+	AccessLogCommon   = "common"
+	AccessLogCombined = "combined"
+	AccessLogJSON     = "json"
+	clfTimeFormat     = "02/Jan/2006:15:04:05 -0700"
+)
+
+/*
+AccessLog - Writes ONE record per request when the response is complete.
+
+The record is written to it's own file if a file name is given, otherwise it is written to the ACCESS log level.
+When an AccessLog is set (see SetAccessLog) the request and response ACCESS lines are not written.
+*/
+type AccessLog struct {
+	mutex    sync.Mutex
+	format   string
+	template string
+	fileName string
+	file     *logging.LogFile
+}
+
+/*
+accessLogRecord - The data for a single access log record. Also the JSON format of the record.
+*/
+type accessLogRecord struct {
+	Time       time.Time `json:"time"`
+	TxID       string    `json:"txid"`
+	RemoteAddr string    `json:"remoteAddr"`
+	User       string    `json:"user,omitempty"`
+	Method     string    `json:"method"`
+	URL        string    `json:"url"`
+	Protocol   string    `json:"protocol"`
+	Route      string    `json:"route,omitempty"`
+	Status     int       `json:"status"`
+	SubCode    int       `json:"subCode"`
+	Bytes      int64     `json:"bytes"`
+	TTFBMs     float64   `json:"ttfbMs"`
+	LatencyMs  float64   `json:"latencyMs"`
+	Referer    string    `json:"referer,omitempty"`
+	UserAgent  string    `json:"userAgent,omitempty"`
+}
+
+/*
+NewAccessLog create an access log. format is one of:
+
+	common   - Apache Common Log Format
+	combined - Apache Combined Log Format (Common plus referer and user agent)
+	json     - A JSON object per line
+	template - Any text containing ${token} values. For example "${txid} ${route} ${status} ${latency}"
+
+An empty format is the same as combined. Template tokens are:
+
+	remoteAddr, user, time, timestamp, method, url, protocol, route, status, subCode,
+	bytes, ttfb, latency, latencyMs, txid, referer, userAgent
+
+Missing values are written as '-'. If fileName is not empty records are appended to the file.
+The file is rotated and re-opened with the log files (see logging.SetLogRotation and logging.ReopenLogFiles).
+*/
+func NewAccessLog(format string, fileName string) *AccessLog {
+	accessLog := &AccessLog{
+		format:   strings.ToLower(strings.TrimSpace(format)),
+		template: "",
+		fileName: fileName,
+		file:     nil,
+	}
+	switch accessLog.format {
+	case "":
+		accessLog.format = AccessLogCombined
+	case AccessLogCommon, AccessLogCombined, AccessLogJSON:
+	default:
+		if !strings.Contains(format, "${") {
+			panic(fmt.Sprintf("NewAccessLog: Format '%s' is not common, combined, json or a template containing ${token} values", format))
+		}
+		accessLog.format = ""
+		accessLog.template = format
+	}
+	if fileName != "" {
+		/*
+			The file is rotated and re-opened (SIGHUP) with the log files. See logging.OpenLogFile
+		*/
+		file, err := logging.OpenLogFile(fileName)
+		if err != nil {
+			panic(fmt.Sprintf("NewAccessLog: Failed to open access log file %s. Error:%s", fileName, err.Error()))
+		}
+		accessLog.file = file
+	}
+	return accessLog
+}
+
+/*
+GetFileName returns the access log file name. Empty if records are written to the ACCESS log level.
+*/
+func (p *AccessLog) GetFileName() string {
+	return p.fileName
+}
+
+/*
+Close the access log file (if there is one)
+*/
+func (p *AccessLog) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.file == nil {
+		return nil
+	}
+	err := p.file.Close()
+	p.file = nil
+	return err
+}
+
+/*
+SetAccessLog write a single access log record per request. See NewAccessLog.
+*/
+func (p *ServerInstanceData) SetAccessLog(accessLog *AccessLog) {
+	p.accessLog = accessLog
+}
+
+/*
+GetAccessLog returns the access log. nil if it is not defined.
+*/
+func (p *ServerInstanceData) GetAccessLog() *AccessLog {
+	return p.accessLog
+}
+
+/*
+logAccess writes the access log record for a completed request
+*/
+func (p *ServerInstanceData) logAccess(request *http.Request, response *Response) {
+	if p.accessLog == nil {
+		return
+	}
+	line := p.accessLog.formatRecord(p.newAccessLogRecord(request, response))
+	if !p.accessLog.write(line) && p.logger.IsAccess() {
+		p.logger.LogAccess(line)
+	}
+}
+
+func (p *ServerInstanceData) newAccessLogRecord(request *http.Request, response *Response) *accessLogRecord {
+	record := &accessLogRecord{
+		Time:       time.Now(),
+		TxID:       response.GetTransactionID(),
+		RemoteAddr: getRemoteIP(request),
+		Method:     request.Method,
+		URL:        request.URL.RequestURI(),
+		Protocol:   request.Proto,
+		Route:      response.GetRoute(),
+		Status:     response.GetCode(),
+		SubCode:    response.GetSubCode(),
+		Referer:    request.Referer(),
+		UserAgent:  request.UserAgent(),
+	}
+	if p.ipFilter != nil {
+		record.RemoteAddr = p.ipFilter.GetClientIP(request)
+	}
+	principal := response.GetPrincipal()
+	if principal != nil {
+		record.User = principal.Name
+	}
+	ww := response.GetWrappedWriter()
+	if ww != nil {
+		record.Time = ww.GetStartTime()
+		record.Status = ww.GetStatusCode()
+		record.Bytes = ww.GetBytesWritten()
+		record.TTFBMs = durationMillis(ww.GetTimeToFirstByte())
+		record.LatencyMs = durationMillis(ww.GetDuration())
+	}
+	return record
+}
+
+/*
+write the line to the access log file. Returns false if there is no file.
+*/
+func (p *AccessLog) write(line string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.file == nil {
+		return false
+	}
+	p.file.Write([]byte(line + "\n"))
+	return true
+}
+
+func (p *AccessLog) formatRecord(record *accessLogRecord) string {
+	switch p.format {
+	case AccessLogCommon:
+		return commonLogFormat(record)
+	case AccessLogCombined:
+		return commonLogFormat(record) + fmt.Sprintf(" \"%s\" \"%s\"", clfQuoted(record.Referer), clfQuoted(record.UserAgent))
+	case AccessLogJSON:
+		encoded, err := json.Marshal(record)
+		if err != nil {
+			return commonLogFormat(record)
+		}
+		return string(encoded)
+	}
+	return substitution.DoSubstitution(p.template, record.tokens(), '$')
+}
+
+func commonLogFormat(record *accessLogRecord) string {
+	return fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s", clfValue(record.RemoteAddr), clfValue(record.User), record.Time.Format(clfTimeFormat), record.Method, clfQuoted(record.URL), record.Protocol, record.Status, clfBytes(record.Bytes))
+}
+
+/*
+tokens returns the template token values. Empty values are '-'.
+*/
+func (p *accessLogRecord) tokens() map[string]string {
+	tokens := map[string]string{
+		"remoteAddr": p.RemoteAddr,
+		"user":       p.User,
+		"time":       p.Time.Format(clfTimeFormat),
+		"timestamp":  p.Time.Format(time.RFC3339Nano),
+		"method":     p.Method,
+		"url":        p.URL,
+		"protocol":   p.Protocol,
+		"route":      p.Route,
+		"status":     strconv.Itoa(p.Status),
+		"subCode":    strconv.Itoa(p.SubCode),
+		"bytes":      strconv.FormatInt(p.Bytes, 10),
+		"ttfb":       strconv.FormatFloat(p.TTFBMs, 'f', 3, 64) + "ms",
+		"latency":    strconv.FormatFloat(p.LatencyMs, 'f', 3, 64) + "ms",
+		"latencyMs":  strconv.FormatFloat(p.LatencyMs, 'f', 3, 64),
+		"txid":       p.TxID,
+		"referer":    p.Referer,
+		"userAgent":  p.UserAgent,
+	}
+	for name, value := range tokens {
+		tokens[name] = clfValue(value)
+	}
+	return tokens
+}
+
+func durationMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func clfValue(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func clfQuoted(value string) string {
+	return strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n", "\r", "\\r").Replace(clfValue(value))
+}
+
+func clfBytes(bytes int64) string {
+	if bytes == 0 {
+		return "-"
+	}
+	return strconv.FormatInt(bytes, 10)
+}
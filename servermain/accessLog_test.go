@@ -0,0 +1,104 @@
+package servermain
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stuartdd/webServerBase/logging"
+	"github.com/stuartdd/webServerBase/test"
+)
+
+func TestAccessLogFormats(t *testing.T) {
+	logging.CreateTestLogger("TestAccessLog")
+	dir, err := ioutil.TempDir("", "access")
+	test.AssertErrorIsNil(t, "", err)
+	defer os.RemoveAll(dir)
+
+	common := accessLogTest(t, filepath.Join(dir, "common.log"), AccessLogCommon)
+	test.AssertStringContains(t, "", common, "192.0.2.1 - - [", "] \"GET /calc/5?x=1 HTTP/1.1\" 200 6")
+	test.AssertStringDoesNotContain(t, "", common, "agent")
+
+	combined := accessLogTest(t, filepath.Join(dir, "combined.log"), "")
+	test.AssertStringEndsWith(t, "", combined, "\" 200 6 \"http://ref/\" \"test \\\"agent\\\"\"")
+
+	template := accessLogTest(t, filepath.Join(dir, "template.log"), "${txid} ${route} ${status} ${subCode} ${bytes} ${user} ${userAgent}")
+	parts := strings.Split(template, " ")
+	test.AssertIntEqual(t, "", len(parts[0]), 8)
+	test.AssertStringEquals(t, "", strings.Join(parts[1:], " "), "/calc/? 200 0 6 - test \"agent\"")
+
+	jsonLine := accessLogTest(t, filepath.Join(dir, "json.log"), AccessLogJSON)
+	record := make(map[string]interface{})
+	err = json.Unmarshal([]byte(jsonLine), &record)
+	test.AssertErrorIsNil(t, "", err)
+	test.AssertStringEquals(t, "", record["route"].(string), "/calc/?")
+	test.AssertStringEquals(t, "", record["url"].(string), "/calc/5?x=1")
+	test.AssertStringEquals(t, "", record["remoteAddr"].(string), "192.0.2.1")
+	test.AssertIntEqual(t, "", int(record["status"].(float64)), 200)
+	test.AssertIntEqual(t, "", int(record["bytes"].(float64)), 6)
+	test.AssertBoolTrue(t, "Latency recorded", record["latencyMs"].(float64) >= 2)
+}
+
+func TestAccessLogErrorRecord(t *testing.T) {
+	logging.CreateTestLogger("TestAccessLog")
+	dir, err := ioutil.TempDir("", "access")
+	test.AssertErrorIsNil(t, "", err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "error.log")
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.SetAccessLog(NewAccessLog("${status} ${subCode} ${route} ${url}", fileName))
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+	server.GetAccessLog().Close()
+	content, err := ioutil.ReadFile(fileName)
+	test.AssertErrorIsNil(t, "", err)
+	test.AssertStringEquals(t, "One record", string(content), "404 1 - /missing\n")
+}
+
+func TestAccessLogReopened(t *testing.T) {
+	logging.CreateTestLogger("TestAccessLog")
+	dir, err := ioutil.TempDir("", "access")
+	test.AssertErrorIsNil(t, "", err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "reopen.log")
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.SetAccessLog(NewAccessLog("${url}", fileName))
+	defer server.GetAccessLog().Close()
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/before", nil))
+	os.Rename(fileName, fileName+".1")
+	test.AssertErrorIsNil(t, "", logging.ReopenLogFiles())
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/after", nil))
+	test.AssertFileContains(t, "", fileName+".1", "/before")
+	test.AssertFileContains(t, "", fileName, "/after")
+	test.AssertFileDoesNotContain(t, "", fileName, "/before")
+}
+
+func TestAccessLogInvalidFormat(t *testing.T) {
+	defer test.AssertPanicAndRecover(t, "is not common, combined, json or a template")
+	NewAccessLog("apache", "")
+}
+
+func accessLogTest(t *testing.T, fileName string, format string) string {
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.SetAccessLog(NewAccessLog(format, fileName))
+	server.AddMappedHandlerWithNames("/calc/?", http.MethodGet, func(r *http.Request, response *Response) {
+		time.Sleep(2 * time.Millisecond)
+		response.SetResponse(200, "result", "text/plain")
+	}, []string{"number"})
+	req := httptest.NewRequest(http.MethodGet, "/calc/5?x=1", nil)
+	req.Header.Set("Referer", "http://ref/")
+	req.Header.Set("User-Agent", "test \"agent\"")
+	server.ServeHTTP(httptest.NewRecorder(), req)
+	server.GetAccessLog().Close()
+	test.AssertFileExists(t, "", fileName)
+	content, err := ioutil.ReadFile(fileName)
+	test.AssertErrorIsNil(t, "", err)
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	test.AssertIntEqual(t, "One record per request", len(lines), 1)
+	return lines[0]
+}
@@ -0,0 +1,398 @@
+package servermain
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stuartdd/webServerBase/panicapi"
+	"golang.org/x/crypto/bcrypt"
+)
+
+/*
+Authentication schemes. Used with AddAuthRule to define which schemes are accepted for a route prefix
+*/
+const (
+	AuthSchemeBasic  = "Basic"
+	AuthSchemeAPIKey = "ApiKey"
+	AuthSchemeBearer = "Bearer"
+)
+
+/*
+DefaultAPIKeyHeader - The header containing the API key if SetAPIKeyHeader is not called
+*/
+const DefaultAPIKeyHeader = "X-API-Key"
+
+/*
+Principal - The authenticated user (or client). Available to handlers via RequestHandlerHelper.GetPrincipal()
+*/
+type Principal struct {
+	Name   string
+	Scheme string
+	Roles  []string
+	Claims map[string]interface{}
+}
+
+/*
+HasAnyRole returns true if the principal has at least one of the roles
+*/
+func (p *Principal) HasAnyRole(roles []string) bool {
+	for _, role := range roles {
+		if containsString(p.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+userData the password hash and roles of a user
+*/
+type userData struct {
+	hash  []byte
+	roles []string
+}
+
+/*
+apiKeyData the principal name and roles for an API key
+*/
+type apiKeyData struct {
+	name  string
+	roles []string
+}
+
+/*
+authRule the schemes accepted for a route prefix
+*/
+type authRule struct {
+	prefix  string
+	schemes []string
+}
+
+/*
+Authenticator - Authenticates requests for route prefixes using HTTP Basic (bcrypt hashed passwords),
+static API keys or HS256 signed bearer tokens (JWT).
+
+Add Authenticator.BeforeHandler to the server using AddBeforeHandler so it runs before ALL mapped handlers.
+*/
+type Authenticator struct {
+	mutex        sync.RWMutex
+	realm        string
+	rules        []*authRule
+	users        map[string]*userData
+	apiKeys      map[string]*apiKeyData
+	apiKeyHeader string
+	jwtSecret    []byte
+	dummyHash    []byte
+	dummyCost    int
+	now          func() time.Time
+}
+
+/*
+NewAuthenticator create an authenticator with no rules. The realm is returned in the WWW-Authenticate header
+*/
+func NewAuthenticator(realm string) *Authenticator {
+	if realm == "" {
+		realm = "webServerBase"
+	}
+	return &Authenticator{
+		realm:        realm,
+		rules:        []*authRule{},
+		users:        make(map[string]*userData),
+		apiKeys:      make(map[string]*apiKeyData),
+		apiKeyHeader: DefaultAPIKeyHeader,
+		jwtSecret:    nil,
+		dummyHash:    nil,
+		dummyCost:    0,
+		now:          time.Now,
+	}
+}
+
+/*
+AddAuthRule requires authentication for ALL urls starting with prefix using one of the schemes.
+The longest matching prefix is used. A rule with no schemes means the prefix does NOT require authentication.
+*/
+func (p *Authenticator) AddAuthRule(prefix string, schemes ...string) {
+	for _, scheme := range schemes {
+		if scheme != AuthSchemeBasic && scheme != AuthSchemeAPIKey && scheme != AuthSchemeBearer {
+			panic("AddAuthRule: Prefix [" + prefix + "] scheme [" + scheme + "] is not supported")
+		}
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.rules = append(p.rules, &authRule{
+		prefix:  prefix,
+		schemes: schemes,
+	})
+}
+
+/*
+AddUser adds a user for HTTP Basic authentication. The password must be a bcrypt hash.
+
+A dummy hash with the highest cost of ALL the users is checked when a user is not found.
+The time taken is then the same as a user with the wrong password so user names cannot be discovered.
+*/
+func (p *Authenticator) AddUser(name string, bcryptHash string, roles ...string) {
+	cost, err := bcrypt.Cost([]byte(bcryptHash))
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if err == nil && cost > p.dummyCost {
+		dummyHash, err := bcrypt.GenerateFromPassword([]byte("dummy-password"), cost)
+		if err == nil {
+			p.dummyHash = dummyHash
+			p.dummyCost = cost
+		}
+	}
+	p.users[name] = &userData{
+		hash:  []byte(bcryptHash),
+		roles: roles,
+	}
+}
+
+/*
+LoadUserFile loads users for HTTP Basic authentication. Each line is name:bcryptHash or name:bcryptHash:role1,role2.
+Empty lines and lines starting with # are ignored.
+*/
+func (p *Authenticator) LoadUserFile(fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return fmt.Errorf("LoadUserFile: User file [%s] could not be opened: %s", fileName, err.Error())
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) < 2 || parts[0] == "" || !strings.HasPrefix(parts[1], "$2") {
+			return fmt.Errorf("LoadUserFile: User file [%s] line %d is not name:bcryptHash[:roles]", fileName, lineNumber)
+		}
+		roles := []string{}
+		if len(parts) == 3 {
+			roles = splitRoles(parts[2], ",")
+		}
+		p.AddUser(parts[0], parts[1], roles...)
+	}
+	return scanner.Err()
+}
+
+/*
+AddAPIKey adds a static API key. The name is the name of the principal
+*/
+func (p *Authenticator) AddAPIKey(key string, name string, roles ...string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.apiKeys[key] = &apiKeyData{
+		name:  name,
+		roles: roles,
+	}
+}
+
+/*
+IsAPIKey returns true if the key is a known API key. Used by the RateLimiter to validate the key header.
+*/
+func (p *Authenticator) IsAPIKey(key string) bool {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	_, found := p.apiKeys[key]
+	return found
+}
+
+/*
+SetAPIKeyHeader set the name of the header containing the API key. Default is X-API-Key
+*/
+func (p *Authenticator) SetAPIKeyHeader(header string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.apiKeyHeader = header
+}
+
+/*
+SetJWTSecret set the secret used to validate HS256 signed bearer tokens
+*/
+func (p *Authenticator) SetJWTSecret(secret []byte) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.jwtSecret = secret
+}
+
+/*
+BeforeHandler vetoes the request with a 401 (Unauthorized) if the credentials are missing or invalid
+or a 403 (Forbidden) if the credentials are valid but the scheme is not accepted for the url.
+
+If the request is authenticated the principal is added to the response.
+*/
+func (p *Authenticator) BeforeHandler(request *http.Request, response *Response) {
+	rule := p.findRule(request.URL.Path)
+	if rule == nil || len(rule.schemes) == 0 {
+		return
+	}
+	principal, err := p.Authenticate(request)
+	if err != nil {
+		invalidToken := strings.HasPrefix(strings.ToLower(request.Header.Get("Authorization")), "bearer ")
+		p.setUnauthorized(response, rule, err.Error(), invalidToken)
+		return
+	}
+	if principal == nil {
+		p.setUnauthorized(response, rule, "Authentication required", false)
+		return
+	}
+	if !containsString(rule.schemes, principal.Scheme) {
+		response.SetErrorResponse(http.StatusForbidden, panicapi.SCForbidden, fmt.Sprintf("Scheme %s is not accepted for %s", principal.Scheme, rule.prefix))
+		return
+	}
+	response.SetPrincipal(principal)
+}
+
+/*
+Authenticate the request. Returns nil, nil if the request does not contain any credentials.
+Returns an error if the credentials are invalid.
+*/
+func (p *Authenticator) Authenticate(request *http.Request) (*Principal, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	authorization := request.Header.Get("Authorization")
+	if authorization != "" {
+		parts := strings.SplitN(authorization, " ", 2)
+		if len(parts) == 2 {
+			switch strings.ToLower(parts[0]) {
+			case "basic":
+				return p.authenticateBasic(request)
+			case "bearer":
+				return p.authenticateBearer(strings.TrimSpace(parts[1]))
+			}
+		}
+		return nil, errors.New("Authorization header is not supported")
+	}
+	key := request.Header.Get(p.apiKeyHeader)
+	if key != "" {
+		apiKey, found := p.apiKeys[key]
+		if !found {
+			return nil, errors.New("API key is invalid")
+		}
+		return &Principal{Name: apiKey.name, Scheme: AuthSchemeAPIKey, Roles: apiKey.roles, Claims: nil}, nil
+	}
+	return nil, nil
+}
+
+/*
+authenticateBasic must be called with the mutex locked!
+*/
+func (p *Authenticator) authenticateBasic(request *http.Request) (*Principal, error) {
+	name, password, ok := request.BasicAuth()
+	if !ok {
+		return nil, errors.New("Basic authorization header is malformed")
+	}
+	user, found := p.users[name]
+	if !found {
+		if p.dummyHash != nil {
+			bcrypt.CompareHashAndPassword(p.dummyHash, []byte(password))
+		}
+		return nil, errors.New("User or password is invalid")
+	}
+	if bcrypt.CompareHashAndPassword(user.hash, []byte(password)) != nil {
+		return nil, errors.New("User or password is invalid")
+	}
+	return &Principal{Name: name, Scheme: AuthSchemeBasic, Roles: user.roles, Claims: nil}, nil
+}
+
+/*
+authenticateBearer must be called with the mutex locked!
+*/
+func (p *Authenticator) authenticateBearer(token string) (*Principal, error) {
+	claims, err := ValidateJWT(token, p.jwtSecret, p.now())
+	if err != nil {
+		return nil, err
+	}
+	name, _ := claims["sub"].(string)
+	if name == "" {
+		return nil, errors.New("JWT does not have a subject (sub)")
+	}
+	return &Principal{Name: name, Scheme: AuthSchemeBearer, Roles: getJWTRoles(claims), Claims: claims}, nil
+}
+
+/*
+getJWTRoles returns the roles from the 'roles' claim. This can be a JSON array of strings or a space separated string.
+*/
+func getJWTRoles(claims map[string]interface{}) []string {
+	switch value := claims["roles"].(type) {
+	case string:
+		return splitRoles(value, " ")
+	case []interface{}:
+		roles := []string{}
+		for _, role := range value {
+			if s, ok := role.(string); ok && s != "" {
+				roles = append(roles, s)
+			}
+		}
+		return roles
+	}
+	return []string{}
+}
+
+func (p *Authenticator) findRule(url string) *authRule {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	var found *authRule
+	for _, rule := range p.rules {
+		if hasPathPrefix(url, rule.prefix) {
+			if found == nil || len(rule.prefix) > len(found.prefix) {
+				found = rule
+			}
+		}
+	}
+	return found
+}
+
+/*
+setUnauthorized returns a 401 with a WWW-Authenticate challenge for each accepted scheme.
+If an invalid bearer token was sent then the Bearer challenge includes error="invalid_token" (RFC 6750)
+*/
+func (p *Authenticator) setUnauthorized(response *Response, rule *authRule, message string, invalidToken bool) {
+	challenges := []string{}
+	for _, scheme := range rule.schemes {
+		switch scheme {
+		case AuthSchemeBasic:
+			challenges = append(challenges, "Basic realm=\""+p.realm+"\", charset=\"UTF-8\"")
+		case AuthSchemeBearer:
+			if invalidToken {
+				challenges = append(challenges, "Bearer realm=\""+p.realm+"\", error=\"invalid_token\"")
+			} else {
+				challenges = append(challenges, "Bearer realm=\""+p.realm+"\"")
+			}
+		case AuthSchemeAPIKey:
+			challenges = append(challenges, AuthSchemeAPIKey+" realm=\""+p.realm+"\", header=\""+p.apiKeyHeader+"\"")
+		}
+	}
+	response.AddHeader("WWW-Authenticate", challenges)
+	response.SetErrorResponse(http.StatusUnauthorized, panicapi.SCUnauthorized, message)
+}
+
+func splitRoles(roles string, separator string) []string {
+	list := []string{}
+	for _, role := range strings.Split(roles, separator) {
+		role = strings.TrimSpace(role)
+		if role != "" {
+			list = append(list, role)
+		}
+	}
+	return list
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
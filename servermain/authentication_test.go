@@ -0,0 +1,213 @@
+package servermain
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stuartdd/webServerBase/panicapi"
+	"github.com/stuartdd/webServerBase/test"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testJWTSecret = []byte("secret")
+
+func TestAuthBasic(t *testing.T) {
+	auth := NewAuthenticator("TestRealm")
+	auth.AddAuthRule("/admin/", AuthSchemeBasic)
+	auth.AddUser("fred", testPasswordHash(t, "pw"))
+
+	resp, req := authTest(auth, "/admin/stop", func(r *http.Request) { r.SetBasicAuth("fred", "pw") })
+	test.AssertIntEqual(t, "OK", resp.GetCode(), 200)
+	test.AssertStringEquals(t, "Name", resp.GetPrincipal().Name, "fred")
+	test.AssertStringEquals(t, "Scheme", resp.GetPrincipal().Scheme, AuthSchemeBasic)
+	test.AssertStringEquals(t, "URL", req.URL.Path, "/admin/stop")
+
+	resp, _ = authTest(auth, "/admin/stop", func(r *http.Request) { r.SetBasicAuth("fred", "wrong") })
+	test.AssertIntEqual(t, "Bad password", resp.GetCode(), 401)
+	test.AssertIntEqual(t, "", resp.GetSubCode(), panicapi.SCUnauthorized)
+	test.AssertStringEquals(t, "", resp.GetHeaders()["WWW-Authenticate"][0], "Basic realm=\"TestRealm\", charset=\"UTF-8\"")
+
+	resp, _ = authTest(auth, "/admin/stop", func(r *http.Request) { r.SetBasicAuth("bob", "pw") })
+	test.AssertIntEqual(t, "Bad user", resp.GetCode(), 401)
+
+	resp, _ = authTest(auth, "/admin/stop", nil)
+	test.AssertIntEqual(t, "No credentials", resp.GetCode(), 401)
+	test.AssertStringEquals(t, "", resp.GetErrorMessage(), "Authentication required")
+
+	resp, _ = authTest(auth, "/status", nil)
+	test.AssertIntEqual(t, "No rule", resp.GetCode(), 200)
+	test.AssertBoolTrue(t, "No principal", resp.GetPrincipal() == nil)
+}
+
+func TestAuthUserFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	test.AssertErrorIsNil(t, "", err)
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "users.txt")
+	content := "# Users\n\nfred:" + testPasswordHash(t, "pw") + "\nbob:" + testPasswordHash(t, "pw") + ":admin, builder\n"
+	test.AssertErrorIsNil(t, "", ioutil.WriteFile(fileName, []byte(content), 0644))
+
+	auth := NewAuthenticator("")
+	test.AssertErrorIsNil(t, "", auth.LoadUserFile(fileName))
+	auth.AddAuthRule("/", AuthSchemeBasic)
+	resp, _ := authTest(auth, "/x", func(r *http.Request) { r.SetBasicAuth("fred", "pw") })
+	test.AssertIntEqual(t, "OK", resp.GetCode(), 200)
+	test.AssertIntEqual(t, "No roles", len(resp.GetPrincipal().Roles), 0)
+	resp, _ = authTest(auth, "/x", func(r *http.Request) { r.SetBasicAuth("bob", "pw") })
+	test.AssertBoolTrue(t, "Roles", resp.GetPrincipal().HasAnyRole([]string{"builder"}))
+
+	test.AssertErrorIsNil(t, "", ioutil.WriteFile(fileName, []byte("fred:notAHash\n"), 0644))
+	test.AssertErrorTextContains(t, "", NewAuthenticator("").LoadUserFile(fileName), "line 1")
+	test.AssertErrorTextContains(t, "", NewAuthenticator("").LoadUserFile(filepath.Join(dir, "missing.txt")), "could not be opened")
+}
+
+func TestAuthAPIKey(t *testing.T) {
+	auth := NewAuthenticator("TestRealm")
+	auth.AddAuthRule("/script/", AuthSchemeAPIKey)
+	auth.AddAPIKey("KEY1", "client1")
+
+	resp, _ := authTest(auth, "/script/list", func(r *http.Request) { r.Header.Set(DefaultAPIKeyHeader, "KEY1") })
+	test.AssertIntEqual(t, "OK", resp.GetCode(), 200)
+	test.AssertStringEquals(t, "", resp.GetPrincipal().Name, "client1")
+
+	resp, _ = authTest(auth, "/script/list", func(r *http.Request) { r.Header.Set(DefaultAPIKeyHeader, "KEY2") })
+	test.AssertIntEqual(t, "Bad key", resp.GetCode(), 401)
+	resp, _ = authTest(auth, "//script/list", func(r *http.Request) {})
+	test.AssertIntEqual(t, "Leading slashes", resp.GetCode(), 401)
+	test.AssertStringEquals(t, "", resp.GetHeaders()["WWW-Authenticate"][0], "ApiKey realm=\"TestRealm\", header=\"X-API-Key\"")
+
+	auth.SetAPIKeyHeader("X-Key")
+	resp, _ = authTest(auth, "/script/list", func(r *http.Request) { r.Header.Set("X-Key", "KEY1") })
+	test.AssertIntEqual(t, "Header", resp.GetCode(), 200)
+}
+
+func TestAuthBearer(t *testing.T) {
+	now := time.Now()
+	auth := NewAuthenticator("TestRealm")
+	auth.now = func() time.Time { return now }
+	auth.SetJWTSecret(testJWTSecret)
+	auth.AddAuthRule("/api/", AuthSchemeBearer)
+
+	token := testJWT(t, map[string]interface{}{"sub": "fred", "exp": now.Add(time.Minute).Unix()}, testJWTSecret)
+	resp, _ := authTest(auth, "/api/x", bearer(token))
+	test.AssertIntEqual(t, "OK", resp.GetCode(), 200)
+	test.AssertStringEquals(t, "", resp.GetPrincipal().Name, "fred")
+	test.AssertStringEquals(t, "", resp.GetPrincipal().Claims["sub"].(string), "fred")
+
+	resp, _ = authTest(auth, "/api/x", nil)
+	test.AssertIntEqual(t, "None", resp.GetCode(), 401)
+	test.AssertStringEquals(t, "", resp.GetHeaders()["WWW-Authenticate"][0], "Bearer realm=\"TestRealm\"")
+
+	now = now.Add(2 * time.Minute)
+	resp, _ = authTest(auth, "/api/x", bearer(token))
+	test.AssertIntEqual(t, "Expired", resp.GetCode(), 401)
+	test.AssertStringEquals(t, "", resp.GetErrorMessage(), "JWT has expired")
+	test.AssertStringEquals(t, "", resp.GetHeaders()["WWW-Authenticate"][0], "Bearer realm=\"TestRealm\", error=\"invalid_token\"")
+
+	token = testJWT(t, map[string]interface{}{"sub": "fred"}, []byte("other"))
+	resp, _ = authTest(auth, "/api/x", bearer(token))
+	test.AssertStringEquals(t, "Bad signature", resp.GetErrorMessage(), "JWT signature is invalid")
+
+	exp := now.Add(time.Hour).Unix()
+	token = testJWT(t, map[string]interface{}{"nbf": now.Add(time.Minute).Unix(), "exp": exp}, testJWTSecret)
+	resp, _ = authTest(auth, "/api/x", bearer(token))
+	test.AssertStringEquals(t, "Not before", resp.GetErrorMessage(), "JWT is not valid yet")
+
+	token = testJWT(t, map[string]interface{}{"sub": "fred"}, testJWTSecret)
+	resp, _ = authTest(auth, "/api/x", bearer(token))
+	test.AssertStringEquals(t, "No expiry", resp.GetErrorMessage(), "JWT does not have a numeric expiry (exp)")
+
+	token = testJWT(t, map[string]interface{}{"sub": "fred", "exp": "never"}, testJWTSecret)
+	resp, _ = authTest(auth, "/api/x", bearer(token))
+	test.AssertStringEquals(t, "String expiry", resp.GetErrorMessage(), "JWT does not have a numeric expiry (exp)")
+
+	token = testJWT(t, map[string]interface{}{"sub": "fred", "exp": exp, "nbf": "now"}, testJWTSecret)
+	resp, _ = authTest(auth, "/api/x", bearer(token))
+	test.AssertStringEquals(t, "String not before", resp.GetErrorMessage(), "JWT not before (nbf) is not numeric")
+
+	resp, _ = authTest(auth, "/api/x", bearer("a.b"))
+	test.AssertStringEquals(t, "Malformed", resp.GetErrorMessage(), "JWT is malformed")
+}
+
+func TestAuthSchemeNotAcceptedAndLongestPrefix(t *testing.T) {
+	auth := NewAuthenticator("TestRealm")
+	auth.AddAuthRule("/", AuthSchemeBasic, AuthSchemeAPIKey)
+	auth.AddAuthRule("/admin/", AuthSchemeBasic)
+	auth.AddAuthRule("/health/")
+	auth.AddAuthRule("/api", AuthSchemeBasic)
+	auth.AddAPIKey("KEY1", "client1")
+	apiKey := func(r *http.Request) { r.Header.Set(DefaultAPIKeyHeader, "KEY1") }
+
+	resp, _ := authTest(auth, "/script/list", apiKey)
+	test.AssertIntEqual(t, "Root", resp.GetCode(), 200)
+
+	resp, _ = authTest(auth, "/admin/stop", apiKey)
+	test.AssertIntEqual(t, "Admin", resp.GetCode(), 403)
+	test.AssertIntEqual(t, "", resp.GetSubCode(), panicapi.SCForbidden)
+	test.AssertStringEquals(t, "", resp.GetErrorMessage(), "Scheme ApiKey is not accepted for /admin/")
+
+	resp, _ = authTest(auth, "/health/live", nil)
+	test.AssertIntEqual(t, "Health", resp.GetCode(), 200)
+	resp, _ = authTest(auth, "/healthx", apiKey)
+	test.AssertIntEqual(t, "Sibling of /health/ uses the root rule", resp.GetCode(), 200)
+	resp, _ = authTest(auth, "/api/x", apiKey)
+	test.AssertIntEqual(t, "Api", resp.GetCode(), 403)
+	resp, _ = authTest(auth, "/apix", apiKey)
+	test.AssertIntEqual(t, "Sibling of /api uses the root rule", resp.GetCode(), 200)
+	resp, _ = authTest(auth, "/api-internal/x", apiKey)
+	test.AssertIntEqual(t, "Sibling of /api uses the root rule", resp.GetCode(), 200)
+
+	resp, _ = authTest(auth, "/status", nil)
+	test.AssertIntEqual(t, "Status", resp.GetCode(), 401)
+	test.AssertIntEqual(t, "Challenges", len(resp.GetHeaders()["WWW-Authenticate"]), 2)
+}
+
+func authTest(auth *Authenticator, url string, setup func(*http.Request)) (*Response, *http.Request) {
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	if setup != nil {
+		setup(req)
+	}
+	response := NewResponse(nil, nil, "TXID")
+	auth.BeforeHandler(req, response)
+	return response, req
+}
+
+func bearer(token string) func(*http.Request) {
+	return func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+func TestAuthDummyHashCost(t *testing.T) {
+	auth := NewAuthenticator("")
+	test.AssertBoolTrue(t, "No users", auth.dummyHash == nil)
+	for _, cost := range []int{5, 6, 4} {
+		hash, err := bcrypt.GenerateFromPassword([]byte("pw"), cost)
+		test.AssertErrorIsNil(t, "", err)
+		auth.AddUser("user"+strconv.Itoa(cost), string(hash))
+	}
+	/*
+		An unknown user takes as long as the slowest known user
+	*/
+	cost, err := bcrypt.Cost(auth.dummyHash)
+	test.AssertErrorIsNil(t, "", err)
+	test.AssertIntEqual(t, "Highest cost", cost, 6)
+}
+
+func testJWT(t *testing.T, claims map[string]interface{}, secret []byte) string {
+	token, err := CreateJWT(claims, secret)
+	test.AssertErrorIsNil(t, "", err)
+	return token
+}
+
+func testPasswordHash(t *testing.T, password string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	test.AssertErrorIsNil(t, "", err)
+	return string(hash)
+}
@@ -0,0 +1,42 @@
+package servermain
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/stuartdd/webServerBase/panicapi"
+)
+
+/*
+IsAuthorized returns true if no roles are required or the principal (see Authenticator) has one of the roles.
+
+If not authorized the response is set to a 403 and the denial is written to the ACCESS log with the transaction ID.
+
+A 401 is not returned when there is no principal as it requires a WWW-Authenticate challenge. Routes protected by an
+Authenticator rule are challenged by Authenticator.BeforeHandler before this is called so no principal here means that
+no Authenticator covers the route.
+*/
+func (p *ServerInstanceData) IsAuthorized(request *http.Request, response *Response, roles []string) bool {
+	if len(roles) == 0 {
+		return true
+	}
+	principal := response.GetPrincipal()
+	if principal == nil {
+		p.logAccessDenied(request, response, "<none>", roles)
+		response.SetErrorResponse(http.StatusForbidden, panicapi.SCAccessDenied, fmt.Sprintf("Access denied. Requires an authenticated principal with role %s", strings.Join(roles, " or ")))
+		return false
+	}
+	if !principal.HasAnyRole(roles) {
+		p.logAccessDenied(request, response, principal.Name, roles)
+		response.SetErrorResponse(http.StatusForbidden, panicapi.SCAccessDenied, fmt.Sprintf("Access denied. Requires role %s", strings.Join(roles, " or ")))
+		return false
+	}
+	return true
+}
+
+func (p *ServerInstanceData) logAccessDenied(request *http.Request, response *Response, principalName string, roles []string) {
+	if p.logger.IsAccess() {
+		p.logger.LogAccessf("ID: %s <<< ACCESS DENIED: METHOD=%s: REQUEST=%s PRINCIPAL=%s REQUIRED-ROLES=%s", response.GetTransactionID(), request.Method, request.URL.Path, principalName, strings.Join(roles, ","))
+	}
+}
@@ -0,0 +1,102 @@
+package servermain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stuartdd/webServerBase/logging"
+	"github.com/stuartdd/webServerBase/panicapi"
+	"github.com/stuartdd/webServerBase/test"
+)
+
+func TestAuthorizationMappedRoutesAndScripts(t *testing.T) {
+	logging.CreateTestLogger("TestAuthorization")
+	auth := NewAuthenticator("TestRealm")
+	auth.AddAuthRule("/admin/", AuthSchemeAPIKey)
+	auth.AddAuthRule("/script/", AuthSchemeAPIKey)
+	auth.AddAPIKey("ADMIN", "admin1", "admin", "builder")
+	auth.AddAPIKey("USER", "user1", "user")
+
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.AddBeforeHandler(auth.BeforeHandler)
+	server.AddMappedHandler("/admin/stop", http.MethodGet, func(r *http.Request, response *Response) {
+		response.SetResponse(200, "Stopped by "+NewRequestHandlerHelper(r, response).GetPrincipal().Name, "")
+	}, "admin")
+	server.AddMappedHandler("/open", http.MethodGet, func(r *http.Request, response *Response) {
+		response.SetResponse(200, "Open", "")
+	})
+	server.AddMappedHandler("/closed", http.MethodGet, func(r *http.Request, response *Response) {
+		response.SetResponse(200, "Closed", "")
+	}, "admin")
+	server.AddMappedHandlerWithNames("/script/?", http.MethodGet, DefaultOSScriptHandler, []string{"script"})
+	server.SetOsScriptsData(os.TempDir(), map[string][]string{"build": {"build.sh"}, "test": {"test.sh"}})
+	server.SetOsScriptRoles(map[string][]string{"build": {"builder"}})
+
+	rec := authorizationTest(server, "/admin/stop", "ADMIN")
+	test.AssertIntEqual(t, "Admin", rec.Code, 200)
+	test.AssertStringEquals(t, "", rec.Body.String(), "Stopped by admin1")
+
+	rec = authorizationTest(server, "/admin/stop", "USER")
+	test.AssertIntEqual(t, "User", rec.Code, 403)
+	test.AssertStringContains(t, "", rec.Body.String(), "\"Code\":"+strconv.Itoa(panicapi.SCAccessDenied), "Requires role admin")
+
+	rec = authorizationTest(server, "/open", "")
+	test.AssertIntEqual(t, "Open", rec.Code, 200)
+
+	rec = authorizationTest(server, "/closed", "")
+	test.AssertIntEqual(t, "No principal", rec.Code, 403)
+	test.AssertStringContains(t, "", rec.Body.String(), "\"Code\":"+strconv.Itoa(panicapi.SCAccessDenied), "Requires an authenticated principal with role admin")
+	test.AssertStringEquals(t, "No challenge", rec.Header().Get("WWW-Authenticate"), "")
+
+	rec = authorizationTest(server, "/script/build", "USER")
+	test.AssertIntEqual(t, "Build script", rec.Code, 403)
+	test.AssertStringContains(t, "", rec.Body.String(), "Requires role builder")
+	/*
+		The role check is done before the script is looked up
+	*/
+	server.SetOsScriptsData(os.TempDir(), map[string][]string{"test": {"test.sh"}})
+	rec = authorizationTest(server, "/script/build", "USER")
+	test.AssertIntEqual(t, "Removed build script", rec.Code, 403)
+	server.SetOsScriptsData(os.TempDir(), map[string][]string{"build": {"build.sh"}, "test": {"test.sh"}})
+	/*
+		No roles are required for the test script so it is run (and fails as it does not exist)
+	*/
+	rec = authorizationTest(server, "/script/test", "USER")
+	test.AssertIntEqual(t, "Test script", rec.Code, 417)
+}
+
+func TestPrincipalRoles(t *testing.T) {
+	principal := &Principal{Name: "fred", Roles: []string{"a", "b"}}
+	test.AssertBoolTrue(t, "", principal.HasAnyRole([]string{"x", "b"}))
+	test.AssertBoolFalse(t, "", principal.HasAnyRole([]string{"x", "y"}))
+	test.AssertBoolFalse(t, "", principal.HasAnyRole([]string{}))
+
+	test.AssertStringEquals(t, "Array", joinRoles(getJWTRoles(map[string]interface{}{"roles": []interface{}{"a", 1, "b"}})), "a,b")
+	test.AssertStringEquals(t, "String", joinRoles(getJWTRoles(map[string]interface{}{"roles": " a  b "})), "a,b")
+	test.AssertStringEquals(t, "None", joinRoles(getJWTRoles(map[string]interface{}{})), "")
+	test.AssertStringEquals(t, "Split", joinRoles(splitRoles("admin, builder,,", ",")), "admin,builder")
+}
+
+func authorizationTest(server *ServerInstanceData, url string, apiKey string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	if apiKey != "" {
+		req.Header.Set(DefaultAPIKeyHeader, apiKey)
+	}
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	return rec
+}
+
+func joinRoles(roles []string) string {
+	s := ""
+	for i, role := range roles {
+		if i > 0 {
+			s = s + ","
+		}
+		s = s + role
+	}
+	return s
+}
@@ -0,0 +1,190 @@
+package servermain
+
+import (
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/stuartdd/webServerBase/panicapi"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+bodyFormat - The format of a request body derived from the Content-Type header
+*/
+type bodyFormat int
+
+const (
+	bodyFormatUnsupported bodyFormat = iota
+	bodyFormatJSON
+	bodyFormatXML
+	bodyFormatYAML
+	bodyFormatForm
+)
+
+/*
+getBodyFormat returns the body format for a Content-Type header value.
+A missing Content-Type is treated as JSON.
+*/
+func getBodyFormat(contentType string) (bodyFormat, string) {
+	if strings.TrimSpace(contentType) == "" {
+		return bodyFormatJSON, ""
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return bodyFormatUnsupported, contentType
+	}
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return bodyFormatJSON, mediaType
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return bodyFormatXML, mediaType
+	case mediaType == "application/yaml" || mediaType == "application/x-yaml" || mediaType == "text/yaml" || mediaType == "text/x-yaml":
+		return bodyFormatYAML, mediaType
+	case mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data":
+		return bodyFormatForm, mediaType
+	}
+	return bodyFormatUnsupported, mediaType
+}
+
+func decodeXMLBody(body []byte, object interface{}) {
+	err := xml.Unmarshal(body, object)
+	if err != nil {
+		panicapi.ThrowError(400, panicapi.SCInvalidXMLRequest, "Invalid XML in request body", err.Error())
+	}
+}
+
+func decodeYAMLBody(body []byte, object interface{}) {
+	err := yaml.Unmarshal(body, object)
+	if err != nil {
+		panicapi.ThrowError(400, panicapi.SCInvalidYAMLRequest, "Invalid YAML in request body", err.Error())
+	}
+}
+
+/*
+decodeFormValues populates object from form values. object must be a pointer to a struct or a map.
+
+Struct fields are matched using the 'form' tag or the field name (case is ignored).
+Fields can be strings, numbers, booleans or slices of these. Maps can be map[string]string,
+map[string][]string or map[string]interface{}.
+*/
+func decodeFormValues(values url.Values, object interface{}) {
+	target := reflect.ValueOf(object)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		panicapi.ThrowError(500, panicapi.SCRuntimeError, "Invalid form target", fmt.Sprintf("decodeFormValues: target type [%T] is not a pointer", object))
+	}
+	target = target.Elem()
+	switch target.Kind() {
+	case reflect.Struct:
+		decodeFormStruct(values, target)
+	case reflect.Map:
+		decodeFormMap(values, target)
+	default:
+		panicapi.ThrowError(500, panicapi.SCRuntimeError, "Invalid form target", fmt.Sprintf("decodeFormValues: target type [%T] is not a struct or map", object))
+	}
+}
+
+func decodeFormStruct(values url.Values, target reflect.Value) {
+	targetType := target.Type()
+	for i := 0; i < targetType.NumField(); i++ {
+		field := targetType.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Tag.Get("form")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		list := findFormValues(values, name)
+		if len(list) == 0 {
+			continue
+		}
+		setFormField(target.Field(i), name, list)
+	}
+}
+
+func findFormValues(values url.Values, name string) []string {
+	if list, ok := values[name]; ok {
+		return list
+	}
+	for key, list := range values {
+		if strings.EqualFold(key, name) {
+			return list
+		}
+	}
+	return nil
+}
+
+func setFormField(field reflect.Value, name string, list []string) {
+	if field.Kind() == reflect.Slice {
+		slice := reflect.MakeSlice(field.Type(), len(list), len(list))
+		for i, value := range list {
+			setFormValue(slice.Index(i), name, value)
+		}
+		field.Set(slice)
+		return
+	}
+	setFormValue(field, name, list[0])
+}
+
+func setFormValue(field reflect.Value, name string, value string) {
+	var err error
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(value)
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		i, err = strconv.ParseInt(value, 10, field.Type().Bits())
+		field.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var u uint64
+		u, err = strconv.ParseUint(value, 10, field.Type().Bits())
+		field.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		f, err = strconv.ParseFloat(value, field.Type().Bits())
+		field.SetFloat(f)
+	default:
+		panicapi.ThrowError(500, panicapi.SCRuntimeError, "Invalid form target", fmt.Sprintf("decodeFormValues: field [%s] type [%s] is not supported", name, field.Type()))
+	}
+	if err != nil {
+		panicapi.ThrowError(400, panicapi.SCInvalidFormRequest, fmt.Sprintf("Invalid form field '%s'", name), err.Error())
+	}
+}
+
+func decodeFormMap(values url.Values, target reflect.Value) {
+	if target.IsNil() {
+		target.Set(reflect.MakeMap(target.Type()))
+	}
+	switch m := target.Interface().(type) {
+	case map[string]string:
+		for name, list := range values {
+			m[name] = list[0]
+		}
+	case map[string][]string:
+		for name, list := range values {
+			m[name] = list
+		}
+	case map[string]interface{}:
+		for name, list := range values {
+			if len(list) == 1 {
+				m[name] = list[0]
+			} else {
+				m[name] = list
+			}
+		}
+	default:
+		panicapi.ThrowError(500, panicapi.SCRuntimeError, "Invalid form target", fmt.Sprintf("decodeFormValues: map type [%s] is not supported", target.Type()))
+	}
+}
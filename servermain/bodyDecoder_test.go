@@ -0,0 +1,82 @@
+package servermain
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stuartdd/webServerBase/panicapi"
+	"github.com/stuartdd/webServerBase/test"
+)
+
+type decoderTestStruct struct {
+	Name  string   `json:"name" xml:"name" yaml:"name" form:"name"`
+	Count int      `json:"count" xml:"count" yaml:"count" form:"count"`
+	Tags  []string `json:"tags" xml:"tag" yaml:"tags" form:"tag"`
+	Live  bool     `json:"live" xml:"live" yaml:"live"`
+}
+
+func TestGetBodyAsObject(t *testing.T) {
+	obj := decodeTest(t, "application/json; charset=utf-8", `{"name":"Fruit","count":3,"tags":["a","b"],"live":true}`)
+	assertDecoded(t, "JSON", obj)
+	obj = decodeTest(t, "", `{"name":"Fruit","count":3,"tags":["a","b"],"live":true}`)
+	assertDecoded(t, "No Content-Type", obj)
+	obj = decodeTest(t, "application/xml", `<item><name>Fruit</name><count>3</count><tag>a</tag><tag>b</tag><live>true</live></item>`)
+	assertDecoded(t, "XML", obj)
+	obj = decodeTest(t, "text/xml", `<item><name>Fruit</name><count>3</count><tag>a</tag><tag>b</tag><live>true</live></item>`)
+	assertDecoded(t, "text XML", obj)
+	obj = decodeTest(t, "application/x-yaml", "name: Fruit\ncount: 3\ntags:\n  - a\n  - b\nlive: true\n")
+	assertDecoded(t, "YAML", obj)
+	obj = decodeTest(t, "application/x-www-form-urlencoded", "name=Fruit&count=3&tag=a&tag=b&LIVE=true")
+	assertDecoded(t, "Form", obj)
+}
+
+func TestGetBodyAsObjectFormMap(t *testing.T) {
+	d := decoderHelper(t, "application/x-www-form-urlencoded", "a=1&b=2&b=3")
+	m := make(map[string]interface{})
+	d.GetBodyAsObject(&m)
+	test.AssertStringEquals(t, "", m["a"].(string), "1")
+	test.AssertIntEqual(t, "", len(m["b"].([]string)), 2)
+}
+
+func TestGetBodyAsObjectErrors(t *testing.T) {
+	decodeErrorTest(t, "text/plain", "abc", 415, panicapi.SCUnsupportedMediaType)
+	decodeErrorTest(t, "application/xml", "<item><name>", 400, panicapi.SCInvalidXMLRequest)
+	decodeErrorTest(t, "application/yaml", "name: [", 400, panicapi.SCInvalidYAMLRequest)
+	decodeErrorTest(t, "application/json", "{", 400, panicapi.SCInvalidJSONRequest)
+	decodeErrorTest(t, "application/x-www-form-urlencoded", "count=abc", 400, panicapi.SCInvalidFormRequest)
+}
+
+func decoderHelper(t *testing.T, contentType string, body string) *RequestHandlerHelper {
+	req, err := http.NewRequest("POST", "http://abc:8080/data", strings.NewReader(body))
+	if err != nil {
+		test.Fail(t, "", err.Error())
+	}
+	if contentType != "" {
+		req.Header.Set(ContentTypeName, contentType)
+	}
+	return NewRequestHandlerHelper(req, NewResponse(nil, nil, "TXID"))
+}
+
+func decodeTest(t *testing.T, contentType string, body string) *decoderTestStruct {
+	obj := &decoderTestStruct{}
+	decoderHelper(t, contentType, body).GetBodyAsObject(obj)
+	return obj
+}
+
+func decodeErrorTest(t *testing.T, contentType string, body string, status int, subCode int) {
+	defer func() {
+		state := panicapi.GetPanicData(recover(), "TXID")
+		test.AssertIntEqual(t, contentType, state.StatusCode, status)
+		test.AssertIntEqual(t, contentType, state.SubCode, subCode)
+	}()
+	decodeTest(t, contentType, body)
+}
+
+func assertDecoded(t *testing.T, info string, obj *decoderTestStruct) {
+	test.AssertStringEquals(t, info, obj.Name, "Fruit")
+	test.AssertIntEqual(t, info, obj.Count, 3)
+	test.AssertIntEqual(t, info, len(obj.Tags), 2)
+	test.AssertStringEquals(t, info, obj.Tags[1], "b")
+	test.AssertBoolTrue(t, info, obj.Live)
+}
@@ -0,0 +1,311 @@
+package servermain
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/stuartdd/webServerBase/panicapi"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+ResponseEncoder converts a response object (struct, map, slice...) in to the bytes for a content type.
+Return ErrNotEncodable if the object cannot be represented in the content type so another acceptable type is tried.
+*/
+type ResponseEncoder func(object interface{}) ([]byte, error)
+
+/*
+ErrNotEncodable is returned by a ResponseEncoder if the object cannot be represented in it's content type.
+For example the CSV encoder can only encode slices of structs.
+*/
+var ErrNotEncodable = errors.New("The response cannot be encoded for this content type")
+
+/*
+responseEncoders the encoders by content type. contentTypes retains the order they were added.
+*/
+type responseEncoders struct {
+	contentTypes []string
+	encoders     map[string]ResponseEncoder
+}
+
+/*
+acceptRange a media range from the Accept header
+*/
+type acceptRange struct {
+	mediaType string
+	quality   float64
+}
+
+func newResponseEncoders() *responseEncoders {
+	p := &responseEncoders{
+		contentTypes: []string{},
+		encoders:     make(map[string]ResponseEncoder),
+	}
+	p.add("application/json", encodeJSON)
+	p.add("application/xml", encodeXML)
+	p.add("application/yaml", encodeYAML)
+	p.add("text/csv", encodeCSV)
+	p.add("text/xml", encodeXML)
+	p.add("application/x-yaml", encodeYAML)
+	p.add("text/yaml", encodeYAML)
+	return p
+}
+
+func (p *responseEncoders) add(contentType string, encoder ResponseEncoder) {
+	contentType = strings.ToLower(contentType)
+	if _, found := p.encoders[contentType]; !found {
+		p.contentTypes = append(p.contentTypes, contentType)
+	}
+	p.encoders[contentType] = encoder
+}
+
+/*
+AddResponseEncoder add (or replace) the encoder for a content type. See Response.SetResponse.
+JSON, XML, YAML and CSV encoders are added by default.
+*/
+func (p *ServerInstanceData) AddResponseEncoder(contentType string, encoder ResponseEncoder) {
+	if contentType == "" || encoder == nil {
+		panic("AddResponseEncoder: A content type and an encoder are required")
+	}
+	p.encoders.add(contentType, encoder)
+}
+
+/*
+negotiateContent encodes a response object (NOT a string) using the content type that best matches the request Accept header.
+
+If Accept is missing or allows any type then the content type given to SetResponse is used (JSON if that is not defined).
+If none of the acceptable types can encode the object the response is set to a 406 (Not Acceptable).
+*/
+func (p *ServerInstanceData) negotiateContent(request *http.Request, response *Response) {
+	object := response.response.resp
+	if object == nil {
+		return
+	}
+	if _, isString := object.(string); isString {
+		return
+	}
+	response.AddHeader("Vary", []string{"Accept"})
+	preferred, _, _ := mime.ParseMediaType(response.GetContentType())
+	if _, found := p.encoders.encoders[preferred]; !found {
+		preferred = "application/json"
+	}
+	for _, contentType := range p.encoders.candidates(request.Header.Get("Accept"), preferred) {
+		encoded, err := p.encoders.encoders[contentType](object)
+		if err == ErrNotEncodable {
+			continue
+		}
+		if err != nil {
+			panicapi.ThrowError(500, panicapi.SCResponseEncoding, "Failed to encode response", fmt.Sprintf("Encode response type [%T] as %s failed: %s", object, contentType, err.Error()))
+		}
+		response.SetResponse(response.GetCode(), string(encoded), contentType)
+		return
+	}
+	response.SetErrorResponse(406, panicapi.SCNotAcceptable, fmt.Sprintf("Accept:%s. Available:%s", request.Header.Get("Accept"), strings.Join(p.encoders.contentTypes, ",")))
+}
+
+/*
+candidates returns the content types that are acceptable in order of preference
+*/
+func (p *responseEncoders) candidates(accept string, preferred string) []string {
+	if strings.TrimSpace(accept) == "" {
+		return []string{preferred}
+	}
+	list := []string{}
+	added := make(map[string]bool)
+	addType := func(contentType string) {
+		if !added[contentType] {
+			added[contentType] = true
+			list = append(list, contentType)
+		}
+	}
+	for _, r := range parseAccept(accept) {
+		switch {
+		case r.mediaType == "*/*":
+			addType(preferred)
+			for _, contentType := range p.contentTypes {
+				addType(contentType)
+			}
+		case strings.HasSuffix(r.mediaType, "/*"):
+			prefix := strings.TrimSuffix(r.mediaType, "*")
+			if strings.HasPrefix(preferred, prefix) {
+				addType(preferred)
+			}
+			for _, contentType := range p.contentTypes {
+				if strings.HasPrefix(contentType, prefix) {
+					addType(contentType)
+				}
+			}
+		default:
+			if _, found := p.encoders[r.mediaType]; found {
+				addType(r.mediaType)
+			}
+		}
+	}
+	return list
+}
+
+/*
+parseAccept returns the media ranges in the Accept header ordered by quality. Ranges with q=0 are removed.
+*/
+func parseAccept(accept string) []*acceptRange {
+	ranges := []*acceptRange{}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, found := params["q"]; found {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if quality > 0 {
+			ranges = append(ranges, &acceptRange{mediaType: mediaType, quality: quality})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].quality > ranges[j].quality
+	})
+	return ranges
+}
+
+func encodeJSON(object interface{}) ([]byte, error) {
+	return json.Marshal(object)
+}
+
+func encodeYAML(object interface{}) ([]byte, error) {
+	return yaml.Marshal(object)
+}
+
+/*
+encodeXML encodes structs using encoding/xml (so xml tags are used).
+Maps and slices (not supported by encoding/xml) are converted to elements in a 'response' element.
+Map keys are element names and slice values are 'item' elements.
+*/
+func encodeXML(object interface{}) ([]byte, error) {
+	value := reflect.Indirect(reflect.ValueOf(object))
+	if value.Kind() == reflect.Struct {
+		encoded, err := xml.Marshal(object)
+		if err == nil {
+			return append([]byte(xml.Header), encoded...), nil
+		}
+	}
+	jsonBytes, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	var document interface{}
+	err = json.Unmarshal(jsonBytes, &document)
+	if err != nil {
+		return nil, err
+	}
+	buffer := bytes.NewBufferString(xml.Header)
+	writeXMLElement(buffer, "response", document)
+	return buffer.Bytes(), nil
+}
+
+func writeXMLElement(buffer *bytes.Buffer, name string, value interface{}) {
+	buffer.WriteString("<" + name + ">")
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			writeXMLElement(buffer, xmlElementName(key), v[key])
+		}
+	case []interface{}:
+		for _, item := range v {
+			writeXMLElement(buffer, "item", item)
+		}
+	case nil:
+	default:
+		xml.EscapeText(buffer, []byte(fmt.Sprintf("%v", v)))
+	}
+	buffer.WriteString("</" + name + ">")
+}
+
+/*
+xmlElementName replaces characters that are not allowed in an XML element name with '_'
+*/
+func xmlElementName(name string) string {
+	var sb strings.Builder
+	for i, c := range name {
+		switch {
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+			sb.WriteRune(c)
+		case i > 0 && (c == '-' || c == '.' || (c >= '0' && c <= '9')):
+			sb.WriteRune(c)
+		default:
+			sb.WriteRune('_')
+		}
+	}
+	if sb.Len() == 0 {
+		return "_"
+	}
+	return sb.String()
+}
+
+/*
+encodeCSV encodes a slice of structs (or pointers to structs). The first row contains the field names
+(the json tag name if defined). Returns ErrNotEncodable for anything else.
+*/
+func encodeCSV(object interface{}) ([]byte, error) {
+	value := reflect.Indirect(reflect.ValueOf(object))
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return nil, ErrNotEncodable
+	}
+	elemType := value.Type().Elem()
+	if elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return nil, ErrNotEncodable
+	}
+	fields := []int{}
+	header := []string{}
+	for i := 0; i < elemType.NumField(); i++ {
+		field := elemType.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, i)
+		header = append(header, name)
+	}
+	buffer := &bytes.Buffer{}
+	writer := csv.NewWriter(buffer)
+	writer.Write(header)
+	for i := 0; i < value.Len(); i++ {
+		elem := reflect.Indirect(value.Index(i))
+		row := make([]string, len(fields))
+		if elem.IsValid() {
+			for j, index := range fields {
+				row[j] = fmt.Sprintf("%v", elem.Field(index).Interface())
+			}
+		}
+		writer.Write(row)
+	}
+	writer.Flush()
+	return buffer.Bytes(), writer.Error()
+}
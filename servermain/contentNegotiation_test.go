@@ -0,0 +1,91 @@
+package servermain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stuartdd/webServerBase/logging"
+	"github.com/stuartdd/webServerBase/panicapi"
+	"github.com/stuartdd/webServerBase/test"
+)
+
+type negotiationTestRow struct {
+	Name  string `json:"name" xml:"name"`
+	Count int    `json:"count" xml:"count"`
+}
+
+func TestContentNegotiation(t *testing.T) {
+	logging.CreateTestLogger("TestNegotiation")
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.AddMappedHandler("/rows", http.MethodGet, func(r *http.Request, response *Response) {
+		response.SetResponse(200, []*negotiationTestRow{{Name: "a,b", Count: 1}, {Name: "c", Count: 2}}, "")
+	})
+	server.AddMappedHandler("/map", http.MethodGet, func(r *http.Request, response *Response) {
+		response.SetResponse(200, map[string]interface{}{"name": "x<y", "list": []int{1, 2}, "1st": true}, "application/yaml")
+	})
+	server.AddMappedHandler("/text", http.MethodGet, func(r *http.Request, response *Response) {
+		response.SetResponse(200, "Plain", "text/plain")
+	})
+	server.AddResponseEncoder("text/plain", func(object interface{}) ([]byte, error) {
+		return []byte("custom"), nil
+	})
+
+	rec := negotiationTest(server, "/rows", "")
+	test.AssertStringEquals(t, "Default JSON", rec.Body.String(), `[{"name":"a,b","count":1},{"name":"c","count":2}]`)
+	test.AssertStringContains(t, "", rec.Header().Get(ContentTypeName), "application/json")
+	test.AssertStringEquals(t, "", rec.Header().Get("Vary"), "Accept")
+
+	rec = negotiationTest(server, "/rows", "text/csv")
+	test.AssertStringEquals(t, "CSV", rec.Body.String(), "name,count\n\"a,b\",1\nc,2\n")
+	test.AssertStringContains(t, "", rec.Header().Get(ContentTypeName), "text/csv")
+
+	rec = negotiationTest(server, "/rows", "application/xml;q=0.5, application/yaml")
+	test.AssertStringContains(t, "Quality", rec.Body.String(), "- name: a,b\n  count: 1")
+
+	rec = negotiationTest(server, "/rows", "text/xml")
+	test.AssertStringContains(t, "XML slice", rec.Body.String(), "<response><item><count>1</count><name>a,b</name></item>")
+
+	rec = negotiationTest(server, "/map", "*/*")
+	test.AssertStringContains(t, "Preferred type", rec.Body.String(), "name: x<y")
+	test.AssertStringContains(t, "", rec.Header().Get(ContentTypeName), "application/yaml")
+
+	rec = negotiationTest(server, "/map", "application/*")
+	test.AssertStringContains(t, "Preferred in range", rec.Header().Get(ContentTypeName), "application/yaml")
+
+	rec = negotiationTest(server, "/map", "application/xml")
+	test.AssertStringContains(t, "XML map", rec.Body.String(), "<response><_st>true</_st><list><item>1</item><item>2</item></list><name>x&lt;y</name></response>")
+
+	rec = negotiationTest(server, "/map", "text/csv, application/json;q=0.1")
+	test.AssertStringContains(t, "CSV not possible", rec.Header().Get(ContentTypeName), "application/json")
+
+	rec = negotiationTest(server, "/map", "text/csv, text/html")
+	test.AssertIntEqual(t, "Not acceptable", rec.Code, 406)
+	test.AssertStringContains(t, "", rec.Body.String(), "\"Code\":"+strconv.Itoa(panicapi.SCNotAcceptable))
+
+	rec = negotiationTest(server, "/map", "text/plain")
+	test.AssertStringEquals(t, "Custom encoder", rec.Body.String(), "custom")
+
+	rec = negotiationTest(server, "/text", "application/json")
+	test.AssertStringEquals(t, "Strings not encoded", rec.Body.String(), "Plain")
+}
+
+func TestParseAccept(t *testing.T) {
+	var list []string
+	for _, r := range parseAccept("text/html;q=0.8, application/json, */*;q=0.1, text/csv;q=0, bad/") {
+		list = append(list, r.mediaType)
+	}
+	test.AssertStringEquals(t, "", strings.Join(list, ","), "application/json,text/html,*/*")
+}
+
+func negotiationTest(server *ServerInstanceData, url string, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	return rec
+}
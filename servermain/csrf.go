@@ -0,0 +1,168 @@
+package servermain
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/stuartdd/webServerBase/panicapi"
+)
+
+/*
+CSRF defaults. Used if the names are not set
+*/
+const (
+	DefaultCSRFCookieName = "CSRF-TOKEN"
+	DefaultCSRFHeaderName = "X-CSRF-Token"
+	DefaultCSRFFieldName  = "csrfToken"
+)
+
+/*
+CSRFProtection - Cross Site Request Forgery protection using a 'double submit' cookie.
+
+A random token is issued in an HttpOnly cookie. The same token is available to templates as {{.csrfToken}}
+via RequestHandlerHelper.GetMapOfRequestData(). POST, PUT and DELETE requests must return the token in a header
+or a form field.
+
+Requests with a Bearer Authorization header are exempt as browsers do not add it to cross site requests.
+
+Add CSRFProtection.BeforeHandler to the server using AddBeforeHandler so it runs before ALL mapped handlers.
+*/
+type CSRFProtection struct {
+	mutex          sync.RWMutex
+	cookieName     string
+	headerName     string
+	fieldName      string
+	secure         bool
+	exemptPrefixes []string
+}
+
+/*
+NewCSRFProtection create CSRF protection with the default cookie, header and field names
+*/
+func NewCSRFProtection() *CSRFProtection {
+	return &CSRFProtection{
+		cookieName:     DefaultCSRFCookieName,
+		headerName:     DefaultCSRFHeaderName,
+		fieldName:      DefaultCSRFFieldName,
+		secure:         false,
+		exemptPrefixes: []string{},
+	}
+}
+
+/*
+SetCookieName set the name of the cookie containing the token. Default is CSRF-TOKEN
+*/
+func (p *CSRFProtection) SetCookieName(name string) {
+	p.cookieName = name
+}
+
+/*
+SetHeaderName set the name of the request header containing the token. Default is X-CSRF-Token
+*/
+func (p *CSRFProtection) SetHeaderName(name string) {
+	p.headerName = name
+}
+
+/*
+SetFieldName set the name of the form field containing the token. Default is csrfToken
+*/
+func (p *CSRFProtection) SetFieldName(name string) {
+	p.fieldName = name
+}
+
+/*
+SetSecure if true the cookie is only sent over https
+*/
+func (p *CSRFProtection) SetSecure(secure bool) {
+	p.secure = secure
+}
+
+/*
+AddExemptPrefix urls starting with prefix are not checked. For example API routes that do not use cookies
+*/
+func (p *CSRFProtection) AddExemptPrefix(prefix string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.exemptPrefixes = append(p.exemptPrefixes, prefix)
+}
+
+/*
+BeforeHandler issues a token if the request does not have one and validates the token for POST, PUT and DELETE.
+The request is vetoed with a 403 (Forbidden) if the token is missing or does not match the cookie.
+
+If the token is not in the header it is read from the form (see RequestHandlerHelper.GetFormValue).
+The form is parsed ONCE and shared with the handlers. Files in a multipart form are removed when the request has been handled.
+*/
+func (p *CSRFProtection) BeforeHandler(request *http.Request, response *Response) {
+	token := ""
+	cookie, err := request.Cookie(p.cookieName)
+	if err == nil && cookie.Value != "" {
+		token = cookie.Value
+	}
+	if requiresCSRFCheck(request.Method) && !p.isExempt(request) {
+		if token == "" || !csrfTokensMatch(token, p.getRequestToken(request, response)) {
+			response.SetErrorResponse(http.StatusForbidden, panicapi.SCCSRFInvalid, "CSRF token is missing or invalid")
+			return
+		}
+	}
+	if token == "" {
+		token = p.issueToken(response)
+	}
+	response.csrfToken = token
+}
+
+func (p *CSRFProtection) issueToken(response *Response) string {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		panicapi.ThrowError(500, panicapi.SCCSRFInvalid, "CSRF token could not be created", "CSRFProtection: Random token failed: "+err.Error())
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	cookie := &http.Cookie{
+		Name:     p.cookieName,
+		Value:    token,
+		Path:     "/",
+		Secure:   p.secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	response.addCookie(cookie)
+	return token
+}
+
+func (p *CSRFProtection) getRequestToken(request *http.Request, response *Response) string {
+	token := request.Header.Get(p.headerName)
+	if token != "" {
+		return token
+	}
+	/*
+		The form values followed by the URL query values. Other body types are not read.
+	*/
+	return NewRequestHandlerHelper(request, response).GetFormValue(p.fieldName)
+}
+
+func (p *CSRFProtection) isExempt(request *http.Request) bool {
+	if strings.HasPrefix(strings.ToLower(request.Header.Get("Authorization")), "bearer ") {
+		return true
+	}
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	for _, prefix := range p.exemptPrefixes {
+		if hasPathPrefix(request.URL.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func requiresCSRFCheck(method string) bool {
+	return method == http.MethodPost || method == http.MethodPut || method == http.MethodDelete
+}
+
+func csrfTokensMatch(expected string, actual string) bool {
+	return actual != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) == 1
+}
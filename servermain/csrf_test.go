@@ -0,0 +1,124 @@
+package servermain
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stuartdd/webServerBase/logging"
+	"github.com/stuartdd/webServerBase/panicapi"
+	"github.com/stuartdd/webServerBase/test"
+)
+
+func TestCSRFTokenIssuedAndValidated(t *testing.T) {
+	logging.CreateTestLogger("TestCSRF")
+	csrf := NewCSRFProtection()
+	csrf.AddExemptPrefix("/api/")
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.AddBeforeHandler(csrf.BeforeHandler)
+	server.AddMappedHandler("/form", http.MethodGet, func(r *http.Request, response *Response) {
+		response.SetResponse(200, NewRequestHandlerHelper(r, response).GetMapOfRequestData()["csrfToken"], "")
+	})
+	saveHandler := func(r *http.Request, response *Response) {
+		response.SetResponse(200, "Saved "+r.PostFormValue("data"), "")
+	}
+	server.AddMappedHandler("/save", http.MethodPost, saveHandler)
+	server.AddMappedHandler("/api/save", http.MethodPost, saveHandler)
+
+	rec := csrfTest(server, http.MethodGet, "/form", nil, nil)
+	test.AssertIntEqual(t, "", rec.Code, 200)
+	token := rec.Body.String()
+	test.AssertIntEqual(t, "Token", len(token), 43)
+	test.AssertStringContains(t, "", rec.Header().Get("Set-Cookie"), DefaultCSRFCookieName+"="+token, "HttpOnly", "SameSite=Lax")
+	cookie := DefaultCSRFCookieName + "=" + token
+	/*
+		Existing token is re-used
+	*/
+	rec = csrfTest(server, http.MethodGet, "/form", map[string]string{"Cookie": cookie}, nil)
+	test.AssertStringEquals(t, "Same token", rec.Body.String(), token)
+	test.AssertStringEquals(t, "No new cookie", rec.Header().Get("Set-Cookie"), "")
+
+	rec = csrfTest(server, http.MethodPost, "/save", map[string]string{"Cookie": cookie, DefaultCSRFHeaderName: token}, nil)
+	test.AssertIntEqual(t, "Header", rec.Code, 200)
+
+	form := url.Values{DefaultCSRFFieldName: {token}, "data": {"abc"}}
+	rec = csrfTest(server, http.MethodPost, "/save", map[string]string{"Cookie": cookie}, form)
+	test.AssertIntEqual(t, "Form", rec.Code, 200)
+	test.AssertStringEquals(t, "Form still readable", rec.Body.String(), "Saved abc")
+
+	rec = csrfTest(server, http.MethodPost, "/save", map[string]string{"Cookie": cookie, DefaultCSRFHeaderName: token + "x"}, nil)
+	test.AssertIntEqual(t, "Wrong token", rec.Code, 403)
+	test.AssertStringContains(t, "", rec.Body.String(), "\"Code\":"+strconv.Itoa(panicapi.SCCSRFInvalid))
+
+	rec = csrfTest(server, http.MethodPost, "/save", map[string]string{DefaultCSRFHeaderName: token}, nil)
+	test.AssertIntEqual(t, "No cookie", rec.Code, 403)
+
+	rec = csrfTest(server, http.MethodPost, "//api/save", nil, nil)
+	test.AssertIntEqual(t, "Exempt leading slashes", rec.Code, 200)
+
+	rec = csrfTest(server, http.MethodPost, "/save", map[string]string{"Authorization": "Bearer abc"}, nil)
+	test.AssertIntEqual(t, "Bearer exempt", rec.Code, 200)
+
+	rec = csrfTest(server, http.MethodPost, "/api/save", nil, nil)
+	test.AssertIntEqual(t, "Prefix exempt", rec.Code, 200)
+}
+
+func TestCSRFMultipartForm(t *testing.T) {
+	logging.CreateTestLogger("TestCSRF")
+	csrf := NewCSRFProtection()
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.SetBodyCacheLimit(100)
+	server.AddBeforeHandler(csrf.BeforeHandler)
+	tempFile := ""
+	server.AddMappedHandler("/upload", http.MethodPost, func(r *http.Request, response *Response) {
+		files := NewRequestHandlerHelper(r, response).GetUploadedFiles("file")
+		test.AssertIntEqual(t, "Files", len(files), 1)
+		file, _ := files[0].Open()
+		defer file.Close()
+		if osFile, ok := file.(*os.File); ok {
+			tempFile = osFile.Name()
+		}
+		response.SetResponse(200, strconv.FormatInt(files[0].Size, 10), "")
+	})
+	token := "abcdefghijklmnopqrstuvwxyz"
+	/*
+		The file is larger than the body cache limit so it is held in a temporary file
+	*/
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField(DefaultCSRFFieldName, token)
+	part, _ := writer.CreateFormFile("file", "big.txt")
+	part.Write(bytes.Repeat([]byte("x"), 1000))
+	writer.Close()
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set(ContentTypeName, writer.FormDataContentType())
+	req.Header.Set("Cookie", DefaultCSRFCookieName+"="+token)
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	test.AssertIntEqual(t, "Multipart token", rec.Code, 200)
+	test.AssertStringEquals(t, "Upload still readable", rec.Body.String(), "1000")
+	test.AssertBoolTrue(t, "Temp file", tempFile != "")
+	test.AssertFileNotExists(t, "Temp file removed", tempFile)
+}
+
+func csrfTest(server *ServerInstanceData, method string, url string, headers map[string]string, form url.Values) *httptest.ResponseRecorder {
+	var req *http.Request
+	if form != nil {
+		req = httptest.NewRequest(method, url, strings.NewReader(form.Encode()))
+		req.Header.Set(ContentTypeName, "application/x-www-form-urlencoded")
+	} else {
+		req = httptest.NewRequest(method, url, nil)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	return rec
+}
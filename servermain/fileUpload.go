@@ -0,0 +1,281 @@
+package servermain
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/stuartdd/webServerBase/panicapi"
+)
+
+/*
+DefaultMaxUploadFileSize - The maximum size of an uploaded file if SetMaxFileSize is not called
+*/
+const DefaultMaxUploadFileSize = 10 * 1024 * 1024
+
+/*
+DefaultMaxUploadFiles - The maximum number of files in a request if SetMaxFiles is not called
+*/
+const DefaultMaxUploadFiles = 10
+
+/*
+uploadFormOverhead is allowed for the form fields and the multipart headers in addition to the files
+*/
+const uploadFormOverhead = 64 * 1024
+
+/*
+sniffLength is the number of bytes used by http.DetectContentType
+*/
+const sniffLength = 512
+
+/*
+signatureContentTypes are the content types, for a file name extension, that MIME sniffing can detect reliably.
+Files with these extensions must have matching content.
+*/
+var signatureContentTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/bmp":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+/*
+FileUploadHandler - Save the files in a multipart/form-data request to a static path.
+
+The static path is resolved using GetStaticPathForName. ALL files in the request are checked
+(size, extension and content type) before any are saved so a rejected request does not leave partial uploads.
+
+The content type of each file is detected from it's content (MIME sniffing) NOT the type sent by the client.
+Files with extensions like png, jpg and pdf are rejected if their content does not match the extension.
+
+The request body is limited to GetMaxBodySize bytes so a large request is rejected before it is written to disk.
+
+Add FileUploadHandler.Handler to the server using AddMappedHandler with http.MethodPost.
+Use SetRouteMaxBodySize(route, GetMaxBodySize()) so the limit also applies when a before handler (E.G. CSRFProtection) reads the form.
+*/
+type FileUploadHandler struct {
+	staticName          string
+	maxFileSize         int64
+	maxFiles            int
+	allowedExtensions   map[string]bool
+	allowedContentTypes map[string]bool
+}
+
+/*
+UploadResponseData - The JSON response from FileUploadHandler.Handler
+*/
+type UploadResponseData struct {
+	Files []*UploadedFileData
+}
+
+/*
+UploadedFileData - The details of a saved file.
+StoredName is the name of the file in the static path. It is different from FileName if the file already existed.
+*/
+type UploadedFileData struct {
+	Field       string
+	FileName    string
+	StoredName  string
+	ContentType string
+	Size        int64
+}
+
+/*
+NewFileUploadHandler create an upload handler that saves files to the static path for staticName.
+By default NO extensions are allowed so ALL files are rejected. SetAllowedExtensions must be called to allow uploads.
+Any detected content type is allowed unless SetAllowedContentTypes is called.
+*/
+func NewFileUploadHandler(staticName string) *FileUploadHandler {
+	if staticName == "" {
+		panic("NewFileUploadHandler: A static path name is required")
+	}
+	return &FileUploadHandler{
+		staticName:          staticName,
+		maxFileSize:         DefaultMaxUploadFileSize,
+		maxFiles:            DefaultMaxUploadFiles,
+		allowedExtensions:   make(map[string]bool),
+		allowedContentTypes: make(map[string]bool),
+	}
+}
+
+/*
+SetMaxFileSize set the maximum size of each uploaded file. Default is DefaultMaxUploadFileSize
+*/
+func (p *FileUploadHandler) SetMaxFileSize(maxBytes int64) {
+	if maxBytes <= 0 {
+		panic("FileUploadHandler.SetMaxFileSize: The maximum file size must be greater than 0")
+	}
+	p.maxFileSize = maxBytes
+}
+
+/*
+SetMaxFiles set the maximum number of files in a request. Default is DefaultMaxUploadFiles
+*/
+func (p *FileUploadHandler) SetMaxFiles(maxFiles int) {
+	if maxFiles <= 0 {
+		panic("FileUploadHandler.SetMaxFiles: The maximum number of files must be greater than 0")
+	}
+	p.maxFiles = maxFiles
+}
+
+/*
+GetMaxBodySize returns the largest request body accepted. The maximum number of files of the maximum size plus the form fields.
+*/
+func (p *FileUploadHandler) GetMaxBodySize() int64 {
+	return int64(p.maxFiles)*p.maxFileSize + uploadFormOverhead
+}
+
+/*
+SetAllowedExtensions set the file name extensions (without the '.') that can be uploaded. Case is ignored.
+Files with any other extension are rejected. An empty list rejects ALL files.
+*/
+func (p *FileUploadHandler) SetAllowedExtensions(extensions ...string) {
+	p.allowedExtensions = make(map[string]bool)
+	for _, ext := range extensions {
+		p.allowedExtensions[strings.ToLower(strings.TrimPrefix(ext, "."))] = true
+	}
+}
+
+/*
+SetAllowedContentTypes set the detected content types (E.G. image/png) that can be uploaded.
+*/
+func (p *FileUploadHandler) SetAllowedContentTypes(contentTypes ...string) {
+	p.allowedContentTypes = make(map[string]bool)
+	for _, contentType := range contentTypes {
+		p.allowedContentTypes[strings.ToLower(contentType)] = true
+	}
+}
+
+/*
+Handler saves ALL of the uploaded files and returns the stored names and sizes as JSON (UploadResponseData)
+*/
+func (p *FileUploadHandler) Handler(request *http.Request, response *Response) {
+	h := NewRequestHandlerHelper(request, response)
+	path := h.GetStaticPathForName(p.staticName).FilePath
+	/*
+		Limit the body BEFORE it is parsed. Unless a before handler has already parsed it.
+	*/
+	maxBodySize := p.GetMaxBodySize()
+	if request.ContentLength > maxBodySize {
+		panicapi.ThrowError(413, panicapi.SCUploadRejected, "Upload is too large", fmt.Sprintf("FileUploadHandler: Content-Length %d exceeds %d", request.ContentLength, maxBodySize))
+	}
+	if !h.bodyRead && !h.bodyCached {
+		request.Body = newMaxBodyReader(request.Body, maxBodySize)
+	}
+	h.readForm()
+	if request.MultipartForm == nil || len(request.MultipartForm.File) == 0 {
+		panicapi.ThrowError(400, panicapi.SCInvalidFormRequest, "No files uploaded", "FileUploadHandler: The request does not contain multipart/form-data files")
+	}
+	count := 0
+	for _, list := range request.MultipartForm.File {
+		count = count + len(list)
+	}
+	if count > p.maxFiles {
+		panicapi.ThrowError(413, panicapi.SCUploadRejected, "Too many files", fmt.Sprintf("FileUploadHandler: %d files exceeds %d", count, p.maxFiles))
+	}
+	files := []*UploadedFileData{}
+	headers := []*multipart.FileHeader{}
+	for field, list := range request.MultipartForm.File {
+		for _, header := range list {
+			files = append(files, p.checkFile(field, header))
+			headers = append(headers, header)
+		}
+	}
+	for i, file := range files {
+		file.StoredName = p.saveFile(path, headers[i])
+		if h.GetServer().GetServerLogger().IsDebug() {
+			h.GetServer().GetServerLogger().LogDebugf("ID: %s. Uploaded file %s saved as %s. Size %d", h.GetTransactionID(), file.FileName, filepath.Join(path, file.StoredName), file.Size)
+		}
+	}
+	response.SetResponse(200, &UploadResponseData{Files: files}, "application/json")
+}
+
+/*
+checkFile returns the file data if the file can be saved. Otherwise a 4xx error is thrown
+*/
+func (p *FileUploadHandler) checkFile(field string, header *multipart.FileHeader) *UploadedFileData {
+	fileName := cleanUploadFileName(header.Filename)
+	if header.Size > p.maxFileSize {
+		panicapi.ThrowError(413, panicapi.SCUploadRejected, fmt.Sprintf("File %s is too large", fileName), fmt.Sprintf("FileUploadHandler: File %s size %d exceeds %d", fileName, header.Size, p.maxFileSize))
+	}
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(fileName), "."))
+	if !p.allowedExtensions[ext] {
+		panicapi.ThrowError(415, panicapi.SCUploadRejected, fmt.Sprintf("File %s type is not allowed", fileName), fmt.Sprintf("FileUploadHandler: File %s extension [%s] is not allowed", fileName, ext))
+	}
+	file, err := header.Open()
+	if err != nil {
+		panicapi.ThrowError(400, panicapi.SCInvalidFormRequest, fmt.Sprintf("File %s could not be read", fileName), err.Error())
+	}
+	defer file.Close()
+	buffer := make([]byte, sniffLength)
+	n, err := io.ReadFull(file, buffer)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		panicapi.ThrowError(400, panicapi.SCInvalidFormRequest, fmt.Sprintf("File %s could not be read", fileName), err.Error())
+	}
+	contentType := strings.Split(http.DetectContentType(buffer[:n]), ";")[0]
+	expected := LookupContentType(fileName)
+	if signatureContentTypes[expected] && expected != contentType {
+		panicapi.ThrowError(415, panicapi.SCUploadRejected, fmt.Sprintf("File %s content does not match it's type", fileName), fmt.Sprintf("FileUploadHandler: File %s content type [%s] does not match extension [%s]", fileName, contentType, ext))
+	}
+	if len(p.allowedContentTypes) > 0 && !p.allowedContentTypes[contentType] {
+		panicapi.ThrowError(415, panicapi.SCUploadRejected, fmt.Sprintf("File %s type is not allowed", fileName), fmt.Sprintf("FileUploadHandler: File %s content type [%s] is not allowed", fileName, contentType))
+	}
+	return &UploadedFileData{
+		Field:       field,
+		FileName:    fileName,
+		ContentType: contentType,
+		Size:        header.Size,
+	}
+}
+
+/*
+saveFile saves the file in the path and returns the stored name. Existing files are NOT replaced, a number is added to the name.
+*/
+func (p *FileUploadHandler) saveFile(path string, header *multipart.FileHeader) string {
+	fileName := cleanUploadFileName(header.Filename)
+	in, err := header.Open()
+	if err != nil {
+		panicapi.ThrowError(400, panicapi.SCInvalidFormRequest, fmt.Sprintf("File %s could not be read", fileName), err.Error())
+	}
+	defer in.Close()
+	ext := filepath.Ext(fileName)
+	base := strings.TrimSuffix(fileName, ext)
+	storedName := fileName
+	for i := 1; ; i++ {
+		out, err := os.OpenFile(filepath.Join(path, storedName), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = io.Copy(out, in)
+			closeErr := out.Close()
+			if err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(filepath.Join(path, storedName))
+				panicapi.ThrowError(500, panicapi.SCWriteFile, fmt.Sprintf("File %s could not be saved", fileName), err.Error())
+			}
+			return storedName
+		}
+		if !os.IsExist(err) || i > 999 {
+			panicapi.ThrowError(500, panicapi.SCWriteFile, fmt.Sprintf("File %s could not be saved", fileName), err.Error())
+		}
+		storedName = base + "-" + strconv.Itoa(i) + ext
+	}
+}
+
+/*
+cleanUploadFileName removes any path from the name sent by the client
+*/
+func cleanUploadFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == "/" || name == ".." || strings.HasPrefix(name, ".") {
+		panicapi.ThrowError(400, panicapi.SCUploadRejected, "Invalid file name", fmt.Sprintf("FileUploadHandler: File name [%s] is not valid", name))
+	}
+	return name
+}
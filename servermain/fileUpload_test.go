@@ -0,0 +1,139 @@
+package servermain
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stuartdd/webServerBase/logging"
+	"github.com/stuartdd/webServerBase/panicapi"
+	"github.com/stuartdd/webServerBase/test"
+)
+
+var pngHeader = []byte("\x89PNG\x0D\x0A\x1A\x0A\x00\x00\x00\x0DIHDR")
+
+func TestFileUploadHandler(t *testing.T) {
+	logging.CreateTestLogger("TestUpload")
+	dir, err := ioutil.TempDir("", "upload")
+	test.AssertErrorIsNil(t, "", err)
+	defer os.RemoveAll(dir)
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.SetStaticFileServerData(map[string]string{"data": dir})
+	upload := NewFileUploadHandler("data")
+	upload.SetMaxFileSize(100)
+	upload.SetAllowedExtensions("png", ".TXT")
+	upload.SetAllowedContentTypes("image/png", "text/plain")
+	server.AddMappedHandler("/upload", http.MethodPost, upload.Handler)
+
+	rec := uploadTest(server, "/upload", map[string]string{"comment": "Hello"}, map[string][]byte{"../image.png": pngHeader, "notes.txt": []byte("Some notes")})
+	test.AssertIntEqual(t, "", rec.Code, 200)
+	test.AssertStringContains(t, "", rec.Body.String(), "\"FileName\":\"image.png\"", "\"StoredName\":\"image.png\"", "\"ContentType\":\"image/png\"", "\"Size\":16", "\"StoredName\":\"notes.txt\"", "\"Size\":10")
+	saved, err := ioutil.ReadFile(filepath.Join(dir, "notes.txt"))
+	test.AssertErrorIsNil(t, "", err)
+	test.AssertStringEquals(t, "", string(saved), "Some notes")
+	/*
+		Existing files are not replaced
+	*/
+	rec = uploadTest(server, "/upload", nil, map[string][]byte{"notes.txt": []byte("More notes")})
+	test.AssertIntEqual(t, "", rec.Code, 200)
+	test.AssertStringContains(t, "", rec.Body.String(), "\"StoredName\":\"notes-1.txt\"")
+
+	rec = uploadTest(server, "/upload", nil, map[string][]byte{"image.gif": pngHeader})
+	test.AssertIntEqual(t, "Extension", rec.Code, 415)
+	test.AssertStringContains(t, "", rec.Body.String(), "\"Code\":"+strconv.Itoa(panicapi.SCUploadRejected))
+
+	rec = uploadTest(server, "/upload", nil, map[string][]byte{"image.png": []byte("Not a png"), "ok.txt": []byte("ok")})
+	test.AssertIntEqual(t, "Sniffed type", rec.Code, 415)
+	_, err = os.Stat(filepath.Join(dir, "ok.txt"))
+	test.AssertBoolTrue(t, "Nothing saved", os.IsNotExist(err))
+
+	rec = uploadTest(server, "/upload", nil, map[string][]byte{"big.txt": bytes.Repeat([]byte("X"), 101)})
+	test.AssertIntEqual(t, "Too large", rec.Code, 413)
+
+	rec = uploadTest(server, "/upload", map[string]string{"comment": "Hello"}, nil)
+	test.AssertIntEqual(t, "No files", rec.Code, 400)
+	/*
+		No allowed extensions rejects everything
+	*/
+	server.AddMappedHandler("/upload/default", http.MethodPost, NewFileUploadHandler("data").Handler)
+	rec = uploadTest(server, "/upload/default", nil, map[string][]byte{"page.html": []byte("<script>alert(1)</script>")})
+	test.AssertIntEqual(t, "Default", rec.Code, 415)
+	_, err = os.Stat(filepath.Join(dir, "page.html"))
+	test.AssertBoolTrue(t, "Nothing saved", os.IsNotExist(err))
+}
+
+func TestFileUploadLimits(t *testing.T) {
+	logging.CreateTestLogger("TestUpload")
+	dir, err := ioutil.TempDir("", "upload")
+	test.AssertErrorIsNil(t, "", err)
+	defer os.RemoveAll(dir)
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.SetStaticFileServerData(map[string]string{"data": dir})
+	upload := NewFileUploadHandler("data")
+	upload.SetMaxFileSize(100)
+	upload.SetMaxFiles(2)
+	test.AssertInt64Equal(t, "", upload.GetMaxBodySize(), 200+uploadFormOverhead)
+	server.AddMappedHandler("/upload", http.MethodPost, upload.Handler)
+
+	rec := uploadTest(server, "/upload", nil, map[string][]byte{"a.txt": []byte("a"), "b.txt": []byte("b"), "c.txt": []byte("c")})
+	test.AssertIntEqual(t, "Too many files", rec.Code, 413)
+	test.AssertStringContains(t, "", rec.Body.String(), "\"Code\":"+strconv.Itoa(panicapi.SCUploadRejected))
+	/*
+		Rejected by Content-Length before the body is read
+	*/
+	large := map[string][]byte{"big.txt": bytes.Repeat([]byte("X"), uploadFormOverhead+1000)}
+	rec = uploadTest(server, "/upload", nil, large)
+	test.AssertIntEqual(t, "Content-Length", rec.Code, 413)
+	test.AssertStringContains(t, "", rec.Body.String(), "\"Code\":"+strconv.Itoa(panicapi.SCUploadRejected))
+	/*
+		No Content-Length. Rejected when the limit is read
+	*/
+	rec = uploadTestWithRequest(server, "/upload", nil, large, func(req *http.Request) {
+		req.ContentLength = -1
+	})
+	test.AssertIntEqual(t, "Chunked", rec.Code, 413)
+	test.AssertStringContains(t, "", rec.Body.String(), "\"Code\":"+strconv.Itoa(panicapi.SCBodyTooLarge))
+	files, _ := ioutil.ReadDir(dir)
+	test.AssertIntEqual(t, "Nothing saved", len(files), 0)
+}
+
+func TestFormValues(t *testing.T) {
+	logging.CreateTestLogger("TestForm")
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.AddMappedHandler("/form", http.MethodPost, func(r *http.Request, response *Response) {
+		h := NewRequestHandlerHelper(r, response)
+		files := h.GetUploadedFiles("file")
+		response.SetResponse(200, h.GetFormValue("a")+":"+strconv.Itoa(len(h.GetFormValues("a")))+":"+h.GetFormValue("q")+":"+strconv.Itoa(len(files)), "")
+	})
+	rec := uploadTest(server, "/form?a=B&q=Q", map[string]string{"a": "A"}, map[string][]byte{"x.txt": []byte("X")})
+	test.AssertStringEquals(t, "Multipart", rec.Body.String(), "A:2:Q:1")
+}
+
+func uploadTest(server *ServerInstanceData, url string, fields map[string]string, files map[string][]byte) *httptest.ResponseRecorder {
+	return uploadTestWithRequest(server, url, fields, files, func(req *http.Request) {})
+}
+
+func uploadTestWithRequest(server *ServerInstanceData, url string, fields map[string]string, files map[string][]byte, update func(*http.Request)) *httptest.ResponseRecorder {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for name, value := range fields {
+		writer.WriteField(name, value)
+	}
+	for name, content := range files {
+		part, _ := writer.CreateFormFile("file", name)
+		part.Write(content)
+	}
+	writer.Close()
+	req := httptest.NewRequest(http.MethodPost, url, body)
+	req.Header.Set(ContentTypeName, writer.FormDataContentType())
+	update(req)
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	return rec
+}
@@ -15,6 +15,8 @@ type MappingElements struct {
 	HandlerFunc   func(*http.Request, *Response)
 	RequestMethod string
 	names         map[string]int
+	urlPattern    string
+	roles         []string
 	parent        *MappingElements
 }
 
@@ -27,6 +29,8 @@ func NewMappingElements(parent *MappingElements) *MappingElements {
 		HandlerFunc:   nil,
 		RequestMethod: "",
 		names:         make(map[string]int),
+		urlPattern:    "",
+		roles:         nil,
 		parent:        parent,
 	}
 }
@@ -74,16 +78,18 @@ func validateNames(urlParts []string, names []string) map[string]int {
 }
 
 /*
-AddPathMappingElement Add a path to the mapping
+AddPathMappingElement Add a path to the mapping.
+If roles are defined the principal must have one of them to invoke the handler
 */
-func (p *MappingElements) AddPathMappingElement(url string, method string, handlerFunc func(*http.Request, *Response)) {
-	p.AddPathMappingElementWithNames(url, method, handlerFunc, nil)
+func (p *MappingElements) AddPathMappingElement(url string, method string, handlerFunc func(*http.Request, *Response), roles ...string) {
+	p.AddPathMappingElementWithNames(url, method, handlerFunc, nil, roles...)
 }
 
 /*
-AddPathMappingElementWithNames Add a path to the mapping
+AddPathMappingElementWithNames Add a path to the mapping.
+If roles are defined the principal must have one of them to invoke the handler
 */
-func (p *MappingElements) AddPathMappingElementWithNames(url string, method string, handlerFunc func(*http.Request, *Response), names []string) {
+func (p *MappingElements) AddPathMappingElementWithNames(url string, method string, handlerFunc func(*http.Request, *Response), names []string, roles ...string) {
 	var me *MappingElements
 	var found bool
 	parts := strings.Split(strings.Trim(url, "/"), "/")
@@ -110,6 +116,22 @@ func (p *MappingElements) AddPathMappingElementWithNames(url string, method stri
 	currentElement.HandlerFunc = handlerFunc
 	currentElement.names = validateNames(parts, names)
 	currentElement.RequestMethod = strings.ToUpper(method)
+	currentElement.urlPattern = "/" + strings.Trim(url, "/")
+	currentElement.roles = roles
+}
+
+/*
+GetRequiredRoles returns the roles required to invoke the handler. Empty if no roles are required
+*/
+func (p *MappingElements) GetRequiredRoles() []string {
+	return p.roles
+}
+
+/*
+GetURLPattern returns the url pattern used to add the mapping. For example /calc/?/div/?
+*/
+func (p *MappingElements) GetURLPattern() string {
+	return p.urlPattern
 }
 
 /*
@@ -141,14 +163,14 @@ func (p *MappingElements) getPathMappingElement(parts []string, method string, p
 		*/
 		wc := me.elements["*"]
 		if wc == nil {
-			if me.RequestMethod != method || me.RequestMethod == "" {
+			if !me.methodMatches(method) {
 				return nil, false
 			}
 		} else {
 			/*
 				Even a wildcard needs to match the method
 			*/
-			if wc.RequestMethod != method || wc.RequestMethod == "" {
+			if !wc.methodMatches(method) {
 				return nil, false
 			}
 			return wc, true
@@ -176,6 +198,49 @@ func (p *MappingElements) getPathMappingElement(parts []string, method string, p
 	return nil, false
 }
 
+/*
+routePath returns the url path as the mapping lookup sees it (see GetPathMappingElement). Empty parts are removed so
+//script/list and /script//list/ are both /script/list. Use this (or hasPathPrefix) to match route prefixes.
+*/
+func routePath(urlPath string) string {
+	parts := []string{}
+	for _, part := range strings.Split(urlPath, "/") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+/*
+hasPathPrefix returns true if the normalised url path (see routePath) is in the route family of prefix.
+The path must equal the prefix or continue with a '/' so /api matches /api and /api/x but not /apix.
+/script matches the prefix /script/ as it is the same route family.
+*/
+func hasPathPrefix(urlPath string, prefix string) bool {
+	normalisedPrefix := routePath(prefix)
+	if normalisedPrefix == "/" {
+		return true
+	}
+	normalised := routePath(urlPath)
+	return normalised == normalisedPrefix || strings.HasPrefix(normalised, normalisedPrefix+"/")
+}
+
+/*
+methodMatches returns true if the mapping has a handler for the method. RequestMethod can be a comma separated list. For example "GET,PUT"
+*/
+func (p *MappingElements) methodMatches(method string) bool {
+	if p.RequestMethod == "" || method == "" {
+		return false
+	}
+	for _, m := range strings.Split(p.RequestMethod, ",") {
+		if strings.TrimSpace(m) == method {
+			return true
+		}
+	}
+	return false
+}
+
 func getMappingElementTreeString(ce *MappingElements, ind int, b *bytes.Buffer) {
 	for key, val := range ce.elements {
 		b.WriteString(fmt.Sprintf("%s[%s] key:%s method:%s size:%d\n", strings.Repeat(".", ind), ce.RequestMethod, key, val.RequestMethod, len(val.elements)))
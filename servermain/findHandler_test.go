@@ -134,6 +134,31 @@ func TestCreateSimple(t *testing.T) {
 
 }
 
+func TestCreateMultipleMethods(t *testing.T) {
+	m := NewMappingElements(nil)
+	m.AddPathMappingElement("/admin/log/levels", "get, Put", statusHandler)
+	for _, method := range []string{http.MethodGet, http.MethodPut, "put"} {
+		me, found := m.GetPathMappingElement("/admin/log/levels", method)
+		test.AssertBoolTrue(t, "Found "+method, found && me.HandlerFunc != nil)
+	}
+	assertNotFound(t, m, "/admin/log/levels", http.MethodPost)
+	assertNotFound(t, m, "/admin/log/levels", "")
+}
+
+func TestRoutePath(t *testing.T) {
+	test.AssertStringEquals(t, "", routePath("//script//list/"), "/script/list")
+	test.AssertStringEquals(t, "", routePath(""), "/")
+	test.AssertBoolTrue(t, "", hasPathPrefix("//script/list", "/script/"))
+	test.AssertBoolTrue(t, "", hasPathPrefix("/script", "/script/"))
+	test.AssertBoolFalse(t, "", hasPathPrefix("/scripts", "/script/"))
+	test.AssertBoolTrue(t, "", hasPathPrefix("/", "/"))
+	test.AssertBoolTrue(t, "", hasPathPrefix("/api/x", "/api"))
+	test.AssertBoolTrue(t, "", hasPathPrefix("/api", "/api"))
+	test.AssertBoolFalse(t, "", hasPathPrefix("/apix", "/api"))
+	test.AssertBoolFalse(t, "", hasPathPrefix("/apiadmin/x", "/api"))
+	test.AssertBoolFalse(t, "", hasPathPrefix("/api-internal", "/api/"))
+}
+
 func TestFindRoot(t *testing.T) {
 	meRoot := NewMappingElements(nil)
 	meRoot.RequestMethod = "ROOT"
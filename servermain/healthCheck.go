@@ -0,0 +1,256 @@
+package servermain
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Health check status values
+*/
+const (
+	HealthStatusUp   = "UP"
+	HealthStatusDown = "DOWN"
+)
+
+/*
+Names of the built in health checks. These are added when the static paths, templates or OS scripts are configured.
+*/
+const (
+	HealthCheckStaticPaths = "staticPaths"
+	HealthCheckTemplates   = "templates"
+	HealthCheckOsScripts   = "osScripts"
+)
+
+/*
+DefaultHealthCheckTimeout - The time allowed for each health check if SetHealthCheckTimeout is not called
+*/
+const DefaultHealthCheckTimeout = 5 * time.Second
+
+type healthCheckData struct {
+	name  string
+	check func() error
+}
+
+/*
+HealthCheckResult the result of a single health check
+*/
+type HealthCheckResult struct {
+	Status string
+	Error  string `json:",omitempty"`
+}
+
+/*
+HealthData the result of ALL health checks. Returned as JSON by the health handlers
+*/
+type HealthData struct {
+	Status string
+	State  string
+	Checks map[string]*HealthCheckResult
+}
+
+/*
+IsUp returns true if the health status is UP
+*/
+func (p *HealthData) IsUp() bool {
+	return p.Status == HealthStatusUp
+}
+
+/*
+HealthLiveHandler returns 200 if the server process is running and can handle requests.
+The health checks are NOT run. A failed dependency should not cause the process to be restarted, see HealthReadyHandler.
+*/
+func HealthLiveHandler(request *http.Request, response *Response) {
+	writeHealthResponse(request, response, response.GetWrappedServer().GetLiveness())
+}
+
+/*
+HealthReadyHandler returns 200 if ALL health checks pass and the server is NOT stopping, otherwise 503.
+The JSON response contains the result of each check.
+*/
+func HealthReadyHandler(request *http.Request, response *Response) {
+	writeHealthResponse(request, response, response.GetWrappedServer().GetHealth(true))
+}
+
+/*
+AddHealthCheck adds a named health check. The check should return an error if the check fails.
+Adding a check with an existing name replaces the existing check.
+A check that does not return within the health check timeout (see SetHealthCheckTimeout) is a failed check.
+*/
+func (p *ServerInstanceData) AddHealthCheck(name string, check func() error) {
+	if name == "" {
+		panic("AddHealthCheck: A health check requires a name")
+	}
+	if check == nil {
+		panic("AddHealthCheck: Health check [" + name + "] function cannot be nil")
+	}
+	p.stateMutex.Lock()
+	defer p.stateMutex.Unlock()
+	/*
+		Replace the entry (do not update it) as GetHealth runs a copy of the checks without the lock
+	*/
+	for i, hc := range p.healthChecks {
+		if hc.name == name {
+			p.healthChecks[i] = &healthCheckData{
+				name:  name,
+				check: check,
+			}
+			return
+		}
+	}
+	p.healthChecks = append(p.healthChecks, &healthCheckData{
+		name:  name,
+		check: check,
+	})
+}
+
+/*
+SetHealthCheckTimeout set the time allowed for each health check. Default is DefaultHealthCheckTimeout.
+The checks run at the same time so GetHealth returns within the timeout even if a check hangs.
+*/
+func (p *ServerInstanceData) SetHealthCheckTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		panic("SetHealthCheckTimeout: The timeout must be greater than 0")
+	}
+	p.stateMutex.Lock()
+	defer p.stateMutex.Unlock()
+	p.healthCheckTimeout = timeout
+}
+
+/*
+GetLiveness returns UP and the server state. The health checks are NOT run.
+*/
+func (p *ServerInstanceData) GetLiveness() *HealthData {
+	p.stateMutex.Lock()
+	state := p.serverState.State
+	p.stateMutex.Unlock()
+	return &HealthData{
+		Status: HealthStatusUp,
+		State:  state,
+		Checks: make(map[string]*HealthCheckResult),
+	}
+}
+
+/*
+GetHealth runs ALL health checks, at the same time, and returns the result.
+If forReadiness is true then the status is DOWN when the server is NOT RUNNING (for example STOPPING).
+*/
+func (p *ServerInstanceData) GetHealth(forReadiness bool) *HealthData {
+	p.stateMutex.Lock()
+	state := p.serverState.State
+	timeout := p.healthCheckTimeout
+	checks := make([]*healthCheckData, len(p.healthChecks))
+	copy(checks, p.healthChecks)
+	p.stateMutex.Unlock()
+
+	health := &HealthData{
+		Status: HealthStatusUp,
+		State:  state,
+		Checks: make(map[string]*HealthCheckResult),
+	}
+	errs := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, hc := range checks {
+		wg.Add(1)
+		go func(i int, hc *healthCheckData) {
+			defer wg.Done()
+			errs[i] = runHealthCheckWithTimeout(hc, timeout)
+		}(i, hc)
+	}
+	wg.Wait()
+	for i, hc := range checks {
+		err := errs[i]
+		if err == nil {
+			health.Checks[hc.name] = &HealthCheckResult{Status: HealthStatusUp}
+		} else {
+			health.Checks[hc.name] = &HealthCheckResult{Status: HealthStatusDown, Error: err.Error()}
+			health.Status = HealthStatusDown
+		}
+	}
+	if forReadiness && state != "RUNNING" {
+		health.Status = HealthStatusDown
+	}
+	return health
+}
+
+/*
+runHealthCheckWithTimeout - A check that does not return within the timeout is a failed check.
+The check cannot be stopped so it is left to complete in it's own go routine.
+*/
+func runHealthCheckWithTimeout(hc *healthCheckData, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- runHealthCheck(hc)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("Health check timed out after %s", timeout)
+	}
+}
+
+/*
+runHealthCheck - A check that panics is a failed check
+*/
+func runHealthCheck(hc *healthCheckData) (err error) {
+	defer func() {
+		rec := recover()
+		if rec != nil {
+			err = fmt.Errorf("Health check panic: %v", rec)
+		}
+	}()
+	return hc.check()
+}
+
+func writeHealthResponse(request *http.Request, response *Response, health *HealthData) {
+	server := response.GetWrappedServer()
+	code := http.StatusOK
+	if !health.IsUp() {
+		code = http.StatusServiceUnavailable
+	}
+	response.SetResponse(code, health, LookupContentType("json"))
+	/*
+		Write the response here so a 503 returns the check details and not the standard error response
+	*/
+	server.PreProcessResponse(request, response)
+	fmt.Fprint(response.GetWrappedWriter(), response.GetResp())
+	server.LogResponse(response)
+	response.Close()
+}
+
+/*
+checkPathExists - return an error if the path does not exist or is not a directory
+*/
+func checkPathExists(desc string, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("%s path [%s] is not accessible: %s", desc, path, err.Error())
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s path [%s] is not a directory", desc, path)
+	}
+	return nil
+}
+
+func (p *StaticFileServerData) checkStaticPaths() error {
+	var failed []string
+	container := p.FileServerContainerRoot
+	for container != nil && container.next != nil {
+		err := checkPathExists("Static", container.FilePath)
+		if err != nil {
+			failed = append(failed, err.Error())
+		}
+		container = container.next
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s", strings.Join(failed, ". "))
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package servermain
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stuartdd/webServerBase/logging"
+	"github.com/stuartdd/webServerBase/test"
+)
+
+func TestHealthChecksUpAndDown(t *testing.T) {
+	server := NewServerInstanceData("ServerName", "utf-8")
+	health := server.GetHealth(true)
+	test.AssertBoolTrue(t, "No checks", health.IsUp())
+
+	server.AddHealthCheck("ok", func() error { return nil })
+	health = server.GetHealth(true)
+	test.AssertBoolTrue(t, "OK check", health.IsUp())
+	test.AssertStringEquals(t, "", health.Checks["ok"].Status, HealthStatusUp)
+
+	server.AddHealthCheck("bad", func() error { return errors.New("Bad Thing") })
+	server.AddHealthCheck("panic", func() error { panic("Worse Thing") })
+	health = server.GetHealth(false)
+	test.AssertBoolFalse(t, "Bad check", health.IsUp())
+	test.AssertStringEquals(t, "", health.Checks["ok"].Status, HealthStatusUp)
+	test.AssertStringEquals(t, "", health.Checks["bad"].Error, "Bad Thing")
+	test.AssertStringContains(t, "", health.Checks["panic"].Error, "Worse Thing")
+
+	server.AddHealthCheck("bad", func() error { return nil })
+	server.AddHealthCheck("panic", func() error { return nil })
+	test.AssertBoolTrue(t, "Replaced checks", server.GetHealth(false).IsUp())
+	test.AssertIntEqual(t, "", len(server.GetHealth(false).Checks), 3)
+}
+
+func TestHealthCheckTimeout(t *testing.T) {
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.SetHealthCheckTimeout(50 * time.Millisecond)
+	hang := make(chan bool)
+	defer close(hang)
+	server.AddHealthCheck("ok", func() error { return nil })
+	server.AddHealthCheck("hung1", func() error { <-hang; return nil })
+	server.AddHealthCheck("hung2", func() error { <-hang; return nil })
+	start := time.Now()
+	health := server.GetHealth(false)
+	test.AssertBoolTrue(t, "Checks run at the same time", time.Since(start) < time.Second)
+	test.AssertBoolFalse(t, "Hung check", health.IsUp())
+	test.AssertStringEquals(t, "", health.Checks["ok"].Status, HealthStatusUp)
+	test.AssertStringContains(t, "", health.Checks["hung1"].Error, "timed out after 50ms")
+	test.AssertStringEquals(t, "", health.Checks["hung2"].Status, HealthStatusDown)
+}
+
+func TestHealthCheckReplacedWhileRunning(t *testing.T) {
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.AddHealthCheck("check", func() error { return nil })
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			server.AddHealthCheck("check", func() error { return nil })
+		}
+		close(done)
+	}()
+	for i := 0; i < 100; i++ {
+		test.AssertBoolTrue(t, "", server.GetHealth(false).IsUp())
+	}
+	<-done
+}
+
+func TestHealthReadyWhenStopping(t *testing.T) {
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.serverState.State = "STOPPING"
+	test.AssertBoolTrue(t, "Live", server.GetHealth(false).IsUp())
+	test.AssertBoolFalse(t, "Ready", server.GetHealth(true).IsUp())
+}
+
+func TestHealthBuiltInPathChecks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "health")
+	test.AssertErrorIsNil(t, "", err)
+	defer os.RemoveAll(dir)
+	static := filepath.Join(dir, "static")
+	test.AssertErrorIsNil(t, "", os.Mkdir(static, 0755))
+
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.SetStaticFileServerData(map[string]string{"/static": static})
+	server.SetOsScriptsData(dir, map[string][]string{"list": {"ls"}})
+	test.AssertBoolTrue(t, "Paths exist", server.GetHealth(true).IsUp())
+
+	test.AssertErrorIsNil(t, "", os.Remove(static))
+	health := server.GetHealth(true)
+	test.AssertBoolFalse(t, "Static path removed", health.IsUp())
+	test.AssertStringEquals(t, "", health.Checks[HealthCheckStaticPaths].Status, HealthStatusDown)
+	test.AssertStringEquals(t, "", health.Checks[HealthCheckOsScripts].Status, HealthStatusUp)
+}
+
+func TestHealthHandlerResponse(t *testing.T) {
+	logging.CreateTestLogger("TestHealth")
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.AddHealthCheck("bad", func() error { return errors.New("Bad Thing") })
+	req := httptest.NewRequest(http.MethodGet, "/health/ready", nil)
+	rec := httptest.NewRecorder()
+	response := NewResponse(NewResponseWriterWrapper(rec), server, "TXID")
+	HealthReadyHandler(req, response)
+	test.AssertBoolTrue(t, "Closed", response.IsClosed())
+	test.AssertIntEqual(t, "", rec.Code, http.StatusServiceUnavailable)
+	test.AssertStringContains(t, "", rec.Body.String(), "\"Status\":\"DOWN\"", "\"State\":\"RUNNING\"", "\"bad\":{\"Status\":\"DOWN\",\"Error\":\"Bad Thing\"}")
+}
+
+func TestHealthLiveIgnoresChecks(t *testing.T) {
+	logging.CreateTestLogger("TestHealth")
+	server := NewServerInstanceData("ServerName", "utf-8")
+	called := false
+	server.AddHealthCheck("bad", func() error {
+		called = true
+		return errors.New("Bad Thing")
+	})
+	req := httptest.NewRequest(http.MethodGet, "/health/live", nil)
+	rec := httptest.NewRecorder()
+	HealthLiveHandler(req, NewResponse(NewResponseWriterWrapper(rec), server, "TXID"))
+	test.AssertIntEqual(t, "", rec.Code, http.StatusOK)
+	test.AssertStringContains(t, "", rec.Body.String(), "\"Status\":\"UP\"", "\"State\":\"RUNNING\"")
+	test.AssertBoolFalse(t, "Checks not run", called)
+}
@@ -0,0 +1,184 @@
+package servermain
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+/*
+ipRule the allowed and denied networks for a route prefix
+*/
+type ipRule struct {
+	prefix string
+	allow  []*net.IPNet
+	deny   []*net.IPNet
+}
+
+/*
+IPFilter - Allow or deny requests by client IP address (CIDR) per route prefix.
+The longest matching prefix is used. Deny is checked first. If an allow list is defined the client must be in it.
+
+The client IP address is the connection address. If the connection is from a trusted proxy then the
+X-Forwarded-For header is used. Addresses are taken from the right, skipping trusted proxies.
+
+Use ServerInstanceData.SetIPFilter so the filter is applied before the mapping for the url is found.
+*/
+type IPFilter struct {
+	mutex          sync.RWMutex
+	rules          []*ipRule
+	trustedProxies []*net.IPNet
+}
+
+/*
+NewIPFilter create an IP filter with no rules. All requests are allowed.
+*/
+func NewIPFilter() *IPFilter {
+	return &IPFilter{
+		rules:          []*ipRule{},
+		trustedProxies: []*net.IPNet{},
+	}
+}
+
+/*
+AddIPRule adds allowed and denied networks for ALL urls starting with prefix.
+Networks are in CIDR notation (10.0.0.0/8) or single IP addresses (10.1.2.3).
+*/
+func (p *IPFilter) AddIPRule(prefix string, allow []string, deny []string) {
+	rule := &ipRule{
+		prefix: prefix,
+		allow:  parseNetworks("AddIPRule: Prefix ["+prefix+"] allow", allow),
+		deny:   parseNetworks("AddIPRule: Prefix ["+prefix+"] deny", deny),
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.rules = append(p.rules, rule)
+}
+
+/*
+SetTrustedProxies defines the proxies (CIDR or IP addresses) that are trusted to set X-Forwarded-For
+*/
+func (p *IPFilter) SetTrustedProxies(proxies []string) {
+	networks := parseNetworks("SetTrustedProxies", proxies)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.trustedProxies = networks
+}
+
+/*
+IsAllowed returns true if the client IP address is allowed for the url. The client IP address is also returned.
+*/
+func (p *IPFilter) IsAllowed(request *http.Request) (bool, string) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	clientIP := getClientIP(request, p.trustedProxies)
+	rule := p.findRule(request.URL.Path)
+	if rule == nil {
+		return true, clientIP
+	}
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false, clientIP
+	}
+	if containsIP(rule.deny, ip) {
+		return false, clientIP
+	}
+	if len(rule.allow) > 0 && !containsIP(rule.allow, ip) {
+		return false, clientIP
+	}
+	return true, clientIP
+}
+
+/*
+GetClientIP returns the IP address of the client. X-Forwarded-For is only used if the connection is from a trusted proxy.
+*/
+func (p *IPFilter) GetClientIP(request *http.Request) string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return getClientIP(request, p.trustedProxies)
+}
+
+/*
+getClientIP returns the connection address. If the connection is from a trusted proxy then the
+X-Forwarded-For header is used. Addresses are taken from the right, skipping trusted proxies.
+*/
+func getClientIP(request *http.Request, trustedProxies []*net.IPNet) string {
+	clientIP := getRemoteIP(request)
+	if !isTrustedProxy(trustedProxies, clientIP) {
+		return clientIP
+	}
+	forwarded := strings.Split(strings.Join(request.Header["X-Forwarded-For"], ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(forwarded[i])
+		if address == "" {
+			continue
+		}
+		clientIP = address
+		if !isTrustedProxy(trustedProxies, address) {
+			break
+		}
+	}
+	return clientIP
+}
+
+/*
+getRemoteIP returns the IP address of the connected client (without the port)
+*/
+func getRemoteIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
+func isTrustedProxy(trustedProxies []*net.IPNet, address string) bool {
+	ip := net.ParseIP(address)
+	return ip != nil && containsIP(trustedProxies, ip)
+}
+
+func (p *IPFilter) findRule(url string) *ipRule {
+	var found *ipRule
+	for _, rule := range p.rules {
+		if hasPathPrefix(url, rule.prefix) {
+			if found == nil || len(rule.prefix) > len(found.prefix) {
+				found = rule
+			}
+		}
+	}
+	return found
+}
+
+func parseNetworks(desc string, list []string) []*net.IPNet {
+	networks := []*net.IPNet{}
+	for _, value := range list {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				panic(desc + ": [" + value + "] is not a valid IP address")
+			}
+			if ip.To4() != nil {
+				value = value + "/32"
+			} else {
+				value = value + "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			panic(desc + ": [" + value + "] is not a valid CIDR: " + err.Error())
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
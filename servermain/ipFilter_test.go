@@ -0,0 +1,80 @@
+package servermain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stuartdd/webServerBase/logging"
+	"github.com/stuartdd/webServerBase/panicapi"
+	"github.com/stuartdd/webServerBase/test"
+)
+
+func TestIPFilterAllowDeny(t *testing.T) {
+	filter := NewIPFilter()
+	filter.AddIPRule("/", nil, []string{"192.168.99.0/24"})
+	filter.AddIPRule("/script/", []string{"10.0.0.0/8", "172.16.1.1"}, []string{"10.9.0.0/16"})
+	filter.AddIPRule("/stop", []string{"::1", "127.0.0.1"}, nil)
+
+	test.AssertBoolTrue(t, "Allowed", ipFilterTest(filter, "/script/list", "10.1.2.3:1", ""))
+	test.AssertBoolTrue(t, "Allowed IP", ipFilterTest(filter, "/script/list", "172.16.1.1:1", ""))
+	test.AssertBoolFalse(t, "Not in allow", ipFilterTest(filter, "/script/list", "172.16.1.2:1", ""))
+	test.AssertBoolFalse(t, "Denied", ipFilterTest(filter, "/script/list", "10.9.1.1:1", ""))
+	test.AssertBoolFalse(t, "Leading slashes", ipFilterTest(filter, "//script/list", "8.8.8.8:1", ""))
+	test.AssertBoolFalse(t, "Empty parts", ipFilterTest(filter, "/script//list/", "8.8.8.8:1", ""))
+	test.AssertBoolFalse(t, "No trailing slash", ipFilterTest(filter, "/script", "8.8.8.8:1", ""))
+	test.AssertBoolTrue(t, "IPv6", ipFilterTest(filter, "/stop", "[::1]:1", ""))
+	test.AssertBoolFalse(t, "IPv6", ipFilterTest(filter, "/stop", "[::2]:1", ""))
+	test.AssertBoolTrue(t, "Root", ipFilterTest(filter, "/status", "8.8.8.8:1", ""))
+	test.AssertBoolFalse(t, "Root deny", ipFilterTest(filter, "/status", "192.168.99.1:1", ""))
+}
+
+func TestIPFilterTrustedProxies(t *testing.T) {
+	filter := NewIPFilter()
+	filter.SetTrustedProxies([]string{"10.0.0.1", "10.0.1.0/24"})
+	filter.AddIPRule("/script/", []string{"192.168.1.0/24"}, nil)
+
+	test.AssertBoolTrue(t, "Via proxy", ipFilterTest(filter, "/script/list", "10.0.0.1:1", "192.168.1.5"))
+	test.AssertBoolTrue(t, "Via 2 proxies", ipFilterTest(filter, "/script/list", "10.0.0.1:1", "192.168.1.5, 10.0.1.7"))
+	test.AssertBoolFalse(t, "Spoofed", ipFilterTest(filter, "/script/list", "10.0.0.1:1", "192.168.1.5, 8.8.8.8"))
+	test.AssertBoolFalse(t, "Untrusted proxy", ipFilterTest(filter, "/script/list", "8.8.8.8:1", "192.168.1.5"))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.1.9:1"
+	test.AssertStringEquals(t, "No header", filter.GetClientIP(req), "10.0.1.9")
+}
+
+func TestIPFilterBadConfig(t *testing.T) {
+	defer test.AssertPanicAndRecover(t, "[10.0.0.0/33] is not a valid CIDR")
+	NewIPFilter().AddIPRule("/", []string{"10.0.0.0/33"}, nil)
+}
+
+func TestIPFilterServer(t *testing.T) {
+	logging.CreateTestLogger("TestIPFilter")
+	filter := NewIPFilter()
+	filter.AddIPRule("/", []string{"192.0.2.0/24"}, nil)
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.SetIPFilter(filter)
+	server.AddMappedHandler("/status", http.MethodGet, StatusHandler)
+
+	rec := serveTestRequest(server, "/status")
+	test.AssertIntEqual(t, "httptest uses 192.0.2.1", rec.Code, 200)
+
+	req := httptest.NewRequest(http.MethodGet, "/unmapped", nil)
+	req.RemoteAddr = "8.8.8.8:1"
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	test.AssertIntEqual(t, "Before mapping", rec.Code, 403)
+	test.AssertStringContains(t, "", rec.Body.String(), "\"Code\":"+strconv.Itoa(panicapi.SCIPDenied))
+}
+
+func ipFilterTest(filter *IPFilter, url string, remoteAddr string, forwardedFor string) bool {
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	req.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	allowed, _ := filter.IsAllowed(req)
+	return allowed
+}
@@ -0,0 +1,581 @@
+package servermain
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/stuartdd/webServerBase/panicapi"
+)
+
+/*
+SchemaViolation - A value that does not match a JSON schema.
+Pointer is the JSON pointer (RFC 6901) to the value in the document. The root of the document is "".
+*/
+type SchemaViolation struct {
+	Pointer string
+	Message string
+}
+
+/*
+JSONSchemas - A named set of JSON schemas loaded from a directory. The name of a schema is the file name without the .json extension.
+
+The following (draft 7) keywords are supported:
+
+	type enum const $ref allOf anyOf oneOf not
+	properties required additionalProperties minProperties maxProperties
+	items minItems maxItems uniqueItems
+	minLength maxLength pattern
+	minimum maximum exclusiveMinimum exclusiveMaximum multipleOf
+
+A $ref can refer to the same schema (#/definitions/name) or another schema in the set (name.json#/definitions/name).
+Every $ref is resolved by CheckRefs when the schemas are loaded. Other keywords (E.G. format) are ignored.
+*/
+type JSONSchemas struct {
+	path     string
+	schemas  map[string]interface{}
+	patterns map[string]*regexp.Regexp
+}
+
+/*
+LoadJSONSchemas load ALL of the *.json files in path as JSON schemas.
+Panics if the path cannot be read, a schema is invalid or a $ref cannot be resolved (see CheckRefs) as this is a configuration error.
+*/
+func LoadJSONSchemas(path string) *JSONSchemas {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		panic("LoadJSONSchemas: The path [" + path + "] could not be read: " + err.Error())
+	}
+	p := &JSONSchemas{
+		path:     path,
+		schemas:  make(map[string]interface{}),
+		patterns: make(map[string]*regexp.Regexp),
+	}
+	for _, file := range files {
+		if file.IsDir() || strings.ToLower(filepath.Ext(file.Name())) != ".json" {
+			continue
+		}
+		fileName := filepath.Join(path, file.Name())
+		content, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			panic("LoadJSONSchemas: The schema [" + fileName + "] could not be read: " + err.Error())
+		}
+		p.AddSchema(strings.TrimSuffix(file.Name(), filepath.Ext(file.Name())), content)
+	}
+	p.CheckRefs()
+	return p
+}
+
+/*
+NewJSONSchemas create an empty schema set. Use AddSchema to add schemas.
+*/
+func NewJSONSchemas() *JSONSchemas {
+	return &JSONSchemas{
+		path:     "",
+		schemas:  make(map[string]interface{}),
+		patterns: make(map[string]*regexp.Regexp),
+	}
+}
+
+/*
+AddSchema add a JSON schema with the given name. Panics if the schema is invalid.
+A $ref can refer to a schema that has not been added yet so call CheckRefs once ALL of the schemas have been added.
+*/
+func (p *JSONSchemas) AddSchema(name string, content []byte) {
+	var schema interface{}
+	err := json.Unmarshal(content, &schema)
+	if err != nil {
+		panic("JSONSchemas: The schema [" + name + "] is not valid JSON: " + err.Error())
+	}
+	switch schema.(type) {
+	case map[string]interface{}, bool:
+	default:
+		panic("JSONSchemas: The schema [" + name + "] must be an object or a boolean")
+	}
+	p.compilePatterns(name, schema)
+	p.schemas[name] = schema
+}
+
+/*
+CheckRefs resolves every $ref in ALL of the schemas. Panics if a $ref cannot be resolved or if a $ref leads back
+to itself without validating any data (E.G. {"$ref": "#"} or {"allOf": [{"$ref": "#"}]}) as validation would never end.
+
+A $ref inside properties or items (E.G. a tree of nodes) is not a cycle as each step validates a child value.
+*/
+func (p *JSONSchemas) CheckRefs() {
+	names := []string{}
+	for name := range p.schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p.checkRefs(name, p.schemas[name])
+	}
+}
+
+/*
+checkRefs resolves each $ref in the schema and checks that it does not lead to a cycle
+*/
+func (p *JSONSchemas) checkRefs(schemaName string, schema interface{}) {
+	switch s := schema.(type) {
+	case map[string]interface{}:
+		if ref, ok := s["$ref"].(string); ok {
+			if _, _, found := p.lookupRef(schemaName, ref); !found {
+				panic("JSONSchemas: The schema [" + schemaName + "] $ref [" + ref + "] was not found")
+			}
+			p.checkCycle(schemaName, s, make(map[uintptr]bool), []string{})
+		}
+		for key, value := range s {
+			if key != "enum" && key != "const" {
+				p.checkRefs(schemaName, value)
+			}
+		}
+	case []interface{}:
+		for _, value := range s {
+			p.checkRefs(schemaName, value)
+		}
+	}
+}
+
+/*
+checkCycle follows the keywords that validate the same value ($ref allOf anyOf oneOf not). Panics if a schema is reached twice.
+*/
+func (p *JSONSchemas) checkCycle(schemaName string, schema interface{}, visiting map[uintptr]bool, refs []string) {
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		return
+	}
+	id := reflect.ValueOf(s).Pointer()
+	if visiting[id] {
+		panic("JSONSchemas: The schema [" + schemaName + "] has a $ref cycle [" + strings.Join(refs, " -> ") + "]")
+	}
+	visiting[id] = true
+	defer delete(visiting, id)
+	if ref, ok := s["$ref"].(string); ok {
+		refName, refSchema, found := p.lookupRef(schemaName, ref)
+		if found {
+			p.checkCycle(refName, refSchema, visiting, append(refs, ref))
+		}
+		return
+	}
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf"} {
+		if list, ok := s[keyword].([]interface{}); ok {
+			for _, sub := range list {
+				p.checkCycle(schemaName, sub, visiting, refs)
+			}
+		}
+	}
+	if not, ok := s["not"]; ok {
+		p.checkCycle(schemaName, not, visiting, refs)
+	}
+}
+
+/*
+HasSchema returns true if a schema with the name exists
+*/
+func (p *JSONSchemas) HasSchema(name string) bool {
+	_, found := p.schemas[name]
+	return found
+}
+
+/*
+ListSchemaNames returns the names of the schemas separated by sep
+*/
+func (p *JSONSchemas) ListSchemaNames(sep string) string {
+	names := []string{}
+	for name := range p.schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, sep)
+}
+
+/*
+Validate an object against the named schema. The object is converted to JSON first so struct fields
+are validated using their JSON names. Returns ALL of the violations. Empty if the object is valid.
+*/
+func (p *JSONSchemas) Validate(name string, object interface{}) []*SchemaViolation {
+	schema, found := p.schemas[name]
+	if !found {
+		panicapi.ThrowError(500, panicapi.SCSchemaValidation, "Schema not found", fmt.Sprintf("JSONSchemas: Schema [%s] not found. Available: %s", name, p.ListSchemaNames(", ")))
+	}
+	jsonBytes, err := json.Marshal(object)
+	if err != nil {
+		panicapi.ThrowError(500, panicapi.SCSchemaValidation, "Object could not be validated", fmt.Sprintf("JSONSchemas: Marshal type [%T] failed: %s", object, err.Error()))
+	}
+	var document interface{}
+	err = json.Unmarshal(jsonBytes, &document)
+	if err != nil {
+		panicapi.ThrowError(500, panicapi.SCSchemaValidation, "Object could not be validated", err.Error())
+	}
+	violations := []*SchemaViolation{}
+	p.validate(name, schema, document, "", &violations)
+	return violations
+}
+
+/*
+validateRequestBody validates the request body if a schema is defined for the route and method.
+
+Only JSON and YAML bodies are validated. Other content types (including XML and forms that GetBodyAsObject can decode)
+are rejected with a 415 (Unsupported Media Type) because they do not map on to a JSON document.
+If the body is not valid the response is set to a 400 with the violations in the error details and false is returned.
+*/
+func (p *ServerInstanceData) validateRequestBody(request *http.Request, response *Response) bool {
+	schemaName, found := p.routeSchemas[routeSchemaKey(response.GetRoute(), request.Method)]
+	if !found {
+		return true
+	}
+	h := NewRequestHandlerHelper(request, response)
+	var document interface{}
+	format, mediaType := getBodyFormat(request.Header.Get(ContentTypeName))
+	switch format {
+	case bodyFormatJSON:
+		h.GetJSONBodyAsObject(&document)
+	case bodyFormatYAML:
+		decodeYAMLBody(h.GetBody(), &document)
+	default:
+		panicapi.ThrowError(415, panicapi.SCUnsupportedMediaType, "Unsupported Media Type", fmt.Sprintf("Schema %s: Content-Type [%s] is not supported", schemaName, mediaType))
+	}
+	violations := p.schemas.Validate(schemaName, document)
+	if len(violations) == 0 {
+		return true
+	}
+	if p.logger.IsWarn() {
+		p.logger.LogWarnf("ID: %s. Request body failed schema %s with %d violation(s). First: %s %s", response.GetTransactionID(), schemaName, len(violations), violations[0].Pointer, violations[0].Message)
+	}
+	response.SetErrorResponseWithDetails(400, panicapi.SCSchemaValidation, fmt.Sprintf("Request body failed schema %s", schemaName), violations)
+	return false
+}
+
+func routeSchemaKey(route string, method string) string {
+	return strings.ToUpper(method) + " /" + strings.Trim(route, "/")
+}
+
+func (p *JSONSchemas) validate(schemaName string, schema interface{}, value interface{}, pointer string, violations *[]*SchemaViolation) {
+	switch s := schema.(type) {
+	case bool:
+		if !s {
+			addViolation(violations, pointer, "No value is allowed")
+		}
+		return
+	case map[string]interface{}:
+		if ref, ok := s["$ref"].(string); ok {
+			refName, refSchema := p.resolveRef(schemaName, ref)
+			p.validate(refName, refSchema, value, pointer, violations)
+			return
+		}
+		p.validateGeneral(schemaName, s, value, pointer, violations)
+		switch v := value.(type) {
+		case map[string]interface{}:
+			p.validateObject(schemaName, s, v, pointer, violations)
+		case []interface{}:
+			p.validateArray(schemaName, s, v, pointer, violations)
+		case string:
+			p.validateString(s, v, pointer, violations)
+		case float64:
+			validateNumber(s, v, pointer, violations)
+		}
+	}
+}
+
+func (p *JSONSchemas) validateGeneral(schemaName string, s map[string]interface{}, value interface{}, pointer string, violations *[]*SchemaViolation) {
+	if t, ok := s["type"]; ok {
+		types := []string{}
+		switch tv := t.(type) {
+		case string:
+			types = append(types, tv)
+		case []interface{}:
+			for _, name := range tv {
+				types = append(types, fmt.Sprintf("%v", name))
+			}
+		}
+		if !matchesAnyType(types, value) {
+			addViolation(violations, pointer, fmt.Sprintf("Expected type %s but found %s", strings.Join(types, " or "), jsonTypeName(value)))
+			return
+		}
+	}
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if reflect.DeepEqual(e, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			addViolation(violations, pointer, "Value is not one of the allowed values")
+		}
+	}
+	if c, ok := s["const"]; ok && !reflect.DeepEqual(c, value) {
+		addViolation(violations, pointer, "Value does not match the constant value")
+	}
+	if all, ok := s["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			p.validate(schemaName, sub, value, pointer, violations)
+		}
+	}
+	if anyOf, ok := s["anyOf"].([]interface{}); ok {
+		if p.countMatches(schemaName, anyOf, value, pointer) == 0 {
+			addViolation(violations, pointer, "Value does not match any of the schemas in anyOf")
+		}
+	}
+	if oneOf, ok := s["oneOf"].([]interface{}); ok {
+		count := p.countMatches(schemaName, oneOf, value, pointer)
+		if count != 1 {
+			addViolation(violations, pointer, fmt.Sprintf("Value must match exactly one schema in oneOf. Matched %d", count))
+		}
+	}
+	if not, ok := s["not"]; ok {
+		if p.countMatches(schemaName, []interface{}{not}, value, pointer) > 0 {
+			addViolation(violations, pointer, "Value must not match the schema in not")
+		}
+	}
+}
+
+func (p *JSONSchemas) validateObject(schemaName string, s map[string]interface{}, value map[string]interface{}, pointer string, violations *[]*SchemaViolation) {
+	if required, ok := s["required"].([]interface{}); ok {
+		for _, r := range required {
+			name := fmt.Sprintf("%v", r)
+			if _, found := value[name]; !found {
+				addViolation(violations, pointer+"/"+escapePointer(name), "Required property is missing")
+			}
+		}
+	}
+	if min, ok := getSchemaInt(s, "minProperties"); ok && len(value) < min {
+		addViolation(violations, pointer, fmt.Sprintf("Object must have at least %d properties", min))
+	}
+	if max, ok := getSchemaInt(s, "maxProperties"); ok && len(value) > max {
+		addViolation(violations, pointer, fmt.Sprintf("Object must have at most %d properties", max))
+	}
+	properties, _ := s["properties"].(map[string]interface{})
+	additional, hasAdditional := s["additionalProperties"]
+	for _, name := range sortedPropertyNames(value) {
+		childPointer := pointer + "/" + escapePointer(name)
+		if propertySchema, found := properties[name]; found {
+			p.validate(schemaName, propertySchema, value[name], childPointer, violations)
+		} else if hasAdditional {
+			if allowed, isBool := additional.(bool); isBool && !allowed {
+				addViolation(violations, childPointer, "Additional property is not allowed")
+			} else {
+				p.validate(schemaName, additional, value[name], childPointer, violations)
+			}
+		}
+	}
+}
+
+func (p *JSONSchemas) validateArray(schemaName string, s map[string]interface{}, value []interface{}, pointer string, violations *[]*SchemaViolation) {
+	if min, ok := getSchemaInt(s, "minItems"); ok && len(value) < min {
+		addViolation(violations, pointer, fmt.Sprintf("Array must have at least %d items", min))
+	}
+	if max, ok := getSchemaInt(s, "maxItems"); ok && len(value) > max {
+		addViolation(violations, pointer, fmt.Sprintf("Array must have at most %d items", max))
+	}
+	if unique, ok := s["uniqueItems"].(bool); ok && unique {
+		for i := 1; i < len(value); i++ {
+			for j := 0; j < i; j++ {
+				if reflect.DeepEqual(value[i], value[j]) {
+					addViolation(violations, pointer+"/"+strconv.Itoa(i), fmt.Sprintf("Item is a duplicate of item %d", j))
+				}
+			}
+		}
+	}
+	switch items := s["items"].(type) {
+	case []interface{}:
+		for i, item := range value {
+			if i < len(items) {
+				p.validate(schemaName, items[i], item, pointer+"/"+strconv.Itoa(i), violations)
+			}
+		}
+	case nil:
+	default:
+		for i, item := range value {
+			p.validate(schemaName, items, item, pointer+"/"+strconv.Itoa(i), violations)
+		}
+	}
+}
+
+func (p *JSONSchemas) validateString(s map[string]interface{}, value string, pointer string, violations *[]*SchemaViolation) {
+	length := utf8.RuneCountInString(value)
+	if min, ok := getSchemaInt(s, "minLength"); ok && length < min {
+		addViolation(violations, pointer, fmt.Sprintf("String must be at least %d characters", min))
+	}
+	if max, ok := getSchemaInt(s, "maxLength"); ok && length > max {
+		addViolation(violations, pointer, fmt.Sprintf("String must be at most %d characters", max))
+	}
+	if pattern, ok := s["pattern"].(string); ok && !p.patterns[pattern].MatchString(value) {
+		addViolation(violations, pointer, fmt.Sprintf("String does not match the pattern %s", pattern))
+	}
+}
+
+func validateNumber(s map[string]interface{}, value float64, pointer string, violations *[]*SchemaViolation) {
+	if min, ok := s["minimum"].(float64); ok && value < min {
+		addViolation(violations, pointer, fmt.Sprintf("Value must be at least %v", min))
+	}
+	if max, ok := s["maximum"].(float64); ok && value > max {
+		addViolation(violations, pointer, fmt.Sprintf("Value must be at most %v", max))
+	}
+	if min, ok := s["exclusiveMinimum"].(float64); ok && value <= min {
+		addViolation(violations, pointer, fmt.Sprintf("Value must be greater than %v", min))
+	}
+	if max, ok := s["exclusiveMaximum"].(float64); ok && value >= max {
+		addViolation(violations, pointer, fmt.Sprintf("Value must be less than %v", max))
+	}
+	if multiple, ok := s["multipleOf"].(float64); ok && multiple > 0 {
+		quotient := value / multiple
+		if math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+			addViolation(violations, pointer, fmt.Sprintf("Value must be a multiple of %v", multiple))
+		}
+	}
+}
+
+/*
+countMatches returns the number of schemas in the list that the value matches
+*/
+func (p *JSONSchemas) countMatches(schemaName string, schemas []interface{}, value interface{}, pointer string) int {
+	count := 0
+	for _, sub := range schemas {
+		subViolations := []*SchemaViolation{}
+		p.validate(schemaName, sub, value, pointer, &subViolations)
+		if len(subViolations) == 0 {
+			count++
+		}
+	}
+	return count
+}
+
+/*
+resolveRef returns the schema name and the schema for a $ref. Panics if it cannot be resolved.
+*/
+func (p *JSONSchemas) resolveRef(schemaName string, ref string) (string, interface{}) {
+	name, schema, found := p.lookupRef(schemaName, ref)
+	if !found {
+		panicapi.ThrowError(500, panicapi.SCSchemaValidation, "Schema reference not found", fmt.Sprintf("JSONSchemas: Schema [%s] $ref [%s] not found", schemaName, ref))
+	}
+	return name, schema
+}
+
+/*
+lookupRef returns the schema name and the schema for a $ref. Returns false if it cannot be resolved.
+*/
+func (p *JSONSchemas) lookupRef(schemaName string, ref string) (string, interface{}, bool) {
+	name := schemaName
+	fragment := ref
+	pos := strings.Index(ref, "#")
+	if pos < 0 {
+		name, fragment = ref, ""
+	} else if pos > 0 {
+		name, fragment = ref[:pos], ref[pos+1:]
+	} else {
+		fragment = ref[1:]
+	}
+	name = strings.TrimSuffix(name, ".json")
+	schema, found := p.schemas[name]
+	if !found {
+		return name, nil, false
+	}
+	if fragment == "" {
+		return name, schema, true
+	}
+	for _, token := range strings.Split(strings.TrimPrefix(fragment, "/"), "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		m, ok := schema.(map[string]interface{})
+		if !ok {
+			schema = nil
+			break
+		}
+		schema, found = m[token]
+		if !found {
+			break
+		}
+	}
+	return name, schema, schema != nil && found
+}
+
+/*
+compilePatterns compiles ALL pattern keywords in the schema so they are only compiled once
+*/
+func (p *JSONSchemas) compilePatterns(name string, schema interface{}) {
+	switch s := schema.(type) {
+	case map[string]interface{}:
+		for key, value := range s {
+			if pattern, ok := value.(string); ok && key == "pattern" {
+				re, err := regexp.Compile(pattern)
+				if err != nil {
+					panic("JSONSchemas: The schema [" + name + "] pattern [" + pattern + "] is invalid: " + err.Error())
+				}
+				p.patterns[pattern] = re
+			} else {
+				p.compilePatterns(name, value)
+			}
+		}
+	case []interface{}:
+		for _, value := range s {
+			p.compilePatterns(name, value)
+		}
+	}
+}
+
+func matchesAnyType(types []string, value interface{}) bool {
+	for _, t := range types {
+		switch t {
+		case "integer":
+			if f, ok := value.(float64); ok && f == math.Trunc(f) {
+				return true
+			}
+		default:
+			if t == jsonTypeName(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func getSchemaInt(s map[string]interface{}, name string) (int, bool) {
+	f, ok := s[name].(float64)
+	return int(f), ok
+}
+
+func sortedPropertyNames(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func escapePointer(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
+
+func addViolation(violations *[]*SchemaViolation, pointer string, message string) {
+	*violations = append(*violations, &SchemaViolation{Pointer: pointer, Message: message})
+}
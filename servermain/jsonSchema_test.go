@@ -0,0 +1,161 @@
+package servermain
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stuartdd/webServerBase/logging"
+	"github.com/stuartdd/webServerBase/panicapi"
+	"github.com/stuartdd/webServerBase/test"
+)
+
+var orderSchema = `{
+	"type": "object",
+	"required": ["id", "items"],
+	"additionalProperties": false,
+	"properties": {
+		"id": {"type": "integer", "minimum": 1},
+		"ref": {"type": "string", "pattern": "^[A-Z]{3}$"},
+		"status": {"enum": ["NEW", "PAID"]},
+		"items": {"type": "array", "minItems": 1, "items": {"$ref": "item.json"}},
+		"a/b": {"type": "string"}
+	}
+}`
+
+var itemSchema = `{
+	"type": "object",
+	"required": ["name"],
+	"properties": {
+		"name": {"type": "string", "minLength": 2},
+		"qty": {"$ref": "#/definitions/qty"}
+	},
+	"definitions": {"qty": {"type": "number", "exclusiveMinimum": 0, "multipleOf": 0.5}}
+}`
+
+func TestSchemaValidation(t *testing.T) {
+	schemas := NewJSONSchemas()
+	schemas.AddSchema("order", []byte(orderSchema))
+	schemas.AddSchema("item", []byte(itemSchema))
+
+	valid := map[string]interface{}{"id": 1, "ref": "ABC", "status": "NEW", "items": []interface{}{map[string]interface{}{"name": "Pen", "qty": 1.5}}}
+	test.AssertIntEqual(t, "Valid", len(schemas.Validate("order", valid)), 0)
+
+	invalid := map[string]interface{}{"id": 1.5, "ref": "abc", "status": "OLD", "items": []interface{}{map[string]interface{}{"name": "P", "qty": 0.3}, map[string]interface{}{}}, "a/b": 1, "extra": true}
+	violations := schemas.Validate("order", invalid)
+	pointers := []string{}
+	for _, v := range violations {
+		pointers = append(pointers, v.Pointer)
+	}
+	test.AssertStringEquals(t, "Pointers", strings.Join(pointers, ","), "/a~1b,/extra,/id,/items/0/name,/items/0/qty,/items/1/name,/ref,/status")
+
+	type order struct {
+		ID    int           `json:"id"`
+		Items []interface{} `json:"items"`
+	}
+	violations = schemas.Validate("order", &order{ID: 0})
+	test.AssertIntEqual(t, "Struct", len(violations), 2)
+	test.AssertStringEquals(t, "", violations[0].Pointer, "/id")
+	test.AssertStringContains(t, "", violations[1].Message, "Expected type array but found null")
+}
+
+func TestSchemaBadConfig(t *testing.T) {
+	defer test.AssertPanicAndRecover(t, "pattern [(] is invalid")
+	NewJSONSchemas().AddSchema("bad", []byte(`{"properties": {"a": {"pattern": "("}}}`))
+}
+
+func TestSchemaRefNotFound(t *testing.T) {
+	schemas := NewJSONSchemas()
+	schemas.AddSchema("order", []byte(orderSchema))
+	defer test.AssertPanicAndRecover(t, "The schema [order] $ref [item.json] was not found")
+	schemas.CheckRefs()
+}
+
+func TestSchemaRefFragmentNotFound(t *testing.T) {
+	schemas := NewJSONSchemas()
+	schemas.AddSchema("a", []byte(`{"properties": {"x": {"$ref": "#/definitions/missing"}}, "definitions": {}}`))
+	defer test.AssertPanicAndRecover(t, "$ref [#/definitions/missing] was not found")
+	schemas.CheckRefs()
+}
+
+func TestSchemaRefCycle(t *testing.T) {
+	schemas := NewJSONSchemas()
+	schemas.AddSchema("a", []byte(`{"definitions": {"x": {"allOf": [{"$ref": "b.json#/definitions/y"}]}}, "properties": {"v": {"$ref": "#/definitions/x"}}}`))
+	schemas.AddSchema("b", []byte(`{"definitions": {"y": {"$ref": "a.json#/definitions/x"}}}`))
+	defer test.AssertPanicAndRecover(t, "has a $ref cycle")
+	schemas.CheckRefs()
+}
+
+func TestSchemaRecursiveRef(t *testing.T) {
+	schemas := NewJSONSchemas()
+	schemas.AddSchema("node", []byte(`{"type": "object", "required": ["name"], "properties": {"name": {"type": "string"}, "children": {"type": "array", "items": {"$ref": "#"}}}}`))
+	schemas.CheckRefs()
+	tree := map[string]interface{}{"name": "root", "children": []interface{}{map[string]interface{}{"name": "leaf"}, map[string]interface{}{}}}
+	violations := schemas.Validate("node", tree)
+	test.AssertIntEqual(t, "Recursive", len(violations), 1)
+	test.AssertStringEquals(t, "", violations[0].Pointer, "/children/1/name")
+}
+
+func TestSchemaRouteValidation(t *testing.T) {
+	logging.CreateTestLogger("TestSchema")
+	dir, err := ioutil.TempDir("", "schema")
+	test.AssertErrorIsNil(t, "", err)
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "order.json"), []byte(orderSchema), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "item.json"), []byte(itemSchema), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "readme.txt"), []byte("Not a schema"), 0644)
+
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.SetSchemaPath(dir)
+	test.AssertStringEquals(t, "", server.GetSchemas().ListSchemaNames(","), "item,order")
+	server.AddMappedHandlerWithNames("/order/?", http.MethodPost, func(r *http.Request, response *Response) {
+		h := NewRequestHandlerHelper(r, response)
+		response.SetResponse(200, "Order "+h.GetNamedURLPart("shop", "")+" "+h.GetBodyString(), "")
+	}, []string{"shop"})
+	server.SetRouteSchema("/order/?/", http.MethodPost, "order")
+
+	rec := schemaTest(server, "application/json", `{"id":1,"items":[{"name":"Pen"}]}`)
+	test.AssertIntEqual(t, "Valid", rec.Code, 200)
+	test.AssertStringEquals(t, "Body still readable", rec.Body.String(), `Order a {"id":1,"items":[{"name":"Pen"}]}`)
+
+	rec = schemaTest(server, "application/x-yaml", "id: 1\nitems:\n  - name: Pen\n")
+	test.AssertIntEqual(t, "YAML", rec.Code, 200)
+
+	rec = schemaTest(server, "application/json", `{"id":0,"items":[{"name":"P"}]}`)
+	test.AssertIntEqual(t, "Invalid", rec.Code, 400)
+	test.AssertStringContains(t, "", rec.Body.String(), "\"Code\":"+strconv.Itoa(panicapi.SCSchemaValidation), `"Details":[{"Pointer":"/id","Message":"Value must be at least 1"},{"Pointer":"/items/0/name"`)
+
+	rec = schemaTest(server, "text/plain", `abc`)
+	test.AssertIntEqual(t, "Media type", rec.Code, 415)
+	rec = schemaTest(server, "application/xml", `<order><id>1</id></order>`)
+	test.AssertIntEqual(t, "XML is not validated", rec.Code, 415)
+	/*
+		The available names are in the log text of the error (not split by panicapi)
+	*/
+	func() {
+		defer func() {
+			panicState := panicapi.GetPanicData(recover(), "tx")
+			test.AssertIntEqual(t, "", panicState.StatusCode, 500)
+			test.AssertStringContains(t, "", panicState.LogMessage, "Available: item, order")
+		}()
+		server.GetSchemas().Validate("missing", nil)
+	}()
+}
+
+func TestSchemaRouteNotFound(t *testing.T) {
+	defer test.AssertPanicAndRecover(t, "Schema [order] for route [/order] not found")
+	NewServerInstanceData("ServerName", "utf-8").SetRouteSchema("/order", http.MethodPost, "order")
+}
+
+func schemaTest(server *ServerInstanceData, contentType string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/order/a", strings.NewReader(body))
+	req.Header.Set(ContentTypeName, contentType)
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	return rec
+}
@@ -0,0 +1,99 @@
+package servermain
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+/*
+CreateJWT creates an HS256 signed JSON Web Token for the claims.
+Usefull for testing and for issuing tokens. Standard claims are 'sub' (the principal name), 'exp' and 'nbf' (unix seconds)
+*/
+func CreateJWT(claims map[string]interface{}, secret []byte) (string, error) {
+	header, err := json.Marshal(&jwtHeader{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signJWT(signingInput, secret)), nil
+}
+
+/*
+ValidateJWT validates an HS256 signed JSON Web Token and returns the claims.
+The signature, 'exp' (expiry) and 'nbf' (not before) claims are checked.
+A token without a numeric 'exp' claim is rejected. If 'nbf' is defined it must be numeric.
+*/
+func ValidateJWT(token string, secret []byte, now time.Time) (map[string]interface{}, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("JWT secret is not defined")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("JWT is malformed")
+	}
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("JWT header is not valid base64")
+	}
+	header := &jwtHeader{}
+	err = json.Unmarshal(headerBytes, header)
+	if err != nil {
+		return nil, errors.New("JWT header is not valid JSON")
+	}
+	if header.Alg != "HS256" {
+		return nil, errors.New("JWT algorithm [" + header.Alg + "] is not supported")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("JWT signature is not valid base64")
+	}
+	if !hmac.Equal(signature, signJWT(parts[0]+"."+parts[1], secret)) {
+		return nil, errors.New("JWT signature is invalid")
+	}
+	payloadBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("JWT payload is not valid base64")
+	}
+	claims := make(map[string]interface{})
+	err = json.Unmarshal(payloadBytes, &claims)
+	if err != nil {
+		return nil, errors.New("JWT payload is not valid JSON")
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("JWT does not have a numeric expiry (exp)")
+	}
+	if now.Unix() >= int64(exp) {
+		return nil, errors.New("JWT has expired")
+	}
+	if value, found := claims["nbf"]; found {
+		nbf, ok := value.(float64)
+		if !ok {
+			return nil, errors.New("JWT not before (nbf) is not numeric")
+		}
+		if now.Unix() < int64(nbf) {
+			return nil, errors.New("JWT is not valid yet")
+		}
+	}
+	return claims, nil
+}
+
+func signJWT(signingInput string, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,58 @@
+package servermain
+
+import (
+	"errors"
+	"io"
+)
+
+/*
+ErrRequestBodyTooLarge - Returned when reading a request body that exceeds the maximum body size.
+See SetMaxBodySize and SetRouteMaxBodySize
+*/
+var ErrRequestBodyTooLarge = errors.New("Request body too large")
+
+/*
+maxBodyReader - Limit the number of bytes that can be read from a request body.
+Unlike io.LimitReader an error is returned if the body is larger than the limit.
+*/
+type maxBodyReader struct {
+	body      io.ReadCloser
+	remaining int64
+	exceeded  bool
+}
+
+func newMaxBodyReader(body io.ReadCloser, maxBytes int64) io.ReadCloser {
+	return &maxBodyReader{
+		body:      body,
+		remaining: maxBytes,
+		exceeded:  false,
+	}
+}
+
+func (p *maxBodyReader) Read(b []byte) (int, error) {
+	if p.exceeded {
+		return 0, ErrRequestBodyTooLarge
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	/*
+		Read one more byte than remaining so we know if the limit was exceeded
+	*/
+	if int64(len(b)) > p.remaining+1 {
+		b = b[:p.remaining+1]
+	}
+	n, err := p.body.Read(b)
+	if int64(n) > p.remaining {
+		n = int(p.remaining)
+		p.remaining = 0
+		p.exceeded = true
+		return n, ErrRequestBodyTooLarge
+	}
+	p.remaining -= int64(n)
+	return n, err
+}
+
+func (p *maxBodyReader) Close() error {
+	return p.body.Close()
+}
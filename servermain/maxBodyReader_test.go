@@ -0,0 +1,74 @@
+package servermain
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stuartdd/webServerBase/logging"
+	"github.com/stuartdd/webServerBase/panicapi"
+	"github.com/stuartdd/webServerBase/test"
+)
+
+func TestMaxBodyReader(t *testing.T) {
+	body, err := ioutil.ReadAll(newMaxBodyReader(ioutil.NopCloser(strings.NewReader("12345")), 5))
+	test.AssertErrorIsNil(t, "At limit", err)
+	test.AssertStringEquals(t, "", string(body), "12345")
+
+	body, err = ioutil.ReadAll(newMaxBodyReader(ioutil.NopCloser(strings.NewReader("123456")), 5))
+	test.AssertBoolTrue(t, "Over limit", err == ErrRequestBodyTooLarge)
+	test.AssertStringEquals(t, "", string(body), "12345")
+}
+
+func TestMaxBodySize(t *testing.T) {
+	logging.CreateTestLogger("TestMaxBody")
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.AddMappedHandler("/body", http.MethodPost, func(r *http.Request, response *Response) {
+		response.SetResponse(200, NewRequestHandlerHelper(r, response).GetBodyString(), "")
+	})
+	server.AddMappedHandlerWithNames("/upload/?", http.MethodPost, func(r *http.Request, response *Response) {
+		n, err := ioutil.ReadAll(NewRequestHandlerHelper(r, response).GetBodyReader())
+		if err == ErrRequestBodyTooLarge {
+			response.SetErrorResponse(413, panicapi.SCBodyTooLarge, "Stream too large")
+			return
+		}
+		response.SetResponse(200, strconv.Itoa(len(n)), "")
+	}, []string{"name"})
+	server.SetMaxBodySize(10)
+	server.SetRouteMaxBodySize("/upload/?/", 20)
+	test.AssertIntEqual(t, "", int(server.GetMaxBodySize("/body")), 10)
+	test.AssertIntEqual(t, "", int(server.GetMaxBodySize("/upload/?")), 20)
+
+	rec := maxBodyTest(server, "/body", "0123456789", true)
+	test.AssertIntEqual(t, "At limit", rec.Code, 200)
+	test.AssertStringEquals(t, "", rec.Body.String(), "0123456789")
+
+	rec = maxBodyTest(server, "/body", "0123456789X", true)
+	test.AssertIntEqual(t, "Content-Length", rec.Code, 413)
+	test.AssertStringContains(t, "", rec.Body.String(), "\"Code\":"+strconv.Itoa(panicapi.SCBodyTooLarge))
+
+	rec = maxBodyTest(server, "/body", "0123456789X", false)
+	test.AssertIntEqual(t, "Chunked", rec.Code, 413)
+	test.AssertStringContains(t, "", rec.Body.String(), "\"Code\":"+strconv.Itoa(panicapi.SCBodyTooLarge))
+
+	rec = maxBodyTest(server, "/upload/a", "0123456789ABCDEFGHIJ", false)
+	test.AssertIntEqual(t, "Route limit", rec.Code, 200)
+	test.AssertStringEquals(t, "", rec.Body.String(), "20")
+
+	rec = maxBodyTest(server, "/upload/a", "0123456789ABCDEFGHIJK", false)
+	test.AssertIntEqual(t, "Stream", rec.Code, 413)
+	test.AssertStringContains(t, "", rec.Body.String(), "Stream too large")
+}
+
+func maxBodyTest(server *ServerInstanceData, url string, body string, withLength bool) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	if !withLength {
+		req.ContentLength = -1
+	}
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	return rec
+}
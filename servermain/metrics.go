@@ -0,0 +1,264 @@
+package servermain
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+MetricsContentType - The content type of the Prometheus text exposition format
+*/
+const MetricsContentType = "text/plain; version=0.0.4"
+
+/*
+metricsPrefix - All metric names start with this
+*/
+const metricsPrefix = "webserver_"
+
+/*
+defaultLatencyBuckets - Histogram upper bounds in seconds (same as the Prometheus client defaults)
+*/
+var defaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+/*
+histogramData - A single histogram for a single set of label values
+*/
+type histogramData struct {
+	labels  string
+	buckets []int64
+	count   int64
+	sum     float64
+}
+
+/*
+histogramVec - A set of histograms keyed by label values. For example {route="/status",method="GET",status="200"}
+*/
+type histogramVec struct {
+	bounds []float64
+	values map[string]*histogramData
+}
+
+/*
+counterVec - A set of counters keyed by label values.
+*/
+type counterVec struct {
+	values map[string]int64
+}
+
+/*
+serverMetrics - The live metrics. ALL access is via the mutex as requests are concurrent
+*/
+type serverMetrics struct {
+	mutex            sync.Mutex
+	inFlight         int64
+	staticBytes      int64
+	requests         *counterVec
+	requestLatency   *histogramVec
+	templateRender   *histogramVec
+	scriptExecutions *counterVec
+	scriptDurations  *histogramVec
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		inFlight:         0,
+		staticBytes:      0,
+		requests:         newCounterVec(),
+		requestLatency:   newHistogramVec(defaultLatencyBuckets),
+		templateRender:   newHistogramVec(defaultLatencyBuckets),
+		scriptExecutions: newCounterVec(),
+		scriptDurations:  newHistogramVec(defaultLatencyBuckets),
+	}
+}
+
+/*
+MetricsHandler returns the server metrics in the Prometheus text exposition format
+*/
+func MetricsHandler(request *http.Request, response *Response) {
+	response.SetResponse(200, response.GetWrappedServer().GetMetricsText(), MetricsContentType)
+}
+
+/*
+GetMetricsText returns the server metrics in the Prometheus text exposition format
+*/
+func (p *ServerInstanceData) GetMetricsText() string {
+	return p.metrics.text(p.statistics.getPanics())
+}
+
+func (p *serverMetrics) requestStarted() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.inFlight++
+}
+
+func (p *serverMetrics) requestFinished(method string, route string, status int, duration time.Duration) {
+	labels := formatLabels("route", routeName(route), "method", methodName(method, route), "status", strconv.Itoa(status))
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.inFlight--
+	p.requests.add(labels, 1)
+	p.requestLatency.observe(labels, duration.Seconds())
+}
+
+func (p *serverMetrics) addStaticBytes(count int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.staticBytes = p.staticBytes + count
+}
+
+func (p *serverMetrics) observeTemplate(templateName string, duration time.Duration) {
+	labels := formatLabels("template", templateName)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.templateRender.observe(labels, duration.Seconds())
+}
+
+func (p *serverMetrics) observeScript(scriptName string, retCode int, duration time.Duration) {
+	result := "ok"
+	if retCode != 0 {
+		result = "failed"
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.scriptExecutions.add(formatLabels("script", scriptName, "result", result), 1)
+	p.scriptDurations.observe(formatLabels("script", scriptName), duration.Seconds())
+}
+
+/*
+text - Write ALL metrics in the Prometheus text exposition format
+*/
+func (p *serverMetrics) text(panics int) string {
+	var b bytes.Buffer
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.requests.write(&b, metricsPrefix+"http_requests_total", "Total number of HTTP requests by route, method and status.")
+	p.requestLatency.write(&b, metricsPrefix+"http_request_duration_seconds", "HTTP request latency by route, method and status.")
+	writeSingleValue(&b, metricsPrefix+"http_requests_in_flight", "gauge", "Number of HTTP requests currently being served.", strconv.FormatInt(p.inFlight, 10))
+	writeSingleValue(&b, metricsPrefix+"panics_total", "counter", "Total number of unhandled panics recovered by the server.", strconv.Itoa(panics))
+	writeSingleValue(&b, metricsPrefix+"static_bytes_served_total", "counter", "Total number of bytes served from static files.", strconv.FormatInt(p.staticBytes, 10))
+	p.templateRender.write(&b, metricsPrefix+"template_render_duration_seconds", "Template render time by template name.")
+	p.scriptExecutions.write(&b, metricsPrefix+"os_script_executions_total", "Total number of OS script executions by script name and result.")
+	p.scriptDurations.write(&b, metricsPrefix+"os_script_duration_seconds", "OS script execution time by script name.")
+	return b.String()
+}
+
+func newCounterVec() *counterVec {
+	return &counterVec{
+		values: make(map[string]int64),
+	}
+}
+
+func (p *counterVec) add(labels string, value int64) {
+	p.values[labels] = p.values[labels] + value
+}
+
+func (p *counterVec) write(b *bytes.Buffer, name string, help string) {
+	writeHelpAndType(b, name, "counter", help)
+	for _, labels := range sortedKeys(p.values) {
+		b.WriteString(fmt.Sprintf("%s%s %d\n", name, labels, p.values[labels]))
+	}
+}
+
+func newHistogramVec(bounds []float64) *histogramVec {
+	return &histogramVec{
+		bounds: bounds,
+		values: make(map[string]*histogramData),
+	}
+}
+
+func (p *histogramVec) observe(labels string, value float64) {
+	h, found := p.values[labels]
+	if !found {
+		h = &histogramData{
+			labels:  labels,
+			buckets: make([]int64, len(p.bounds)),
+			count:   0,
+			sum:     0,
+		}
+		p.values[labels] = h
+	}
+	for i, bound := range p.bounds {
+		if value <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum = h.sum + value
+}
+
+func (p *histogramVec) write(b *bytes.Buffer, name string, help string) {
+	writeHelpAndType(b, name, "histogram", help)
+	keys := make([]string, 0, len(p.values))
+	for key := range p.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		h := p.values[key]
+		for i, bound := range p.bounds {
+			b.WriteString(fmt.Sprintf("%s_bucket%s %d\n", name, addLabel(h.labels, "le", strconv.FormatFloat(bound, 'g', -1, 64)), h.buckets[i]))
+		}
+		b.WriteString(fmt.Sprintf("%s_bucket%s %d\n", name, addLabel(h.labels, "le", "+Inf"), h.count))
+		b.WriteString(fmt.Sprintf("%s_sum%s %s\n", name, h.labels, strconv.FormatFloat(h.sum, 'g', -1, 64)))
+		b.WriteString(fmt.Sprintf("%s_count%s %d\n", name, h.labels, h.count))
+	}
+}
+
+func writeHelpAndType(b *bytes.Buffer, name string, metricType string, help string) {
+	b.WriteString(fmt.Sprintf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType))
+}
+
+func writeSingleValue(b *bytes.Buffer, name string, metricType string, help string, value string) {
+	writeHelpAndType(b, name, metricType, help)
+	b.WriteString(fmt.Sprintf("%s %s\n", name, value))
+}
+
+/*
+formatLabels - Given name, value pairs return {name1="value1",name2="value2"}
+*/
+func formatLabels(nameValues ...string) string {
+	var b bytes.Buffer
+	b.WriteString("{")
+	for i := 0; i+1 < len(nameValues); i = i + 2 {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(nameValues[i])
+		b.WriteString("=\"")
+		b.WriteString(escapeLabelValue(nameValues[i+1]))
+		b.WriteString("\"")
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+/*
+addLabel - Add a label to an existing formatted label string. Used to add 'le' to histogram buckets.
+*/
+func addLabel(labels string, name string, value string) string {
+	if labels == "{}" || labels == "" {
+		return formatLabels(name, value)
+	}
+	return labels[:len(labels)-1] + "," + name + "=\"" + escapeLabelValue(value) + "\"}"
+}
+
+func escapeLabelValue(value string) string {
+	value = strings.ReplaceAll(value, "\\", "\\\\")
+	value = strings.ReplaceAll(value, "\"", "\\\"")
+	return strings.ReplaceAll(value, "\n", "\\n")
+}
+
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,57 @@
+package servermain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stuartdd/webServerBase/test"
+)
+
+func TestMetricsRequestHistogram(t *testing.T) {
+	metrics := newServerMetrics()
+	metrics.requestStarted()
+	metrics.requestStarted()
+	metrics.requestFinished("GET", "/status", 200, 3*time.Millisecond)
+	metrics.requestFinished("GET", "/status", 200, 300*time.Millisecond)
+	text := metrics.text(2)
+	test.AssertStringContains(t, "", text,
+		"# TYPE webserver_http_requests_total counter\n",
+		"webserver_http_requests_total{route=\"/status\",method=\"GET\",status=\"200\"} 2\n",
+		"# TYPE webserver_http_request_duration_seconds histogram\n",
+		"webserver_http_request_duration_seconds_bucket{route=\"/status\",method=\"GET\",status=\"200\",le=\"0.005\"} 1\n",
+		"webserver_http_request_duration_seconds_bucket{route=\"/status\",method=\"GET\",status=\"200\",le=\"0.25\"} 1\n",
+		"webserver_http_request_duration_seconds_bucket{route=\"/status\",method=\"GET\",status=\"200\",le=\"0.5\"} 2\n",
+		"webserver_http_request_duration_seconds_bucket{route=\"/status\",method=\"GET\",status=\"200\",le=\"+Inf\"} 2\n",
+		"webserver_http_request_duration_seconds_sum{route=\"/status\",method=\"GET\",status=\"200\"} 0.303\n",
+		"webserver_http_request_duration_seconds_count{route=\"/status\",method=\"GET\",status=\"200\"} 2\n",
+		"webserver_http_requests_in_flight 0\n",
+		"webserver_panics_total 2\n")
+}
+
+func TestMetricsStaticTemplatesAndScripts(t *testing.T) {
+	metrics := newServerMetrics()
+	metrics.addStaticBytes(100)
+	metrics.addStaticBytes(23)
+	metrics.observeTemplate("index1.html", time.Millisecond)
+	metrics.observeScript("list", 0, time.Second)
+	metrics.observeScript("list", 1, time.Second)
+	metrics.requestFinished("GET", "", 404, time.Millisecond)
+	metrics.requestFinished("FOO1", "", 404, time.Millisecond)
+	metrics.requestFinished("FOO2", "", 404, time.Millisecond)
+	text := metrics.text(0)
+	test.AssertStringContains(t, "", text,
+		"webserver_static_bytes_served_total 123\n",
+		"webserver_template_render_duration_seconds_count{template=\"index1.html\"} 1\n",
+		"webserver_os_script_executions_total{script=\"list\",result=\"ok\"} 1\n",
+		"webserver_os_script_executions_total{script=\"list\",result=\"failed\"} 1\n",
+		"webserver_os_script_duration_seconds_count{script=\"list\"} 2\n",
+		"webserver_http_requests_total{route=\""+unmappedRouteName+"\",method=\"GET\",status=\"404\"} 1\n",
+		"webserver_http_requests_total{route=\""+unmappedRouteName+"\",method=\""+otherMethodName+"\",status=\"404\"} 2\n")
+	test.AssertStringDoesNotContain(t, "", text, "FOO1")
+}
+
+func TestMetricsLabelEscape(t *testing.T) {
+	test.AssertStringEquals(t, "", formatLabels("a", "x\"y", "b", "1\\2\n"), "{a=\"x\\\"y\",b=\"1\\\\2\\n\"}")
+	test.AssertStringEquals(t, "", addLabel("{}", "le", "1"), "{le=\"1\"}")
+	test.AssertStringEquals(t, "", addLabel("{a=\"b\"}", "le", "1"), "{a=\"b\",le=\"1\"}")
+}
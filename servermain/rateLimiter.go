@@ -0,0 +1,256 @@
+package servermain
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/stuartdd/webServerBase/panicapi"
+)
+
+/*
+rateLimitSweepInterval - How often idle (full) buckets are removed
+*/
+const rateLimitSweepInterval = time.Minute
+
+/*
+rateLimitMaxBuckets - The default maximum number of buckets. When full the least recently used bucket is removed
+*/
+const rateLimitMaxBuckets = 10000
+
+/*
+rateLimitData the limit for a route prefix
+*/
+type rateLimitData struct {
+	prefix            string
+	requestsPerSecond float64
+	burst             float64
+	keyHeader         string
+}
+
+/*
+tokenBucket one bucket per route prefix and client
+*/
+type tokenBucket struct {
+	key     string
+	limit   *rateLimitData
+	tokens  float64
+	updated time.Time
+}
+
+/*
+RateLimiter - Token bucket rate limiter. Limits are defined per route prefix.
+Clients are identified by IP address or by the value of an API key header once the key has been validated (see SetKeyValidator).
+The client IP address is found in the same way as IPFilter (see SetTrustedProxies).
+
+Add RateLimiter.BeforeHandler to the server using AddBeforeHandler so it runs before ALL mapped handlers.
+*/
+type RateLimiter struct {
+	mutex          sync.Mutex
+	limits         []*rateLimitData
+	buckets        map[string]*list.Element
+	recent         *list.List
+	maxBuckets     int
+	keyValidator   func(string) bool
+	trustedProxies []*net.IPNet
+	lastSweep      time.Time
+	now            func() time.Time
+}
+
+/*
+NewRateLimiter create a rate limiter with no limits
+*/
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		limits:         []*rateLimitData{},
+		buckets:        make(map[string]*list.Element),
+		recent:         list.New(),
+		maxBuckets:     rateLimitMaxBuckets,
+		keyValidator:   nil,
+		trustedProxies: []*net.IPNet{},
+		lastSweep:      time.Now(),
+		now:            time.Now,
+	}
+}
+
+/*
+SetKeyValidator set the function that validates the value of the key header. For example Authenticator.IsAPIKey.
+
+The header is not trusted until it is validated. If there is no validator, or the key is not valid, the client is identified by IP address.
+*/
+func (p *RateLimiter) SetKeyValidator(keyValidator func(string) bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.keyValidator = keyValidator
+}
+
+/*
+SetTrustedProxies defines the proxies (CIDR or IP addresses) that are trusted to set X-Forwarded-For.
+Use the same proxies as IPFilter.SetTrustedProxies. Otherwise ALL clients behind a proxy share one bucket.
+*/
+func (p *RateLimiter) SetTrustedProxies(proxies []string) {
+	networks := parseNetworks("SetTrustedProxies", proxies)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.trustedProxies = networks
+}
+
+/*
+SetMaxBuckets set the maximum number of buckets (clients per prefix) held in memory. Default is 10000.
+When full the least recently used bucket is removed.
+*/
+func (p *RateLimiter) SetMaxBuckets(maxBuckets int) {
+	if maxBuckets < 1 {
+		panic("SetMaxBuckets: maxBuckets must be greater than 0")
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.maxBuckets = maxBuckets
+}
+
+/*
+AddRateLimit adds a limit for ALL urls starting with prefix. The longest matching prefix is used.
+
+requestsPerSecond is the rate the bucket refills. burst is the size of the bucket.
+If keyHeader is defined (for example X-API-Key) and the request contains a key accepted by the key validator,
+the client is identified by the key, otherwise by the client IP address.
+*/
+func (p *RateLimiter) AddRateLimit(prefix string, requestsPerSecond float64, burst int, keyHeader string) {
+	if requestsPerSecond <= 0 {
+		panic("AddRateLimit: Prefix [" + prefix + "] requestsPerSecond must be greater than 0")
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.limits = append(p.limits, &rateLimitData{
+		prefix:            prefix,
+		requestsPerSecond: requestsPerSecond,
+		burst:             float64(burst),
+		keyHeader:         keyHeader,
+	})
+}
+
+/*
+BeforeHandler vetoes the request with a 429 (Too Many Requests) and a Retry-After header if the client has exceeded the limit
+*/
+func (p *RateLimiter) BeforeHandler(request *http.Request, response *Response) {
+	limit := p.findLimit(request.URL.Path)
+	if limit == nil {
+		return
+	}
+	clientKey := p.getClientKey(request, limit.keyHeader)
+	allowed, retryAfter := p.take(limit, clientKey)
+	if !allowed {
+		response.AddHeader("Retry-After", []string{strconv.Itoa(retryAfter)})
+		response.SetErrorResponse(http.StatusTooManyRequests, panicapi.SCRateLimited, fmt.Sprintf("Rate limit exceeded for %s. Retry after %d seconds", limit.prefix, retryAfter))
+	}
+}
+
+func (p *RateLimiter) findLimit(url string) *rateLimitData {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var found *rateLimitData
+	for _, limit := range p.limits {
+		if hasPathPrefix(url, limit.prefix) {
+			if found == nil || len(limit.prefix) > len(found.prefix) {
+				found = limit
+			}
+		}
+	}
+	return found
+}
+
+/*
+take a token from the bucket. If there are no tokens return false and the number of seconds until there is one.
+*/
+func (p *RateLimiter) take(limit *rateLimitData, clientKey string) (bool, int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	now := p.now()
+	p.sweep(now)
+	key := limit.prefix + "|" + clientKey
+	var bucket *tokenBucket
+	element, found := p.buckets[key]
+	if found {
+		p.recent.MoveToFront(element)
+		bucket = element.Value.(*tokenBucket)
+	} else {
+		for len(p.buckets) >= p.maxBuckets {
+			p.evict()
+		}
+		bucket = &tokenBucket{
+			key:     key,
+			limit:   limit,
+			tokens:  limit.burst,
+			updated: now,
+		}
+		p.buckets[key] = p.recent.PushFront(bucket)
+	}
+	bucket.refill(now)
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	return false, int(math.Ceil((1 - bucket.tokens) / limit.requestsPerSecond))
+}
+
+/*
+sweep - Remove buckets that have refilled. They are the same as a new bucket! Must be called with the mutex locked.
+*/
+func (p *RateLimiter) sweep(now time.Time) {
+	if now.Sub(p.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	p.lastSweep = now
+	for key, element := range p.buckets {
+		bucket := element.Value.(*tokenBucket)
+		bucket.refill(now)
+		if bucket.tokens >= bucket.limit.burst {
+			p.recent.Remove(element)
+			delete(p.buckets, key)
+		}
+	}
+}
+
+/*
+evict - Remove the least recently used bucket. Must be called with the mutex locked.
+*/
+func (p *RateLimiter) evict() {
+	oldest := p.recent.Back()
+	if oldest != nil {
+		p.recent.Remove(oldest)
+		delete(p.buckets, oldest.Value.(*tokenBucket).key)
+	}
+}
+
+func (p *tokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(p.updated).Seconds()
+	if elapsed > 0 {
+		p.tokens = math.Min(p.limit.burst, p.tokens+(elapsed*p.limit.requestsPerSecond))
+		p.updated = now
+	}
+}
+
+/*
+getClientKey returns the API key if it is valid otherwise the client IP address. The header value is never trusted on its own.
+*/
+func (p *RateLimiter) getClientKey(request *http.Request, keyHeader string) string {
+	p.mutex.Lock()
+	keyValidator := p.keyValidator
+	trustedProxies := p.trustedProxies
+	p.mutex.Unlock()
+	if keyHeader != "" {
+		key := request.Header.Get(keyHeader)
+		if key != "" && keyValidator != nil && keyValidator(key) {
+			return "key:" + key
+		}
+	}
+	return "ip:" + getClientIP(request, trustedProxies)
+}
@@ -0,0 +1,146 @@
+package servermain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stuartdd/webServerBase/panicapi"
+	"github.com/stuartdd/webServerBase/test"
+)
+
+func TestRateLimitBurstAndRefill(t *testing.T) {
+	now := time.Now()
+	limiter := NewRateLimiter()
+	limiter.now = func() time.Time { return now }
+	limiter.AddRateLimit("/script/", 0.5, 2, "")
+
+	test.AssertIntEqual(t, "1", rateLimitTest(limiter, "/script/list", "1.1.1.1:1", "").GetCode(), 200)
+	test.AssertIntEqual(t, "2", rateLimitTest(limiter, "/script/list", "1.1.1.1:2", "").GetCode(), 200)
+	resp := rateLimitTest(limiter, "/script/list", "1.1.1.1:3", "")
+	test.AssertIntEqual(t, "3", resp.GetCode(), 429)
+	test.AssertIntEqual(t, "", resp.GetSubCode(), panicapi.SCRateLimited)
+	test.AssertStringEquals(t, "Retry-After", resp.GetHeaders()["Retry-After"][0], "2")
+	/*
+		A different client has its own bucket. Un-limited urls are always allowed
+	*/
+	test.AssertIntEqual(t, "Other IP", rateLimitTest(limiter, "/script/list", "2.2.2.2:1", "").GetCode(), 200)
+	test.AssertIntEqual(t, "Other URL", rateLimitTest(limiter, "/status", "1.1.1.1:3", "").GetCode(), 200)
+	/*
+		After 2 seconds 1 token is available
+	*/
+	now = now.Add(2 * time.Second)
+	test.AssertIntEqual(t, "Refill", rateLimitTest(limiter, "/script/list", "1.1.1.1:4", "").GetCode(), 200)
+	test.AssertIntEqual(t, "Empty", rateLimitTest(limiter, "/script/list", "1.1.1.1:4", "").GetCode(), 429)
+}
+
+func TestRateLimitLongestPrefixAndKeyHeader(t *testing.T) {
+	limiter := NewRateLimiter()
+	limiter.AddRateLimit("/", 100, 100, "")
+	limiter.AddRateLimit("/script/", 1, 1, "X-API-Key")
+	limiter.SetKeyValidator(func(key string) bool { return key == "A" || key == "B" })
+
+	test.AssertIntEqual(t, "Key A", rateLimitTest(limiter, "/script/list", "1.1.1.1:1", "A").GetCode(), 200)
+	test.AssertIntEqual(t, "Key A", rateLimitTest(limiter, "/script/list", "2.2.2.2:1", "A").GetCode(), 429)
+	test.AssertIntEqual(t, "Leading slashes", rateLimitTest(limiter, "//script/list", "2.2.2.2:1", "A").GetCode(), 429)
+	test.AssertIntEqual(t, "Key B", rateLimitTest(limiter, "/script/list", "1.1.1.1:1", "B").GetCode(), 200)
+	test.AssertIntEqual(t, "IP", rateLimitTest(limiter, "/script/list", "1.1.1.1:1", "").GetCode(), 200)
+	test.AssertIntEqual(t, "IP", rateLimitTest(limiter, "/script/list", "1.1.1.1:1", "").GetCode(), 429)
+	test.AssertIntEqual(t, "Root", rateLimitTest(limiter, "/status", "1.1.1.1:1", "").GetCode(), 200)
+	/*
+		An invalid key does not get a new bucket. The IP is used
+	*/
+	test.AssertIntEqual(t, "Invalid key", rateLimitTest(limiter, "/script/list", "1.1.1.1:1", "C").GetCode(), 429)
+	test.AssertIntEqual(t, "Invalid key", rateLimitTest(limiter, "/script/list", "3.3.3.3:1", "D").GetCode(), 200)
+	test.AssertIntEqual(t, "Invalid key", rateLimitTest(limiter, "/script/list", "3.3.3.3:1", "E").GetCode(), 429)
+}
+
+func TestRateLimitKeyNotValidated(t *testing.T) {
+	limiter := NewRateLimiter()
+	limiter.AddRateLimit("/script/", 1, 1, "X-API-Key")
+	test.AssertIntEqual(t, "Key A", rateLimitTest(limiter, "/script/list", "1.1.1.1:1", "A").GetCode(), 200)
+	test.AssertIntEqual(t, "Key B", rateLimitTest(limiter, "/script/list", "1.1.1.1:1", "B").GetCode(), 429)
+}
+
+func TestRateLimitMaxBuckets(t *testing.T) {
+	now := time.Now()
+	limiter := NewRateLimiter()
+	limiter.now = func() time.Time { return now }
+	limiter.SetMaxBuckets(5)
+	limiter.AddRateLimit("/", 1, 2, "")
+	for i := 0; i < 20; i++ {
+		now = now.Add(time.Millisecond)
+		rateLimitTest(limiter, "/x", "1.1.1."+strconv.Itoa(i)+":1", "")
+		test.AssertBoolTrue(t, "Max buckets", len(limiter.buckets) <= 5)
+	}
+	/*
+		The most recent clients are still limited
+	*/
+	test.AssertIntEqual(t, "Recent", rateLimitTest(limiter, "/x", "1.1.1.19:1", "").GetCode(), 200)
+	test.AssertIntEqual(t, "Recent", rateLimitTest(limiter, "/x", "1.1.1.19:1", "").GetCode(), 429)
+	_, found := limiter.buckets["/|ip:1.1.1.0"]
+	test.AssertBoolFalse(t, "Oldest removed", found)
+	/*
+		A bucket that was used is moved to the front so the least recently used is removed
+	*/
+	rateLimitTest(limiter, "/x", "1.1.1.15:1", "")
+	rateLimitTest(limiter, "/x", "2.2.2.2:1", "")
+	_, found = limiter.buckets["/|ip:1.1.1.15"]
+	test.AssertBoolTrue(t, "Recently used kept", found)
+	_, found = limiter.buckets["/|ip:1.1.1.16"]
+	test.AssertBoolFalse(t, "Least recently used removed", found)
+	test.AssertIntEqual(t, "", limiter.recent.Len(), len(limiter.buckets))
+}
+
+func TestRateLimitTrustedProxy(t *testing.T) {
+	limiter := NewRateLimiter()
+	limiter.AddRateLimit("/", 1, 1, "")
+	limiter.SetTrustedProxies([]string{"10.0.0.0/8"})
+	forwarded := func(remoteAddr string, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodGet, "/x", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		response := NewResponse(nil, nil, "TXID")
+		limiter.BeforeHandler(req, response)
+		return response.GetCode()
+	}
+	/*
+		Clients behind the trusted proxy have their own buckets
+	*/
+	test.AssertIntEqual(t, "Client 1", forwarded("10.1.1.1:1", "1.1.1.1"), 200)
+	test.AssertIntEqual(t, "Client 2", forwarded("10.1.1.1:1", "2.2.2.2, 10.2.2.2"), 200)
+	test.AssertIntEqual(t, "Client 1", forwarded("10.1.1.1:1", "1.1.1.1"), 429)
+	/*
+		X-Forwarded-For is ignored from an untrusted connection
+	*/
+	test.AssertIntEqual(t, "Untrusted", forwarded("3.3.3.3:1", "4.4.4.4"), 200)
+	test.AssertIntEqual(t, "Untrusted", forwarded("3.3.3.3:1", "5.5.5.5"), 429)
+}
+
+func TestRateLimitSweep(t *testing.T) {
+	now := time.Now()
+	limiter := NewRateLimiter()
+	limiter.now = func() time.Time { return now }
+	limiter.AddRateLimit("/", 1, 1, "")
+	for i := 0; i < 10; i++ {
+		rateLimitTest(limiter, "/x", "1.1.1."+strconv.Itoa(i)+":1", "")
+	}
+	test.AssertIntEqual(t, "", len(limiter.buckets), 10)
+	now = now.Add(2 * rateLimitSweepInterval)
+	rateLimitTest(limiter, "/x", "9.9.9.9:1", "")
+	test.AssertIntEqual(t, "", len(limiter.buckets), 1)
+	test.AssertIntEqual(t, "", limiter.recent.Len(), 1)
+}
+
+func rateLimitTest(limiter *RateLimiter, url string, remoteAddr string, apiKey string) *Response {
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	req.RemoteAddr = remoteAddr
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	response := NewResponse(nil, nil, "TXID")
+	limiter.BeforeHandler(req, response)
+	return response
+}
@@ -1,13 +1,20 @@
 package servermain
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/stuartdd/webServerBase/panicapi"
 )
@@ -16,6 +23,9 @@ import (
 RequestHandlerHelper contains details of url parameters
 
 Dont fetch anything until asked (lazy load)
+
+The server attaches a helper to the request context so before, mapped and after handlers share
+one instance (and one cached request body). See NewRequestHandlerHelper.
 */
 type RequestHandlerHelper struct {
 	request       *http.Request
@@ -24,8 +34,14 @@ type RequestHandlerHelper struct {
 	urlParts      []string
 	urlPartsCount int
 	queries       url.Values
+	body          []byte
+	bodyRead      bool
+	bodyCached    bool
+	form          url.Values
 }
 
+type requestHandlerHelperKey struct{}
+
 type jsonWrapper struct {
 	Name string
 	UUID string
@@ -33,9 +49,18 @@ type jsonWrapper struct {
 }
 
 /*
-NewRequestHandlerHelper create a new url details with a url
+NewRequestHandlerHelper returns the helper attached to the request by the server.
+If the request does not have a helper for the response (E.G. the handler is called directly) a new one is created.
 */
 func NewRequestHandlerHelper(r *http.Request, response *Response) *RequestHandlerHelper {
+	helper, ok := r.Context().Value(requestHandlerHelperKey{}).(*RequestHandlerHelper)
+	if ok && helper.response == response {
+		return helper
+	}
+	return newRequestHandlerHelper(r, response)
+}
+
+func newRequestHandlerHelper(r *http.Request, response *Response) *RequestHandlerHelper {
 	return &RequestHandlerHelper{
 		request:       r,
 		response:      response,
@@ -43,6 +68,29 @@ func NewRequestHandlerHelper(r *http.Request, response *Response) *RequestHandle
 		urlParts:      nil,
 		urlPartsCount: 0,
 		queries:       nil,
+		body:          nil,
+		bodyRead:      false,
+		bodyCached:    false,
+		form:          nil,
+	}
+}
+
+/*
+attachRequestHandlerHelper returns a copy of the request with a new helper in it's context
+*/
+func attachRequestHandlerHelper(r *http.Request, response *Response) (*http.Request, *RequestHandlerHelper) {
+	helper := newRequestHandlerHelper(nil, response)
+	r = r.WithContext(context.WithValue(r.Context(), requestHandlerHelperKey{}, helper))
+	helper.request = r
+	return helper.request, helper
+}
+
+/*
+cleanUp removes any temporary files created when a multipart form was parsed
+*/
+func (p *RequestHandlerHelper) cleanUp() {
+	if p.request.MultipartForm != nil {
+		p.request.MultipartForm.RemoveAll()
 	}
 }
 
@@ -76,6 +124,20 @@ func (p *RequestHandlerHelper) GetResponseWriter() *ResponseWriterWrapper {
 	return p.response.GetWrappedWriter()
 }
 
+/*
+GetContext returns the request context. This is cancelled if the request times out or the client goes away
+*/
+func (p *RequestHandlerHelper) GetContext() context.Context {
+	return p.request.Context()
+}
+
+/*
+GetDeadline returns the time the request will time out. ok is false if there is no timeout for the request
+*/
+func (p *RequestHandlerHelper) GetDeadline() (deadline time.Time, ok bool) {
+	return p.request.Context().Deadline()
+}
+
 /*
 GetStaticFileServerData get the path for a static name
 */
@@ -98,7 +160,7 @@ func (p *RequestHandlerHelper) GetStaticPathForURL(url string) *FileServerContai
 }
 
 /*
-GetJSONBodyAsObject return an object, populated from a known JSON structure. This can only be done ONCE!
+GetJSONBodyAsObject return an object, populated from a known JSON structure. The body is cached (see GetBody)
 Example: (see RequestTools_test.go)
 	testStruct := &TestStruct{}
 	err = d.GetJSONBodyAsObject(testStruct)
@@ -112,7 +174,44 @@ func (p *RequestHandlerHelper) GetJSONBodyAsObject(configObject interface{}) {
 }
 
 /*
-GetJSONBodyAsMap read the body from the request. This can only be done ONCE!
+GetBodyAsObject populate an object from the request body. The decoder is chosen using the Content-Type header:
+	JSON: application/json (or no Content-Type)
+	XML:  application/xml, text/xml
+	YAML: application/yaml, application/x-yaml, text/yaml
+	Form: application/x-www-form-urlencoded, multipart/form-data (see decodeFormValues)
+A 415 (Unsupported Media Type) is returned for any other Content-Type. The body is cached (see GetBody)
+*/
+func (p *RequestHandlerHelper) GetBodyAsObject(object interface{}) {
+	format, mediaType := getBodyFormat(p.request.Header.Get(ContentTypeName))
+	switch format {
+	case bodyFormatJSON:
+		p.GetJSONBodyAsObject(object)
+	case bodyFormatXML:
+		decodeXMLBody(p.GetBody(), object)
+	case bodyFormatYAML:
+		decodeYAMLBody(p.GetBody(), object)
+	case bodyFormatForm:
+		decodeFormValues(p.readForm(), object)
+	default:
+		panicapi.ThrowError(415, panicapi.SCUnsupportedMediaType, "Unsupported Media Type", fmt.Sprintf("GetBodyAsObject: Content-Type [%s] is not supported", mediaType))
+	}
+}
+
+/*
+ValidateWithSchema validate an object (E.G. populated by GetBodyAsObject) against a named JSON schema (see SetSchemaPath).
+Returns ALL of the violations. Empty if the object is valid. Example:
+	violations := h.ValidateWithSchema("order", order)
+	if len(violations) > 0 {
+		response.SetErrorResponseWithDetails(400, panicapi.SCSchemaValidation, "Invalid order", violations)
+		return
+	}
+*/
+func (p *RequestHandlerHelper) ValidateWithSchema(schemaName string, object interface{}) []*SchemaViolation {
+	return p.GetServer().GetSchemas().Validate(schemaName, object)
+}
+
+/*
+GetJSONBodyAsMap read the body from the request. The body is cached (see GetBody)
 Use this method if the expected Json starts with {
 Example: (see RequestTools_test.go)
 	aMap, err := d.GetJSONBodyAsMap()
@@ -128,7 +227,7 @@ func (p *RequestHandlerHelper) GetJSONBodyAsMap() map[string]interface{} {
 }
 
 /*
-GetJSONBodyAsList read the body from the request. This can only be done ONCE!
+GetJSONBodyAsList read the body from the request. The body is cached (see GetBody)
 Use this method if the expected Json starts with [
 	aList, err := d.GetJSONBodyAsList()
 */
@@ -143,24 +242,156 @@ func (p *RequestHandlerHelper) GetJSONBodyAsList() []interface{} {
 }
 
 /*
-GetBodyString read the body from the request. This can only be done ONCE!
+GetBodyString read the body from the request. The body is cached (see GetBody)
 */
 func (p *RequestHandlerHelper) GetBodyString() string {
 	return string(p.GetBody())
 }
 
 /*
-GetBody read the body from the request. This can only be done ONCE!
+GetBody read the body from the request. The body is cached on the first read so it can be read again
+by this and other handlers, unless it is larger than the body cache limit (see SetBodyCacheLimit).
+A 413 (Request Entity Too Large) is returned if the body exceeds the maximum body size.
 */
 func (p *RequestHandlerHelper) GetBody() []byte {
+	if p.bodyCached {
+		return p.body
+	}
+	p.checkBodyNotRead()
+	p.bodyRead = true
 	bodyBytes, err := ioutil.ReadAll(p.request.Body)
 	defer p.request.Body.Close()
 	if err != nil {
+		if err == ErrRequestBodyTooLarge {
+			panicapi.ThrowError(413, panicapi.SCBodyTooLarge, "Request body too large", err.Error())
+		}
 		panicapi.ThrowError(400, panicapi.SCReadJSONRequest, "Error reading request body", err.Error())
 	}
+	if int64(len(bodyBytes)) <= p.getBodyCacheLimit() {
+		p.body = bodyBytes
+		p.bodyCached = true
+		/*
+			Replace the consumed request body so code reading the request directly (E.G. request.ParseForm) still works
+		*/
+		p.request.Body = ioutil.NopCloser(bytes.NewReader(bodyBytes))
+	}
 	return bodyBytes
 }
 
+/*
+GetBodyReader returns the request body as a stream. Use this to copy large bodies (E.G. uploads) without holding them in memory.
+Read returns ErrRequestBodyTooLarge if the body exceeds the maximum body size.
+
+If the body has been cached by GetBody a reader for the cached body is returned.
+Otherwise the body is NOT cached so this can only be done ONCE!
+*/
+func (p *RequestHandlerHelper) GetBodyReader() io.ReadCloser {
+	if p.bodyCached {
+		return ioutil.NopCloser(bytes.NewReader(p.body))
+	}
+	p.checkBodyNotRead()
+	p.bodyRead = true
+	return p.request.Body
+}
+
+func (p *RequestHandlerHelper) checkBodyNotRead() {
+	if p.bodyRead {
+		panicapi.ThrowError(500, panicapi.SCBodyAlreadyRead, "Request body has already been read", "The request body was read by GetBodyReader or was too large to cache")
+	}
+}
+
+func (p *RequestHandlerHelper) getBodyCacheLimit() int64 {
+	server := p.GetServer()
+	if server == nil {
+		return defaultBodyCacheLimit
+	}
+	return server.bodyCacheLimit
+}
+
+/*
+GetFormValue returns the first value for the named form field. Empty if not found.
+Form fields are read from an application/x-www-form-urlencoded or multipart/form-data body
+followed by the URL query parameters.
+*/
+func (p *RequestHandlerHelper) GetFormValue(name string) string {
+	return p.readForm().Get(name)
+}
+
+/*
+GetFormValues returns ALL of the values for the named form field. Empty if not found. See GetFormValue
+*/
+func (p *RequestHandlerHelper) GetFormValues(name string) []string {
+	return p.readForm()[name]
+}
+
+/*
+GetUploadedFiles returns the files uploaded in the named field of a multipart/form-data body.
+Empty if not found or the body is not multipart/form-data.
+
+Files larger than the body cache limit (see SetBodyCacheLimit) are held in temporary files.
+These are removed when the request has been handled.
+*/
+func (p *RequestHandlerHelper) GetUploadedFiles(name string) []*multipart.FileHeader {
+	p.readForm()
+	if p.request.MultipartForm == nil {
+		return []*multipart.FileHeader{}
+	}
+	return p.request.MultipartForm.File[name]
+}
+
+/*
+readForm parses the form ONCE.
+
+A url encoded body is read using GetBody so it is cached for other handlers.
+A multipart body is NOT cached (it may be large) so it cannot be read again using GetBody.
+*/
+func (p *RequestHandlerHelper) readForm() url.Values {
+	if p.form != nil {
+		return p.form
+	}
+	mediaType, _, _ := mime.ParseMediaType(p.request.Header.Get(ContentTypeName))
+	switch mediaType {
+	case "multipart/form-data":
+		if !p.bodyCached {
+			p.checkBodyNotRead()
+			p.bodyRead = true
+		}
+		p.checkFormError(p.request.ParseMultipartForm(p.getBodyCacheLimit()))
+		p.form = p.appendQueries(url.Values(p.request.MultipartForm.Value))
+	case "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(p.GetBody()))
+		p.checkFormError(err)
+		p.form = p.appendQueries(values)
+	default:
+		p.form = p.appendQueries(url.Values{})
+	}
+	return p.form
+}
+
+/*
+appendQueries returns a copy of the form values with the URL query values after them
+*/
+func (p *RequestHandlerHelper) appendQueries(values url.Values) url.Values {
+	form := url.Values{}
+	for name, list := range values {
+		form[name] = append(form[name], list...)
+	}
+	for name, list := range p.readQueries() {
+		form[name] = append(form[name], list...)
+	}
+	return form
+}
+
+func (p *RequestHandlerHelper) checkFormError(err error) {
+	if err == nil {
+		return
+	}
+	if errors.Is(err, ErrRequestBodyTooLarge) {
+		panicapi.ThrowError(413, panicapi.SCBodyTooLarge, "Request body too large", err.Error())
+	}
+	panicapi.ThrowError(400, panicapi.SCInvalidFormRequest, "Invalid form in request body", err.Error())
+}
+
 /*
 GetURL returns the URL (Cached in the in thos tool's instance)
 */
@@ -221,6 +452,28 @@ func (p *RequestHandlerHelper) GetNamedQuery(name string) string {
 	return p.readQueries().Get(name)
 }
 
+/*
+GetPrincipal returns the authenticated principal. nil if the request was not authenticated
+*/
+func (p *RequestHandlerHelper) GetPrincipal() *Principal {
+	return p.response.GetPrincipal()
+}
+
+/*
+GetSession returns the session for the request. A new session is created if the request does not have one.
+A SessionManager must have been added as a 'before' handler.
+*/
+func (p *RequestHandlerHelper) GetSession() *Session {
+	session := p.response.GetSession()
+	if session != nil {
+		return session
+	}
+	if p.response.sessions == nil {
+		panicapi.ThrowError(500, panicapi.SCSessionError, "Sessions are not available", "GetSession: A SessionManager has not been added as a 'before' handler")
+	}
+	return p.response.sessions.createSession(p.response)
+}
+
 /*
 GetTransactionID returns part by name
 */
@@ -240,7 +493,8 @@ func (p *RequestHandlerHelper) GetQueries() map[string]string {
 }
 
 /*
-GetMapOfRequestData - return the URL Query parameters, Named paaremeters and URL Positional parameters
+GetMapOfRequestData - return the URL Query parameters, Named paaremeters and URL Positional parameters.
+If CSRFProtection is in use the token is added as csrfToken
 */
 func (p *RequestHandlerHelper) GetMapOfRequestData() map[string]string {
 	m := p.GetQueries()
@@ -254,6 +508,9 @@ func (p *RequestHandlerHelper) GetMapOfRequestData() map[string]string {
 		m["url["+strconv.Itoa(index)+"]"] = value
 	}
 	m["uuid"] = p.GetTransactionID()
+	if p.response.csrfToken != "" {
+		m["csrfToken"] = p.response.csrfToken
+	}
 	return m
 }
 
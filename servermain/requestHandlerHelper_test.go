@@ -2,12 +2,15 @@ package servermain
 
 import (
 	"bytes"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/stuartdd/webServerBase/logging"
 	"github.com/stuartdd/webServerBase/panicapi"
 	"github.com/stuartdd/webServerBase/test"
 )
@@ -157,3 +160,76 @@ func TestWithUrl(t *testing.T) {
 	test.AssertIntEqual(t, "", d2.GetPartsCount(), 4)
 
 }
+
+func TestWithBodyCached(t *testing.T) {
+	req, err := http.NewRequest("POST", "http://abc:8080/data", strings.NewReader("[\"TEST\",\"VALUE\"]"))
+	if err != nil {
+		test.Fail(t, "", err.Error())
+	}
+	d := NewRequestHandlerHelper(req, NewResponse(nil, nil, "TXID"))
+	test.AssertStringEquals(t, "", d.GetBodyString(), "[\"TEST\",\"VALUE\"]")
+	test.AssertIntEqual(t, "", len(d.GetJSONBodyAsList()), 2)
+	streamed, err := ioutil.ReadAll(d.GetBodyReader())
+	test.AssertErrorIsNil(t, "", err)
+	test.AssertStringEquals(t, "", string(streamed), "[\"TEST\",\"VALUE\"]")
+	direct, err := ioutil.ReadAll(req.Body)
+	test.AssertErrorIsNil(t, "", err)
+	test.AssertStringEquals(t, "Request body replaced", string(direct), "[\"TEST\",\"VALUE\"]")
+}
+
+func TestWithBodyTooLargeToCache(t *testing.T) {
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.SetBodyCacheLimit(3)
+	req, err := http.NewRequest("POST", "http://abc:8080/data", strings.NewReader("TEST"))
+	if err != nil {
+		test.Fail(t, "", err.Error())
+	}
+	d := NewRequestHandlerHelper(req, NewResponse(nil, server, "TXID"))
+	test.AssertStringEquals(t, "", d.GetBodyString(), "TEST")
+	defer test.AssertPanicAndRecover(t, "Request body has already been read")
+	d.GetBody()
+}
+
+func TestHelperSharedByHandlers(t *testing.T) {
+	logging.CreateTestLogger("TestHelper")
+	server := NewServerInstanceData("ServerName", "utf-8")
+	var helpers []*RequestHandlerHelper
+	server.AddBeforeHandler(func(r *http.Request, response *Response) {
+		h := NewRequestHandlerHelper(r, response)
+		helpers = append(helpers, h)
+		if h.GetBodyString() != "BODY" {
+			response.SetErrorResponse(400, panicapi.SCReadJSONRequest, "Before handler did not get the body")
+		}
+	})
+	server.AddAfterHandler(func(r *http.Request, response *Response) {
+		helpers = append(helpers, NewRequestHandlerHelper(r, response))
+	})
+	server.AddMappedHandler("/body", http.MethodPost, func(r *http.Request, response *Response) {
+		h := NewRequestHandlerHelper(r, response)
+		helpers = append(helpers, h)
+		response.SetResponse(200, h.GetBodyString(), "")
+	})
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/body", strings.NewReader("BODY")))
+	test.AssertIntEqual(t, "", rec.Code, 200)
+	test.AssertStringEquals(t, "Body read twice", rec.Body.String(), "BODY")
+	test.AssertIntEqual(t, "", len(helpers), 3)
+	test.AssertBoolTrue(t, "Main handler", helpers[0] == helpers[1])
+	test.AssertBoolTrue(t, "After handler", helpers[0] == helpers[2])
+}
+
+func TestWithURLEncodedForm(t *testing.T) {
+	req, err := http.NewRequest("POST", "http://abc:8080/form?a=3&b=4", strings.NewReader("a=1&a=2&c=x+y"))
+	if err != nil {
+		test.Fail(t, "", err.Error())
+	}
+	req.Header.Set(ContentTypeName, "application/x-www-form-urlencoded")
+	d := NewRequestHandlerHelper(req, NewResponse(nil, nil, "TXID"))
+	test.AssertStringEquals(t, "Body first", d.GetFormValue("a"), "1")
+	test.AssertIntEqual(t, "", len(d.GetFormValues("a")), 3)
+	test.AssertStringEquals(t, "Query", d.GetFormValue("b"), "4")
+	test.AssertStringEquals(t, "", d.GetFormValue("c"), "x y")
+	test.AssertStringEquals(t, "", d.GetFormValue("d"), "")
+	test.AssertIntEqual(t, "Not multipart", len(d.GetUploadedFiles("file")), 0)
+	test.AssertStringEquals(t, "Body cached", d.GetBodyString(), "a=1&a=2&c=x+y")
+}
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/stuartdd/webServerBase/exec"
 	"github.com/stuartdd/webServerBase/logging"
@@ -37,6 +38,47 @@ func StopServerInstance(request *http.Request, response *Response) {
 	}
 }
 
+/*
+LogLevelsHandler - Reports (GET) or changes (PUT) the log levels while the server is running.
+Map both methods to the same path. The mapping MUST require a role. For example:
+
+	server.AddMappedHandler("/admin/log/levels", "GET,PUT", servermain.LogLevelsHandler, adminRoles...)
+
+A 403 (Forbidden) is returned if the mapping does not require a role.
+
+The PUT body is a JSON map of level name to value. For example {"DEBUG":"SYSOUT","ACCESS":"OFF"}.
+A value can be OFF, SYSOUT, SYSERR, DEFAULT (with or without JSON:) or a log file that is already open (see logging.SetLogLevels).
+If any name or value is invalid a 400 is returned and no level is changed.
+Both methods return the state of each level (see logging.LoggerLevelDataString).
+*/
+func LogLevelsHandler(request *http.Request, response *Response) {
+	h := NewRequestHandlerHelper(request, response)
+	mapping, found := h.GetServer().mappingElements.GetPathMappingElement(request.URL.Path, request.Method)
+	if !found || len(mapping.GetRequiredRoles()) == 0 {
+		response.SetErrorResponse(http.StatusForbidden, panicapi.SCForbidden, "Log levels can only be changed via a mapping that requires a role")
+		return
+	}
+	if request.Method == http.MethodPut {
+		levels := make(map[string]string)
+		h.GetJSONBodyAsObject(&levels)
+		err := logging.SetLogLevels(levels)
+		if err != nil {
+			panicapi.ThrowError(400, panicapi.SCLogLevel, "Invalid log level", err.Error())
+		}
+		logger := h.GetServer().GetServerLogger()
+		if logger.IsInfo() {
+			for name, value := range levels {
+				logger.LogInfof("ID: %s. Log level %s set to %s", response.GetTransactionID(), strings.ToUpper(strings.TrimSpace(name)), value)
+			}
+		}
+	}
+	state := make(map[string]string)
+	for _, name := range logging.GetLogLevelNames() {
+		state[name] = logging.LoggerLevelDataString(name)
+	}
+	response.SetResponse(200, state, "application/json")
+}
+
 /*
 DefaultOSScriptHandler - Response handler for basic template processing
 */
@@ -48,18 +90,27 @@ func DefaultOSScriptHandler(request *http.Request, response *Response) {
 		Get the script name from the URL named parameters
 	*/
 	scriptName := h.GetNamedURLPart("script", "")
+	/*
+		Check the principal is allowed to run the script before looking it up so scripts cannot be enumerated
+	*/
+	if !server.IsAuthorized(request, response, server.GetOsScriptRoles(scriptName)) {
+		return
+	}
 	/*
 		Get the script data (command line arguments) for the script name
 	*/
 	data := server.GetOsScriptsData(scriptName)
 	/*
 		Run the script with the request data map to resolve substitutions.
+		The script is killed if the request times out.
 
 		Also package the response in to a JSON message
 	*/
-	osData := exec.RunAndWait(server.GetOsScriptsPath(), data[0], h.GetMapOfRequestData(), data[1:]...)
+	start := time.Now()
+	osData := exec.RunAndWaitWithContext(h.GetContext(), server.GetOsScriptsPath(), data[0], h.GetMapOfRequestData(), data[1:]...)
+	server.metrics.observeScript(scriptName, osData.RetCode, time.Since(start))
 	if osData.RetCode == 0 {
-		if logging.IsDebug() {
+		if logger.IsDebug() {
 			logger.LogDebugf("OS Script %s Executed OK", scriptName)
 		}
 		contentType := LookupContentType("json")
@@ -67,7 +118,7 @@ func DefaultOSScriptHandler(request *http.Request, response *Response) {
 		return
 	}
 
-	if logging.IsError() {
+	if logger.IsError() {
 		errText := ""
 		if osData.Err != nil {
 			errText = osData.Err.Error()
@@ -86,14 +137,22 @@ func DefaultTemplateFileHandler(request *http.Request, response *Response) {
 	name := h.GetNamedURLPart("template", "")
 	if server.HasTemplate(name) {
 		ww := h.GetResponseWriter()
+		for headerName, value := range response.GetHeaders() {
+			ww.Header()[headerName] = value
+		}
 		contentType := LookupContentType(name)
 		if (contentType != "") && (ww.Header()[ContentTypeName] == nil) {
 			ww.Header()[ContentTypeName] = []string{contentType + "; charset=" + server.contentTypeCharset}
 		}
-		server.TemplateWithWriter(ww, name, request, h.GetMapOfRequestData())
+		/*
+			Template data providers can get the session from the request using GetSessionFromRequest
+		*/
+		server.TemplateWithWriter(ww, name, withSession(request, response.GetSession()), h.GetMapOfRequestData())
 		response.Close()
-		if logging.IsAccess() {
-			response.GetWrappedServer().GetServerLogger().LogAccessf("ID: %s <<< STATUS=%d: CODE=%d: RESP-FROM-FILE=%s: TYPE=%s", response.GetTransactionID(), response.GetCode(), response.GetSubCode(), name, contentType)
+		if response.GetWrappedServer().GetServerLogger().IsAccess() {
+			if response.GetWrappedServer().GetAccessLog() == nil {
+				response.GetWrappedServer().GetServerLogger().LogAccessf("ID: %s <<< STATUS=%d: CODE=%d: %s: RESP-FROM-FILE=%s: TYPE=%s", response.GetTransactionID(), response.GetWrappedWriter().GetStatusCode(), response.GetSubCode(), sizeAndLatency(response), name, contentType)
+			}
 			response.GetWrappedServer().LogHeaderMap(response.GetTransactionID(), response.GetHeaders(), "<-<")
 		}
 	} else {
@@ -144,6 +203,7 @@ func DefaultStaticFileHandler(request *http.Request, response *Response) {
 		Panics 404 if file not found. Panics 500 if file cannot be read
 	*/
 	ServeContent(ww, request, filename)
+	server.metrics.addStaticBytes(ww.GetBytesWritten())
 	/*
 		The file is being written to the response writer.
 		Close the response to prevent further writes to the response writer
@@ -153,8 +213,10 @@ func DefaultStaticFileHandler(request *http.Request, response *Response) {
 		Specific ACCESS log entry for data returned from a file. Dont echo the response as this is a stream. Just indicate the file.
 		Dont log the full file name as this reveals the server file system structure and can lead to vulnerabilities.
 	*/
-	if logging.IsAccess() {
-		response.GetWrappedServer().GetServerLogger().LogAccessf("ID: %s <<< STATUS=%d: CODE=%d: RESP-FROM-FILE=%s: TYPE=%s", response.GetTransactionID(), response.GetCode(), response.GetSubCode(), fileShort, contentType)
+	if response.GetWrappedServer().GetServerLogger().IsAccess() {
+		if response.GetWrappedServer().GetAccessLog() == nil {
+			response.GetWrappedServer().GetServerLogger().LogAccessf("ID: %s <<< STATUS=%d: CODE=%d: %s: RESP-FROM-FILE=%s: TYPE=%s", response.GetTransactionID(), response.GetWrappedWriter().GetStatusCode(), response.GetSubCode(), sizeAndLatency(response), fileShort, contentType)
+		}
 		response.GetWrappedServer().LogHeaderMap(response.GetTransactionID(), response.GetHeaders(), "<-<")
 	}
 	return
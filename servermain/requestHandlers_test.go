@@ -0,0 +1,56 @@
+package servermain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stuartdd/webServerBase/logging"
+	"github.com/stuartdd/webServerBase/test"
+)
+
+func TestLogLevelsHandler(t *testing.T) {
+	logging.CreateTestLogger("TestLogLevels")
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.AddBeforeHandler(func(r *http.Request, response *Response) {
+		response.SetPrincipal(&Principal{Name: "admin", Scheme: "Test", Roles: []string{"admin"}})
+	})
+	server.AddMappedHandler("/admin/log/levels", "GET,PUT", LogLevelsHandler, "admin")
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/log/levels", nil))
+	test.AssertIntEqual(t, "", rec.Code, 200)
+	test.AssertStringContains(t, "", rec.Body.String(), "\"DEBUG\":\"DEBUG:Active note[SYSOUT] error[NO]:Out=Console:\"")
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/admin/log/levels", strings.NewReader("{\"debug\":\"OFF\",\"ACCESS\":\"SYSERR\"}")))
+	test.AssertIntEqual(t, "", rec.Code, 200)
+	test.AssertStringContains(t, "", rec.Body.String(), "\"DEBUG\":\"DEBUG:In-Active note[OFF] error[NO]\"", "\"ACCESS\":\"ACCESS:Active note[SYSERR] error[NO]:Out=Console:\"")
+	test.AssertBoolFalse(t, "IsDebug", logging.IsDebug())
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/admin/log/levels", strings.NewReader("{\"INFO\":\"OFF\",\"TRACE\":\"SYSOUT\"}")))
+	test.AssertIntEqual(t, "", rec.Code, 400)
+	test.AssertStringContains(t, "", rec.Body.String(), "Invalid log level")
+	test.AssertBoolTrue(t, "No level changed", logging.IsInfo())
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/admin/log/levels", strings.NewReader("{\"INFO\":\"/tmp/created-by-client.log\"}")))
+	test.AssertIntEqual(t, "New files are not created", rec.Code, 400)
+	test.AssertFileNotExists(t, "", "/tmp/created-by-client.log")
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/log/levels", strings.NewReader("{}")))
+	test.AssertIntEqual(t, "Method not mapped", rec.Code, 404)
+}
+
+func TestLogLevelsHandlerRequiresRole(t *testing.T) {
+	logging.CreateTestLogger("TestLogLevels")
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.AddMappedHandler("/admin/log/levels", "GET,PUT", LogLevelsHandler)
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/admin/log/levels", strings.NewReader("{\"DEBUG\":\"OFF\"}")))
+	test.AssertIntEqual(t, "", rec.Code, 403)
+	test.AssertBoolTrue(t, "Unchanged", logging.IsDebug())
+}
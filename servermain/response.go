@@ -1,8 +1,10 @@
 package servermain
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
 
@@ -26,6 +28,8 @@ type responseState struct {
 	resp         interface{}
 	contentType  string
 	errorMessage string
+	details      interface{}
+	stream       func(w io.Writer) error
 	closed       bool
 	txid         string
 }
@@ -34,10 +38,16 @@ type responseState struct {
 Response is the defininition of a response
 */
 type Response struct {
-	response *responseState
-	context  *responseContext
-	headers  map[string][]string
-	names    map[string]int
+	response       *responseState
+	context        *responseContext
+	headers        map[string][]string
+	names          map[string]int
+	route          string
+	principal      *Principal
+	session        *Session
+	sessions       *SessionManager
+	csrfToken      string
+	requestContext context.Context
 }
 
 /*
@@ -54,6 +64,13 @@ func (p *Response) AddHeader(name string, value []string) {
 	p.GetHeaders()[name] = value
 }
 
+/*
+addCookie adds a Set-Cookie header. Existing cookies are retained
+*/
+func (p *Response) addCookie(cookie *http.Cookie) {
+	p.AddHeader("Set-Cookie", append(p.GetHeaders()["Set-Cookie"], cookie.String()))
+}
+
 /*
 IsNotAnError returns true is the response is NOT a 2xx
 */
@@ -86,6 +103,12 @@ func (p *Response) GetHeaders() map[string][]string {
 GetResp returns the String response
 */
 func (p *Response) GetResp() string {
+	if p.IsStream() {
+		if p.GetWrappedWriter() == nil {
+			return "[STREAM]"
+		}
+		return fmt.Sprintf("[STREAM %d bytes]", p.GetWrappedWriter().GetBytesWritten())
+	}
 	str, ok := p.response.resp.(string)
 	if ok {
 		return str
@@ -135,6 +158,41 @@ func (p *Response) GetSubCode() int {
 	return p.response.subCode
 }
 
+/*
+GetRoute returns the url pattern of the mapping that matched the request. Empty if no mapping matched
+*/
+func (p *Response) GetRoute() string {
+	return p.route
+}
+
+/*
+SetPrincipal set the authenticated principal. This is usually done by an authentication 'before' handler
+*/
+func (p *Response) SetPrincipal(principal *Principal) {
+	p.principal = principal
+}
+
+/*
+GetPrincipal returns the authenticated principal. nil if the request was not authenticated
+*/
+func (p *Response) GetPrincipal() *Principal {
+	return p.principal
+}
+
+/*
+SetSession set the session for the request. This is usually done by the SessionManager 'before' handler
+*/
+func (p *Response) SetSession(session *Session) {
+	p.session = session
+}
+
+/*
+GetSession returns the session. nil if the request does not have a session (see RequestHandlerHelper.GetSession())
+*/
+func (p *Response) GetSession() *Session {
+	return p.session
+}
+
 /*
 GetWrappedServer returns the ServerInstanceData wrapped in the response context
 */
@@ -157,6 +215,12 @@ func (p *Response) GetCSV() string {
 }
 
 func (p *Response) toErrorJSON() string {
+	if p.response.details != nil {
+		details, err := json.Marshal(p.response.details)
+		if err == nil {
+			return fmt.Sprintf("{\"ID\":\"%s\", \"Status\":%d,\"Code\":%d,\"Message\":\"%s\",\"Error\":\"%s\",\"Details\":%s}", p.response.txid, p.response.code, p.response.subCode, p.response.resp, p.response.errorMessage, details)
+		}
+	}
 	return fmt.Sprintf("{\"ID\":\"%s\", \"Status\":%d,\"Code\":%d,\"Message\":\"%s\",\"Error\":\"%s\"}", p.response.txid, p.response.code, p.response.subCode, p.response.resp, p.response.errorMessage)
 }
 
@@ -194,11 +258,53 @@ func NewResponse(w *ResponseWriterWrapper, s *ServerInstanceData, txid string) *
 			writer: w,
 			server: s,
 		},
-		headers: make(map[string][]string),
-		names:   make(map[string]int),
+		headers:        make(map[string][]string),
+		names:          make(map[string]int),
+		route:          "",
+		principal:      nil,
+		session:        nil,
+		sessions:       nil,
+		csrfToken:      "",
+		requestContext: nil,
+	}
+}
+
+/*
+copyWithWriter create a copy of the response that writes to a different writer. Used when handlers run with a timeout
+*/
+func (p *Response) copyWithWriter(w *ResponseWriterWrapper) *Response {
+	state := *p.response
+	headers := make(map[string][]string)
+	for name, value := range p.headers {
+		headers[name] = value
+	}
+	return &Response{
+		response: &state,
+		context: &responseContext{
+			writer: w,
+			server: p.context.server,
+		},
+		headers:        headers,
+		names:          p.names,
+		route:          p.route,
+		principal:      p.principal,
+		session:        p.session,
+		sessions:       p.sessions,
+		csrfToken:      p.csrfToken,
+		requestContext: p.requestContext,
 	}
 }
 
+/*
+adopt the state and headers of a copy created by copyWithWriter. The writer is NOT adopted.
+*/
+func (p *Response) adopt(from *Response) {
+	p.response = from.response
+	p.headers = from.headers
+	p.principal = from.principal
+	p.session = from.session
+}
+
 /*
 SetError404 create an error response
 */
@@ -231,8 +337,84 @@ func (p *Response) SetErrorResponse(statusCode int, subCode int, errorMessage st
 	return p
 }
 
+/*
+Stream set a streamed response. The writer function is called to write the body AFTER the 'after' handlers have run,
+so they can still veto the response. Each write is flushed to the client (chunked transfer encoding).
+
+The writer function is NOT subject to the route timeout. If it returns an error the response is already started
+so the error is logged and the connection is closed (the server panics with http.ErrAbortHandler).
+Example:
+
+	response.Stream("text/plain", func(w io.Writer) error {
+		for i := 0; i < 10; i++ {
+			_, err := fmt.Fprintf(w, "Line %d\n", i)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+*/
+func (p *Response) Stream(contentType string, writer func(w io.Writer) error) *Response {
+	p.SetResponse(200, nil, contentType)
+	p.response.stream = writer
+	return p
+}
+
+/*
+IsStream returns true if the response was set by Stream
+*/
+func (p *Response) IsStream() bool {
+	return p.response.stream != nil
+}
+
+/*
+WriteStream calls the writer function given to Stream. Each write is flushed.
+Use this in a custom response handler (see SetResponseHandler) after the headers are written.
+*/
+func (p *Response) WriteStream() error {
+	if p.response.stream == nil {
+		return nil
+	}
+	return p.response.stream(&flushingWriter{writer: p.GetWrappedWriter()})
+}
+
+/*
+flushingWriter flushes after every write so the client gets each chunk as it is written
+*/
+type flushingWriter struct {
+	writer *ResponseWriterWrapper
+}
+
+func (p *flushingWriter) Write(b []byte) (int, error) {
+	n, err := p.writer.Write(b)
+	if err == nil {
+		p.writer.Flush()
+	}
+	return n, err
+}
+
+/*
+SetErrorResponseWithDetails create an error response with details. The details are added to the error JSON as "Details".
+For example the violations when a request body fails schema validation.
+*/
+func (p *Response) SetErrorResponseWithDetails(statusCode int, subCode int, errorMessage string, details interface{}) *Response {
+	p.SetErrorResponse(statusCode, subCode, errorMessage)
+	p.response.details = details
+	return p
+}
+
+/*
+GetErrorDetails returns the details of an error response. nil if there are none. See SetErrorResponseWithDetails
+*/
+func (p *Response) GetErrorDetails() interface{} {
+	return p.response.details
+}
+
 /*
 SetResponse set the content type. E.G. application/json
+If resp is not a string (E.G. a struct or map) it is encoded using the request Accept header.
+contentType is used if the client accepts any type. See ServerInstanceData.AddResponseEncoder
 */
 func (p *Response) SetResponse(code int, resp interface{}, contentType string) *Response {
 	p.response = &responseState{
@@ -1,6 +1,18 @@
 package servermain
 
-import "net/http"
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+ErrHijackNotSupported is returned by Hijack if the http.ResponseWriter cannot be hijacked (for example HTTP/2)
+*/
+var ErrHijackNotSupported = errors.New("The response writer does not support Hijack")
 
 /*
 ResponseWriterWrapper replaces http.ResponseWriter
@@ -8,10 +20,29 @@ ResponseWriterWrapper replaces http.ResponseWriter
 Methods are inherited! from http.ResponseWriter.
 This allows us to pass ResponseWriterWrapper as a http.ResponseWriter to
 any methods expecting an object with the http.ResponseWriter interface
+
+The optional http.Flusher, http.Hijacker and http.Pusher interfaces are passed through to the http.ResponseWriter.
+The status code, bytes written, time to first byte and duration are recorded.
 */
 type ResponseWriterWrapper struct {
 	responseWriter http.ResponseWriter
 	statusCode     int
+	bytesWritten   int64
+	startTime      time.Time
+	firstByteTime  time.Time
+	guard          *writeGuard
+}
+
+/*
+writeGuard is used when handlers run with a timeout. The handler writes through a guarded wrapper.
+When the timeout expires the guard is closed and any further writes by the handler are discarded.
+Headers are held in the guard until the status is written so the handler and the server never share the header map.
+*/
+type writeGuard struct {
+	mutex       sync.Mutex
+	timedOut    bool
+	wroteHeader bool
+	header      http.Header
 }
 
 /*
@@ -21,6 +52,38 @@ func (p *ResponseWriterWrapper) GetStatusCode() int {
 	return p.statusCode
 }
 
+/*
+GetBytesWritten return the number of bytes written to the response body.
+*/
+func (p *ResponseWriterWrapper) GetBytesWritten() int64 {
+	return p.bytesWritten
+}
+
+/*
+GetStartTime return the time the wrapper was created (the start of the request).
+*/
+func (p *ResponseWriterWrapper) GetStartTime() time.Time {
+	return p.startTime
+}
+
+/*
+GetTimeToFirstByte return the time from the start of the request until the status (or first byte) was written.
+Zero if nothing has been written.
+*/
+func (p *ResponseWriterWrapper) GetTimeToFirstByte() time.Duration {
+	if p.firstByteTime.IsZero() {
+		return 0
+	}
+	return p.firstByteTime.Sub(p.startTime)
+}
+
+/*
+GetDuration return the time since the start of the request.
+*/
+func (p *ResponseWriterWrapper) GetDuration() time.Duration {
+	return time.Since(p.startTime)
+}
+
 /*
 NewResponseWriterWrapper Create a new ResponseWriterWrapper so we can write throught it!
 */
@@ -28,7 +91,24 @@ func NewResponseWriterWrapper(w http.ResponseWriter) *ResponseWriterWrapper {
 	return &ResponseWriterWrapper{
 		responseWriter: w,
 		statusCode:     http.StatusOK,
+		bytesWritten:   0,
+		startTime:      time.Now(),
+		firstByteTime:  time.Time{},
+		guard:          nil,
+	}
+}
+
+/*
+newGuardedResponseWriterWrapper Create a ResponseWriterWrapper that discards writes once timeout() is called
+*/
+func newGuardedResponseWriterWrapper(w http.ResponseWriter) *ResponseWriterWrapper {
+	ww := NewResponseWriterWrapper(w)
+	ww.guard = &writeGuard{
+		timedOut:    false,
+		wroteHeader: false,
+		header:      make(http.Header),
 	}
+	return ww
 }
 
 /*
@@ -36,7 +116,17 @@ WriteHeader delegates to http.ResponseWriter.WriteHeader method.
 Additional behaviour is to Store the status Code before passing it on.
 */
 func (p *ResponseWriterWrapper) WriteHeader(code int) {
+	if p.guard != nil {
+		p.guard.mutex.Lock()
+		defer p.guard.mutex.Unlock()
+		if p.guard.timedOut || p.guard.wroteHeader {
+			return
+		}
+		p.flushGuardedHeaders()
+		p.guard.wroteHeader = true
+	}
 	p.statusCode = code
+	p.recordFirstByte()
 	p.responseWriter.WriteHeader(code)
 }
 
@@ -44,12 +134,133 @@ func (p *ResponseWriterWrapper) WriteHeader(code int) {
 Header delegates to http.ResponseWriter.Header method.
 */
 func (p *ResponseWriterWrapper) Header() http.Header {
+	if p.guard != nil {
+		return p.guard.header
+	}
 	return p.responseWriter.Header()
 }
 
 /*
 Write delegates to http.ResponseWriter.Write method.
+Additional behaviour is to count the bytes written.
 */
 func (p *ResponseWriterWrapper) Write(b []byte) (n int, err error) {
-	return p.responseWriter.Write(b)
+	if p.guard != nil {
+		p.guard.mutex.Lock()
+		defer p.guard.mutex.Unlock()
+		if p.guard.timedOut {
+			return 0, http.ErrHandlerTimeout
+		}
+		if !p.guard.wroteHeader {
+			p.flushGuardedHeaders()
+			p.guard.wroteHeader = true
+		}
+	}
+	p.recordFirstByte()
+	n, err = p.responseWriter.Write(b)
+	p.bytesWritten = p.bytesWritten + int64(n)
+	return n, err
+}
+
+/*
+Flush delegates to http.Flusher if the http.ResponseWriter supports it. Sends any buffered data to the client.
+*/
+func (p *ResponseWriterWrapper) Flush() {
+	if p.guard != nil {
+		p.guard.mutex.Lock()
+		defer p.guard.mutex.Unlock()
+		if p.guard.timedOut {
+			return
+		}
+	}
+	flusher, ok := p.responseWriter.(http.Flusher)
+	if ok {
+		flusher.Flush()
+	}
+}
+
+/*
+Hijack delegates to http.Hijacker if the http.ResponseWriter supports it. The caller takes over the connection.
+The status code is recorded as 101 (Switching Protocols). Nothing more is written through this wrapper.
+*/
+func (p *ResponseWriterWrapper) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if p.guard != nil {
+		p.guard.mutex.Lock()
+		defer p.guard.mutex.Unlock()
+		if p.guard.timedOut {
+			return nil, nil, http.ErrHandlerTimeout
+		}
+		p.guard.wroteHeader = true
+	}
+	hijacker, ok := p.responseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, ErrHijackNotSupported
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		p.statusCode = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+/*
+Push delegates to http.Pusher if the http.ResponseWriter supports it (HTTP/2 server push).
+Returns http.ErrNotSupported otherwise.
+*/
+func (p *ResponseWriterWrapper) Push(target string, opts *http.PushOptions) error {
+	if p.guard != nil {
+		p.guard.mutex.Lock()
+		defer p.guard.mutex.Unlock()
+		if p.guard.timedOut {
+			return http.ErrHandlerTimeout
+		}
+	}
+	pusher, ok := p.responseWriter.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return pusher.Push(target, opts)
+}
+
+func (p *ResponseWriterWrapper) recordFirstByte() {
+	if p.firstByteTime.IsZero() {
+		p.firstByteTime = time.Now()
+	}
+}
+
+/*
+timeout closes the guard. Returns true if nothing has been written so an error response can still be sent.
+*/
+func (p *ResponseWriterWrapper) timeout() bool {
+	if p.guard == nil {
+		return false
+	}
+	p.guard.mutex.Lock()
+	defer p.guard.mutex.Unlock()
+	p.guard.timedOut = true
+	return !p.guard.wroteHeader
+}
+
+/*
+release is called when a guarded handler completes in time. Any headers not yet written are passed on.
+*/
+func (p *ResponseWriterWrapper) release() {
+	if p.guard == nil {
+		return
+	}
+	p.guard.mutex.Lock()
+	defer p.guard.mutex.Unlock()
+	if !p.guard.wroteHeader {
+		p.flushGuardedHeaders()
+	}
+}
+
+/*
+flushGuardedHeaders must be called with the guard mutex locked!
+*/
+func (p *ResponseWriterWrapper) flushGuardedHeaders() {
+	header := p.responseWriter.Header()
+	for name, value := range p.guard.header {
+		header[name] = value
+	}
 }
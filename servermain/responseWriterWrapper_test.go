@@ -0,0 +1,56 @@
+package servermain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stuartdd/webServerBase/test"
+)
+
+func TestWrapperRecordsBytesAndTiming(t *testing.T) {
+	rec := httptest.NewRecorder()
+	ww := NewResponseWriterWrapper(rec)
+	test.AssertInt64Equal(t, "", int64(ww.GetTimeToFirstByte()), 0)
+	time.Sleep(5 * time.Millisecond)
+	ww.WriteHeader(201)
+	ww.Write([]byte("Hello"))
+	ww.Write([]byte(" World"))
+	ttfb := ww.GetTimeToFirstByte()
+	test.AssertIntEqual(t, "", ww.GetStatusCode(), 201)
+	test.AssertInt64Equal(t, "", ww.GetBytesWritten(), 11)
+	test.AssertBoolTrue(t, "TTFB after sleep", ttfb >= 5*time.Millisecond)
+	test.AssertBoolTrue(t, "Duration includes TTFB", ww.GetDuration() >= ttfb)
+	test.AssertStringEquals(t, "", rec.Body.String(), "Hello World")
+}
+
+func TestWrapperGuardedRecordsOuterBytes(t *testing.T) {
+	rec := httptest.NewRecorder()
+	ww := NewResponseWriterWrapper(rec)
+	guarded := newGuardedResponseWriterWrapper(ww)
+	guarded.Write([]byte("abc"))
+	test.AssertInt64Equal(t, "", ww.GetBytesWritten(), 3)
+	test.AssertBoolTrue(t, "Outer TTFB recorded", ww.GetTimeToFirstByte() > 0)
+	guarded.timeout()
+	_, err := guarded.Write([]byte("def"))
+	test.AssertBoolTrue(t, "", err == http.ErrHandlerTimeout)
+	test.AssertBoolTrue(t, "", guarded.Push("/x", nil) == http.ErrHandlerTimeout)
+	test.AssertInt64Equal(t, "", ww.GetBytesWritten(), 3)
+}
+
+func TestWrapperOptionalInterfaces(t *testing.T) {
+	rec := httptest.NewRecorder()
+	var w http.ResponseWriter = NewResponseWriterWrapper(rec)
+	flusher, ok := w.(http.Flusher)
+	test.AssertBoolTrue(t, "Flusher", ok)
+	flusher.Flush()
+	test.AssertBoolTrue(t, "Flushed", rec.Flushed)
+	hijacker, ok := w.(http.Hijacker)
+	test.AssertBoolTrue(t, "Hijacker", ok)
+	_, _, err := hijacker.Hijack()
+	test.AssertBoolTrue(t, "", err == ErrHijackNotSupported)
+	pusher, ok := w.(http.Pusher)
+	test.AssertBoolTrue(t, "Pusher", ok)
+	test.AssertBoolTrue(t, "", pusher.Push("/x", nil) == http.ErrNotSupported)
+}
@@ -1,8 +1,16 @@
 package servermain
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"github.com/stuartdd/webServerBase/logging"
+	"github.com/stuartdd/webServerBase/panicapi"
 	"github.com/stuartdd/webServerBase/test"
 )
 
@@ -65,3 +73,85 @@ func TestRespAsStructWithHeader(t *testing.T) {
 
 	test.AssertStringEquals(t, "", "{\"A\":\"A\",\"B\":true,\"C\":72.8,\"D\":99}", resp.GetResp())
 }
+
+func TestStreamResponse(t *testing.T) {
+	logging.CreateTestLogger("TestStream")
+	server := NewServerInstanceData("ServerName", "utf-8")
+	chunks := 0
+	server.AddMappedHandler("/stream", http.MethodGet, func(r *http.Request, response *Response) {
+		response.Stream("text/plain", func(w io.Writer) error {
+			for i := 0; i < 3; i++ {
+				fmt.Fprintf(w, "100%% line %d\n", i)
+				chunks++
+			}
+			return nil
+		})
+	})
+	server.AddMappedHandler("/vetoed", http.MethodGet, func(r *http.Request, response *Response) {
+		response.Stream("text/plain", func(w io.Writer) error {
+			chunks++
+			return errors.New("Should not be called")
+		})
+	})
+	server.AddAfterHandler(func(r *http.Request, response *Response) {
+		test.AssertBoolTrue(t, "Not started", response.IsStream() && chunks == 0)
+		if r.URL.Path == "/vetoed" {
+			response.SetErrorResponse(403, panicapi.SCForbidden, "Vetoed")
+			return
+		}
+		response.AddHeader("X-After", []string{"yes"})
+	})
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream", nil))
+	test.AssertIntEqual(t, "", rec.Code, 200)
+	test.AssertStringEquals(t, "Percent not a verb", rec.Body.String(), "100% line 0\n100% line 1\n100% line 2\n")
+	test.AssertBoolTrue(t, "Flushed", rec.Flushed)
+	test.AssertStringEquals(t, "After handler header", rec.Header().Get("X-After"), "yes")
+	test.AssertStringContains(t, "", rec.Header().Get(ContentTypeName), "text/plain")
+
+	chunks = 0
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/vetoed", nil))
+	test.AssertIntEqual(t, "Vetoed", rec.Code, 403)
+	test.AssertIntEqual(t, "Stream not called", chunks, 0)
+}
+
+func TestStreamErrorAbortsConnection(t *testing.T) {
+	logging.CreateTestLogger("TestStream")
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.AddMappedHandler("/stream", http.MethodGet, func(r *http.Request, response *Response) {
+		response.Stream("text/plain", func(w io.Writer) error {
+			fmt.Fprintf(w, "Partial")
+			return errors.New("Source failed")
+		})
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	resp, err := http.Get(httpServer.URL + "/stream")
+	if err == nil {
+		_, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	test.AssertBoolTrue(t, "Incomplete response", err != nil)
+	/*
+		The abort is passed to the http server (not written as an error response)
+	*/
+	defer func() {
+		test.AssertBoolTrue(t, "Abort", recover() == http.ErrAbortHandler)
+	}()
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/stream", nil))
+}
+
+func TestStreamBytesWritten(t *testing.T) {
+	rec := httptest.NewRecorder()
+	resp := NewResponse(NewResponseWriterWrapper(rec), nil, "TXID")
+	resp.Stream("text/plain", func(w io.Writer) error {
+		_, err := io.WriteString(w, "12345")
+		return err
+	})
+	test.AssertStringEquals(t, "", resp.GetResp(), "[STREAM 0 bytes]")
+	test.AssertErrorIsNil(t, "", resp.WriteStream())
+	test.AssertStringEquals(t, "", resp.GetResp(), "[STREAM 5 bytes]")
+	test.AssertIntEqual(t, "", int(resp.GetWrappedWriter().GetBytesWritten()), 5)
+}
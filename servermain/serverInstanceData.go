@@ -8,7 +8,10 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"runtime/debug"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/stuartdd/webServerBase/logging"
@@ -25,6 +28,11 @@ ContentLengthName - so we always get it right!
 */
 const ContentLengthName = "Content-Length"
 
+/*
+defaultBodyCacheLimit - Request bodies up to this size are cached by RequestHandlerHelper.GetBody
+*/
+const defaultBodyCacheLimit = 1024 * 1024
+
 type vetoHandlerListData struct {
 	handlerFunc func(*http.Request, *Response)
 	next        *vetoHandlerListData
@@ -43,14 +51,36 @@ type ServerInstanceData struct {
 	contentTypeCharset string
 	server             *http.Server
 	serverState        *StatusData
+	stateMutex         sync.Mutex
+	statistics         *serverStatistics
+	metrics            *serverMetrics
+	healthChecks       []*healthCheckData
+	healthCheckTimeout time.Duration
 	logger             *logging.LoggerDataReference
 	panicStatusCode    int
+	timeoutStatusCode  int
+	requestTimeout     time.Duration
+	routeTimeouts      map[string]time.Duration
+	maxBodySize        int64
+	routeMaxBodySizes  map[string]int64
+	bodyCacheLimit     int64
+	schemas            *JSONSchemas
+	routeSchemas       map[string]string
+	encoders           *responseEncoders
+	eventHeartbeat     time.Duration
+	webSocketOrigins   []string
+	stopping           chan struct{}
+	stoppingOnce       sync.Once
 	fileServerData     *StaticFileServerData
 	templates          *Templates
+	templatePath       string
 	serverReturnCode   int
 	serverClosedReason string
 	osScriptsPath      string
 	osScripts          map[string][]string
+	osScriptRoles      map[string][]string
+	ipFilter           *IPFilter
+	accessLog          *AccessLog
 }
 
 /*
@@ -63,6 +93,7 @@ type StatusData struct {
 	State      string
 	Panics     int
 	Uptime     int64
+	Statistics *StatisticsData
 }
 
 /*
@@ -96,9 +127,26 @@ func NewServerInstanceData(baseHandlerNameIn string, contentTypeCharsetIn string
 			State:      "RUNNING",
 			Panics:     0,
 			Uptime:     0,
+			Statistics: nil,
 		},
+		statistics:         newServerStatistics(),
+		metrics:            newServerMetrics(),
+		healthChecks:       []*healthCheckData{},
+		healthCheckTimeout: DefaultHealthCheckTimeout,
 		logger:             logging.NewLogger(baseHandlerNameIn),
 		panicStatusCode:    500,
+		timeoutStatusCode:  504,
+		requestTimeout:     0,
+		routeTimeouts:      make(map[string]time.Duration),
+		maxBodySize:        0,
+		routeMaxBodySizes:  make(map[string]int64),
+		bodyCacheLimit:     defaultBodyCacheLimit,
+		schemas:            NewJSONSchemas(),
+		routeSchemas:       make(map[string]string),
+		encoders:           newResponseEncoders(),
+		eventHeartbeat:     DefaultEventStreamHeartbeat,
+		webSocketOrigins:   []string{},
+		stopping:           make(chan struct{}),
 		fileServerData:     nil,
 		templates:          nil,
 		serverReturnCode:   1,
@@ -114,6 +162,9 @@ func (p *ServerInstanceData) ListenAndServeOnPort(port int) {
 	p.server.Handler = p
 	p.logger.LogDebugf("Server Instance created for port : %d", port)
 	err := p.server.ListenAndServe()
+	if p.accessLog != nil {
+		p.accessLog.Close()
+	}
 	if p.GetServerClosedReason() != "" {
 		p.logger.LogInfof("Server Halted: %s", p.GetServerClosedReason())
 		if err != nil {
@@ -157,7 +208,7 @@ func (p *ServerInstanceData) ServeHTTP(rw http.ResponseWriter, httpRequest *http
 		} else {
 			redirect = redirect + "?redirect=true"
 		}
-		if logging.IsInfo() {
+		if p.logger.IsInfo() {
 			p.logger.LogInfof(">>> REDIRECT: %s --> %s", url, redirect)
 		}
 		http.Redirect(w, httpRequest, redirect, http.StatusSeeOther)
@@ -177,17 +228,36 @@ func (p *ServerInstanceData) ServeHTTP(rw http.ResponseWriter, httpRequest *http
 		Create the response object so we can pass it to the handlers
 	*/
 	actualResponse := NewResponse(w, p, txid)
+	actualResponse.requestContext = httpRequest.Context()
 	/*
 		Log the request.
 		Define ACCESS logging to see the request in the logs
 		Define DEBUG and ACCESS to see the request and headers in the logs
 	*/
 	p.logRequest(httpRequest, txid)
+	/*
+		Record the request statistics and metrics. Defered BEFORE the panic recovery so it runs AFTER the panic is recovered.
+	*/
+	p.metrics.requestStarted()
+	defer p.recordStatistics(httpRequest, actualResponse, time.Now())
+	/*
+		Write the access log record once the response is complete. Defered BEFORE the panic recovery so the error response is included.
+	*/
+	defer p.logAccess(httpRequest, actualResponse)
 	/*
 		If a panic is thrown by ANY handler this defered method will clean up and LOG the event correctly.
 	*/
 
 	defer checkForPanicAndRecover(httpRequest, actualResponse, txid)
+	/*
+		Check the client IP address is allowed for the url
+	*/
+	if p.ipFilter != nil {
+		allowed, clientIP := p.ipFilter.IsAllowed(httpRequest)
+		if !allowed {
+			panicapi.ThrowWarning(403, panicapi.SCIPDenied, "Forbidden", fmt.Sprintf("IP:%s is denied for METHOD:%s URL:%s", clientIP, httpRequest.Method, url))
+		}
+	}
 	/*
 		Find the mapping for the url (ReST style)
 	*/
@@ -203,33 +273,26 @@ func (p *ServerInstanceData) ServeHTTP(rw http.ResponseWriter, httpRequest *http
 		Add any url parameter names and indexes to the response so we can get ? values
 	*/
 	actualResponse.names = mapping.names
+	actualResponse.route = mapping.GetURLPattern()
 	/*
-		We found a matching function for the request so lets check each before handler to see if we can procceed.
-		If a before handler changes the response to an error then we abandon the request and return it's response.
+		Limit the size of the request body. Reject now if the Content-Length is too large.
 	*/
-	p.invokeAllVetoHandlersInList(httpRequest, actualResponse, &p.before)
-	if actualResponse.IsAnError() {
-		if logging.IsWarn() {
-			p.logger.LogWarnf("ID: %s. Request was Vetoed by 'Before' handler:%s", txid, actualResponse.GetCSV())
+	maxBodySize := p.GetMaxBodySize(actualResponse.route)
+	if maxBodySize > 0 {
+		if httpRequest.ContentLength > maxBodySize {
+			panicapi.ThrowWarning(413, panicapi.SCBodyTooLarge, "Request body too large", fmt.Sprintf("METHOD:%s URL:%s Content-Length:%d exceeds the limit %d", httpRequest.Method, url, httpRequest.ContentLength, maxBodySize))
 		}
+		httpRequest.Body = newMaxBodyReader(httpRequest.Body, maxBodySize)
+	}
+	/*
+		Invoke the before handlers, the mapped handler and the after handlers.
+		If a timeout applies to the route then the handlers are cancelled when it expires.
+	*/
+	timeout := p.GetRequestTimeout(actualResponse.route)
+	if timeout > 0 {
+		p.invokeHandlersWithTimeout(httpRequest, actualResponse, mapping, timeout)
 	} else {
-		/*
-			We found a matching function for the request so lets get the response.
-			Do not return it immediatly as the after handlers may want to veto the response!
-		*/
-		mapping.HandlerFunc(httpRequest, actualResponse)
-		/*
-			If the handler changes the response to an error then we return it's response
-			Otherwisw we see if an after handler wants to veto
-		*/
-		if actualResponse.IsNotAnError() {
-			p.invokeAllVetoHandlersInList(httpRequest, actualResponse, &p.after)
-			if actualResponse.IsAnError() {
-				if logging.IsWarn() {
-					p.logger.LogWarnf("ID: %s. Response was Vetoed by 'After' handler:%s", txid, actualResponse.GetCSV())
-				}
-			}
-		}
+		p.invokeHandlers(httpRequest, actualResponse, mapping)
 	}
 	/*
 		If the data is already sent there is nothing to do.
@@ -237,6 +300,12 @@ func (p *ServerInstanceData) ServeHTTP(rw http.ResponseWriter, httpRequest *http
 	if actualResponse.IsClosed() {
 		return
 	}
+	/*
+		If the handler returned an object (not a string) encode it for the request Accept header
+	*/
+	if actualResponse.IsNotAnError() {
+		p.negotiateContent(httpRequest, actualResponse)
+	}
 	/*
 		If the response is not a 2xx status code then this is an error
 	*/
@@ -251,12 +320,32 @@ func (p *ServerInstanceData) ServeHTTP(rw http.ResponseWriter, httpRequest *http
 StopServerLater stop the server after N seconds
 */
 func (p *ServerInstanceData) StopServerLater(waitForSeconds int, reason string) {
-	p.serverState.State = "STOPPING"
+	p.setStopping()
 	p.serverClosedReason = reason
 	p.serverReturnCode = 0
 	go p.stopServerThread(waitForSeconds)
 }
 
+/*
+setStopping set the server state to STOPPING and close the stopping channel so long running
+responses (E.G. server sent events) can end before the server shuts down.
+*/
+func (p *ServerInstanceData) setStopping() {
+	p.stateMutex.Lock()
+	p.serverState.State = "STOPPING"
+	p.stateMutex.Unlock()
+	p.stoppingOnce.Do(func() {
+		close(p.stopping)
+	})
+}
+
+/*
+SetEventStreamHeartbeat set the interval between heartbeats on server sent event streams. Default is 15 seconds
+*/
+func (p *ServerInstanceData) SetEventStreamHeartbeat(interval time.Duration) {
+	p.eventHeartbeat = interval
+}
+
 /*
 SetOsScriptsData - Configure and Validate OS script data
 */
@@ -277,6 +366,9 @@ func (p *ServerInstanceData) SetOsScriptsData(path string, scriptsData map[strin
 		}
 	}
 	p.osScripts = scriptsData
+	p.AddHealthCheck(HealthCheckOsScripts, func() error {
+		return checkPathExists("OS Scripts", p.osScriptsPath)
+	})
 }
 
 /*
@@ -290,6 +382,28 @@ func (p *ServerInstanceData) GetOsScriptsData(scriptName string) []string {
 	return data
 }
 
+/*
+SetIPFilter - Allow or deny requests by client IP address. The filter is applied before the mapping for the url is found.
+*/
+func (p *ServerInstanceData) SetIPFilter(ipFilter *IPFilter) {
+	p.ipFilter = ipFilter
+}
+
+/*
+SetOsScriptRoles - Define the roles required to run each OS script (by script name).
+Scripts not in the map can be run by anyone that can invoke the script handler.
+*/
+func (p *ServerInstanceData) SetOsScriptRoles(scriptRoles map[string][]string) {
+	p.osScriptRoles = scriptRoles
+}
+
+/*
+GetOsScriptRoles - Get the roles required to run a specific script name
+*/
+func (p *ServerInstanceData) GetOsScriptRoles(scriptName string) []string {
+	return p.osScriptRoles[scriptName]
+}
+
 /*
 GetOsScriptsPath - Get the OS Script data path. This is where all the scripts are.
 */
@@ -302,6 +416,7 @@ SetStaticFileServerData handle an error response if one occurs
 */
 func (p *ServerInstanceData) SetStaticFileServerData(fileServerDataMap map[string]string) {
 	p.fileServerData = NewStaticFileServerData(fileServerDataMap)
+	p.AddHealthCheck(HealthCheckStaticPaths, p.fileServerData.checkStaticPaths)
 }
 
 /*
@@ -338,6 +453,10 @@ func (p *ServerInstanceData) SetPathToTemplates(pathToTemplates string) {
 	}
 	if templ.HasAnyTemplates() {
 		p.templates = templ
+		p.templatePath = pathToTemplates
+		p.AddHealthCheck(HealthCheckTemplates, func() error {
+			return checkPathExists("Template", p.templatePath)
+		})
 		return
 	}
 	panic("SetPathToTemplates: [" + pathToTemplates + "] did NOT contain any templates")
@@ -389,6 +508,7 @@ TemplateAsString list template names
 */
 func (p *ServerInstanceData) TemplateAsString(templateName string, r *http.Request, data interface{}) string {
 	if p.HasTemplate(templateName) {
+		defer p.observeTemplate(templateName, time.Now())
 		p.templates.executeDataProvider(templateName, r, data)
 		return p.templates.executeString(templateName, data)
 	}
@@ -401,6 +521,7 @@ TemplateWithWriter write a template to the writer
 */
 func (p *ServerInstanceData) TemplateWithWriter(w io.Writer, templateName string, r *http.Request, data interface{}) {
 	if p.HasTemplate(templateName) {
+		defer p.observeTemplate(templateName, time.Now())
 		p.templates.executeDataProvider(templateName, r, data)
 		p.templates.executeWriter(w, templateName, data)
 		return
@@ -409,6 +530,10 @@ func (p *ServerInstanceData) TemplateWithWriter(w io.Writer, templateName string
 
 }
 
+func (p *ServerInstanceData) observeTemplate(templateName string, start time.Time) {
+	p.metrics.observeTemplate(templateName, time.Since(start))
+}
+
 /*
 SetErrorHandler handle an error response if one occurs
 */
@@ -430,6 +555,101 @@ func (p *ServerInstanceData) SetPanicStatusCode(statusCode int) {
 	p.panicStatusCode = statusCode
 }
 
+/*
+SetTimeoutStatusCode set the status code returned when a request times out. Usually 503 or 504 (the default)
+*/
+func (p *ServerInstanceData) SetTimeoutStatusCode(statusCode int) {
+	p.timeoutStatusCode = statusCode
+}
+
+/*
+SetRequestTimeout set the timeout for ALL requests that do not have a route timeout. 0 is no timeout.
+*/
+func (p *ServerInstanceData) SetRequestTimeout(timeout time.Duration) {
+	p.requestTimeout = timeout
+}
+
+/*
+SetRouteTimeout set the timeout for a mapped route. The route is the same path used in AddMappedHandler. For example /script/?
+A timeout of 0 means the route has no timeout even if there is a global timeout.
+*/
+func (p *ServerInstanceData) SetRouteTimeout(route string, timeout time.Duration) {
+	p.routeTimeouts["/"+strings.Trim(route, "/")] = timeout
+}
+
+/*
+GetRequestTimeout get the timeout for a mapped route. If the route does not have a timeout the global timeout is returned
+*/
+func (p *ServerInstanceData) GetRequestTimeout(route string) time.Duration {
+	timeout, found := p.routeTimeouts[route]
+	if found {
+		return timeout
+	}
+	return p.requestTimeout
+}
+
+/*
+SetMaxBodySize set the maximum request body size in bytes for ALL requests that do not have a route limit. 0 is no limit.
+*/
+func (p *ServerInstanceData) SetMaxBodySize(maxBytes int64) {
+	p.maxBodySize = maxBytes
+}
+
+/*
+SetRouteMaxBodySize set the maximum request body size in bytes for a mapped route. The route is the same path used in AddMappedHandler.
+A limit of 0 means the route has no limit even if there is a global limit.
+*/
+func (p *ServerInstanceData) SetRouteMaxBodySize(route string, maxBytes int64) {
+	p.routeMaxBodySizes["/"+strings.Trim(route, "/")] = maxBytes
+}
+
+/*
+GetMaxBodySize get the maximum request body size for a mapped route. If the route does not have a limit the global limit is returned
+*/
+func (p *ServerInstanceData) GetMaxBodySize(route string) int64 {
+	maxBytes, found := p.routeMaxBodySizes[route]
+	if found {
+		return maxBytes
+	}
+	return p.maxBodySize
+}
+
+/*
+SetBodyCacheLimit set the maximum size of a request body that is cached by RequestHandlerHelper.GetBody. Default is 1MB
+*/
+func (p *ServerInstanceData) SetBodyCacheLimit(maxBytes int64) {
+	p.bodyCacheLimit = maxBytes
+}
+
+/*
+SetSchemaPath load ALL of the JSON schemas (*.json) in the path. See JSONSchemas
+*/
+func (p *ServerInstanceData) SetSchemaPath(path string) {
+	p.schemas = LoadJSONSchemas(path)
+}
+
+/*
+GetSchemas returns the JSON schemas loaded by SetSchemaPath
+*/
+func (p *ServerInstanceData) GetSchemas() *JSONSchemas {
+	return p.schemas
+}
+
+/*
+SetRouteSchema validate the request body for the route and method against the named JSON schema before the handler is invoked.
+The route is the url pattern used when the handler was mapped. For example "/calc/?".
+A 400 (Bad Request) listing ALL of the violations is returned if the body is not valid.
+The body must be JSON or YAML. Any other Content-Type returns a 415 (Unsupported Media Type).
+Panics if the schema does not exist or any $ref cannot be resolved (see JSONSchemas.CheckRefs).
+*/
+func (p *ServerInstanceData) SetRouteSchema(route string, method string, schemaName string) {
+	if !p.schemas.HasSchema(schemaName) {
+		panic("SetRouteSchema: Schema [" + schemaName + "] for route [" + route + "] not found. Available: " + p.schemas.ListSchemaNames(", "))
+	}
+	p.schemas.CheckRefs()
+	p.routeSchemas[routeSchemaKey(route, method)] = schemaName
+}
+
 /*
 GetServerReturnCode handle an error response if one occurs
 */
@@ -452,11 +672,27 @@ func (p *ServerInstanceData) GetServerClosedReason() string {
 }
 
 /*
-GetStatusData server status
+GetStatusData server status. A copy is returned so it can be used (marshaled) while other requests update the statistics
 */
 func (p *ServerInstanceData) GetStatusData() *StatusData {
-	p.serverState.Uptime = time.Now().Unix() - p.serverState.UnixTime
-	return p.serverState
+	p.stateMutex.Lock()
+	defer p.stateMutex.Unlock()
+	return &StatusData{
+		UnixTime:   p.serverState.UnixTime,
+		StartTime:  p.serverState.StartTime,
+		Executable: p.serverState.Executable,
+		State:      p.serverState.State,
+		Panics:     p.statistics.getPanics(),
+		Uptime:     time.Now().Unix() - p.serverState.UnixTime,
+		Statistics: p.statistics.snapshot(),
+	}
+}
+
+/*
+SetErrorHistorySize set the number of error records (the last N errors) retained in the status statistics
+*/
+func (p *ServerInstanceData) SetErrorHistorySize(size int) {
+	p.statistics.setErrorHistorySize(size)
 }
 
 /*
@@ -477,17 +713,20 @@ func (p *ServerInstanceData) AddContentTypeFromMap(mimeTypeMap map[string]string
 }
 
 /*
-AddMappedHandler creates a route to a function given a path
+AddMappedHandler creates a route to a function given a path.
+The method can be a comma separated list. For example "GET,PUT" (see LogLevelsHandler).
+If roles are defined the authenticated principal must have one of them (see IsAuthorized)
 */
-func (p *ServerInstanceData) AddMappedHandler(path string, method string, handlerFunc func(*http.Request, *Response)) {
-	p.mappingElements.AddPathMappingElement(path, method, handlerFunc)
+func (p *ServerInstanceData) AddMappedHandler(path string, method string, handlerFunc func(*http.Request, *Response), roles ...string) {
+	p.mappingElements.AddPathMappingElement(path, method, handlerFunc, roles...)
 }
 
 /*
-AddMappedHandlerWithNames creates a route to a function given a path and a set of names for each ? in the mapping
+AddMappedHandlerWithNames creates a route to a function given a path and a set of names for each ? in the mapping.
+If roles are defined the authenticated principal must have one of them (see IsAuthorized)
 */
-func (p *ServerInstanceData) AddMappedHandlerWithNames(path string, method string, handlerFunc func(*http.Request, *Response), names []string) {
-	p.mappingElements.AddPathMappingElementWithNames(path, method, handlerFunc, names)
+func (p *ServerInstanceData) AddMappedHandlerWithNames(path string, method string, handlerFunc func(*http.Request, *Response), names []string, roles ...string) {
+	p.mappingElements.AddPathMappingElementWithNames(path, method, handlerFunc, names, roles...)
 }
 
 /*
@@ -563,16 +802,29 @@ Define ACCESS logging to see the response in the logs
 Define DEBUG and ACCESS to see the response and headers in the logs
 */
 func (p *ServerInstanceData) LogResponse(response *Response) {
-	if logging.IsAccess() {
+	if p.logger.IsAccess() {
 		errText := response.GetErrorMessage()
 		if errText != "" {
 			errText = ": ERROR=" + errText
 		}
-		p.logger.LogAccessf("ID: %s <<< STATUS=%d: CODE=%d: RESP=%s%s", response.GetTransactionID(), response.GetCode(), response.GetSubCode(), response.GetResp(), errText)
+		if p.accessLog == nil {
+			p.logger.LogAccessf("ID: %s <<< STATUS=%d: CODE=%d: %s: RESP=%s%s", response.GetTransactionID(), response.GetCode(), response.GetSubCode(), sizeAndLatency(response), response.GetResp(), errText)
+		}
 		p.LogHeaderMap(response.GetTransactionID(), response.GetHeaders(), "<-<")
 	}
 }
 
+/*
+sizeAndLatency returns the bytes written, the time to first byte and the time taken so far for the access log
+*/
+func sizeAndLatency(response *Response) string {
+	ww := response.GetWrappedWriter()
+	if ww == nil {
+		return "SIZE=0: TTFB=0s: LATENCY=0s"
+	}
+	return fmt.Sprintf("SIZE=%d: TTFB=%s: LATENCY=%s", ww.GetBytesWritten(), ww.GetTimeToFirstByte(), ww.GetDuration())
+}
+
 /*
 ServeContent wraps the http.ServeContent. It opens the file first.
 If the open fails it returns an error.
@@ -596,7 +848,7 @@ LogHeaderMap logs the header data.
 dir will usually be '<-<' or '>->'. Keep it to 3 chars or the logs will look untidy!
 */
 func (p *ServerInstanceData) LogHeaderMap(txid string, headers map[string][]string, dir string) {
-	if logging.IsDebug() {
+	if p.logger.IsDebug() {
 		for k, v := range headers {
 			p.logger.LogDebugf("ID: %s %s HEADER=%s=%s", txid, dir, k, v)
 		}
@@ -607,21 +859,35 @@ func checkForPanicAndRecover(r *http.Request, response *Response, txid string) {
 	server := response.GetWrappedServer()
 	rec := recover()
 	if rec != nil {
+		/*
+			A panic from a handler go routine (see invokeHandlersWithTimeout) has the stack trace of that go routine
+		*/
+		stack := []byte{}
+		if hp, ok := rec.(*handlerPanic); ok {
+			rec = hp.value
+			stack = hp.stack
+		}
+		/*
+			Let the http server abort the connection. Nothing is logged or written.
+		*/
+		if rec == http.ErrAbortHandler {
+			panic(rec)
+		}
 		panicState := panicapi.GetPanicData(rec, txid)
 		if panicState.IsPanicData {
 			switch panicState.Severity {
 			case "I":
-				if logging.IsInfo() {
+				if server.logger.IsInfo() {
 					server.logger.LogInfof("ID: %s %s", panicState.TxID, panicState.String())
 				}
 				break
 			case "W":
-				if logging.IsWarn() {
+				if server.logger.IsWarn() {
 					server.logger.LogWarnf("ID: %s %s", panicState.TxID, panicState.String())
 				}
 				break
 			default:
-				if logging.IsError() {
+				if server.logger.IsError() {
 					server.logger.LogErrorf("ID: %s %s", panicState.TxID, panicState.Error())
 				}
 				break
@@ -629,9 +895,13 @@ func checkForPanicAndRecover(r *http.Request, response *Response, txid string) {
 			server.errorHandler(r, response.SetErrorResponse(panicState.StatusCode, panicState.SubCode, panicState.ErrorText))
 			return
 		}
-		server.serverState.Panics++
+		server.statistics.recordPanic(r.Method, response.GetRoute())
 		text := fmt.Sprintf("ID: %s REQUEST:%s MESSAGE:%s", panicState.TxID, r.URL.Path, panicState.String())
-		server.logger.LogErrorWithStackTrace(txid, "!!!", text)
+		if len(stack) > 0 {
+			server.logger.LogErrorWithStack(txid, "!!!", text, stack)
+		} else {
+			server.logger.LogErrorWithStackTrace(txid, "!!!", text)
+		}
 		server.errorHandler(r, response.SetErrorResponse(server.panicStatusCode, panicapi.SCRuntimeError, panicState.LogMessage))
 	}
 }
@@ -648,15 +918,30 @@ func defaultErrorResponseHandler(request *http.Request, response *Response) {
 	server := response.GetWrappedServer()
 	response.SetContentType(LookupContentType("json"))
 	server.PreProcessResponse(request, response)
+	io.WriteString(response.GetWrappedWriter(), response.toErrorJSON())
 	server.LogResponse(response)
-	fmt.Fprintf(response.GetWrappedWriter(), response.toErrorJSON())
 }
 
 func defaultResponseHandler(request *http.Request, response *Response) {
 	server := response.GetWrappedServer()
 	server.PreProcessResponse(request, response)
+	if response.IsStream() {
+		err := response.WriteStream()
+		if err != nil {
+			if server.logger.IsError() {
+				server.logger.LogErrorf("ID: %s. Stream failed after %d bytes: %s", response.GetTransactionID(), response.GetWrappedWriter().GetBytesWritten(), err.Error())
+			}
+			/*
+				The response has started so an error cannot be returned. Abort the connection so the client knows the response is incomplete.
+			*/
+			response.Close()
+			panic(http.ErrAbortHandler)
+		}
+		server.LogResponse(response)
+		return
+	}
+	io.WriteString(response.GetWrappedWriter(), response.GetResp())
 	server.LogResponse(response)
-	fmt.Fprintf(response.GetWrappedWriter(), response.GetResp())
 }
 
 func (p *ServerInstanceData) stopServerThread(waitForSeconds int) {
@@ -669,6 +954,135 @@ func (p *ServerInstanceData) stopServerThread(waitForSeconds int) {
 	}
 }
 
+/*
+recordStatistics - Count the request by route, status class and sub code and update the request metrics.
+If the response was written directly (closed) then the status code is taken from the wrapped writer
+*/
+func (p *ServerInstanceData) recordStatistics(r *http.Request, response *Response, start time.Time) {
+	status := response.GetCode()
+	if response.IsClosed() {
+		status = response.GetWrappedWriter().GetStatusCode()
+	}
+	p.statistics.recordRequest(r.Method, response.GetRoute(), status, response.GetSubCode(), response.GetTransactionID(), response.GetErrorMessage())
+	p.metrics.requestFinished(r.Method, response.GetRoute(), status, time.Since(start))
+}
+
+/*
+invokeHandlers - Invoke the before handlers, the mapped handler and the after handlers.
+*/
+func (p *ServerInstanceData) invokeHandlers(httpRequest *http.Request, response *Response, mapping *MappingElements) {
+	/*
+		Attach a RequestHandlerHelper to the request so ALL the handlers share the same instance (and cached body)
+	*/
+	httpRequest, helper := attachRequestHandlerHelper(httpRequest, response)
+	defer helper.cleanUp()
+	/*
+		We found a matching function for the request so lets check each before handler to see if we can procceed.
+		If a before handler changes the response to an error then we abandon the request and return it's response.
+	*/
+	p.invokeAllVetoHandlersInList(httpRequest, response, &p.before)
+	if response.IsAnError() {
+		if p.logger.IsWarn() {
+			p.logger.LogWarnf("ID: %s. Request was Vetoed by 'Before' handler:%s", response.GetTransactionID(), response.GetCSV())
+		}
+	} else if p.IsAuthorized(httpRequest, response, mapping.GetRequiredRoles()) && p.validateRequestBody(httpRequest, response) {
+		/*
+			We found a matching function for the request so lets get the response.
+			Do not return it immediatly as the after handlers may want to veto the response!
+		*/
+		mapping.HandlerFunc(httpRequest, response)
+		/*
+			If the handler changes the response to an error then we return it's response
+			Otherwisw we see if an after handler wants to veto
+		*/
+		if response.IsNotAnError() {
+			p.invokeAllVetoHandlersInList(httpRequest, response, &p.after)
+			if response.IsAnError() {
+				if p.logger.IsWarn() {
+					p.logger.LogWarnf("ID: %s. Response was Vetoed by 'After' handler:%s", response.GetTransactionID(), response.GetCSV())
+				}
+			}
+		}
+	}
+}
+
+/*
+handlerPanic - A panic recovered in a handler go routine and the stack trace where it happened
+*/
+type handlerPanic struct {
+	value interface{}
+	stack []byte
+}
+
+/*
+handlerResult - Sent by the handler go routine when it ends. inTime is true if the handlers completed before the timeout.
+*/
+type handlerResult struct {
+	panicked *handlerPanic
+	inTime   bool
+}
+
+/*
+invokeHandlersWithTimeout - Invoke the handlers in a separate go routine with a request context that times out.
+The handlers write through a guarded writer and a copy of the response so that when the timeout expires
+the handlers can be abandoned and the timeout error returned without interference.
+
+A panic in the handlers is passed back, with the stack trace of the handler go routine, and re-thrown here
+so it is handled by checkForPanicAndRecover.
+*/
+func (p *ServerInstanceData) invokeHandlersWithTimeout(httpRequest *http.Request, response *Response, mapping *MappingElements, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(httpRequest.Context(), timeout)
+	defer cancel()
+	guardedWriter := newGuardedResponseWriterWrapper(response.GetWrappedWriter())
+	handlerResponse := response.copyWithWriter(guardedWriter)
+	done := make(chan *handlerResult, 1)
+	go func() {
+		defer func() {
+			result := &handlerResult{panicked: nil, inTime: ctx.Err() == nil}
+			rec := recover()
+			if rec != nil {
+				result.panicked = &handlerPanic{value: rec, stack: debug.Stack()}
+			}
+			done <- result
+		}()
+		p.invokeHandlers(httpRequest.WithContext(ctx), handlerResponse, mapping)
+	}()
+	completed := func(result *handlerResult) {
+		guardedWriter.release()
+		response.adopt(handlerResponse)
+		if result.panicked != nil {
+			panic(result.panicked)
+		}
+	}
+	select {
+	case result := <-done:
+		completed(result)
+	case <-ctx.Done():
+		/*
+			The select is random if both are ready. If the handlers completed before the timeout then use the response
+		*/
+		select {
+		case result := <-done:
+			if result.inTime {
+				completed(result)
+				return
+			}
+		default:
+		}
+		canSend := guardedWriter.timeout()
+		if ctx.Err() == context.DeadlineExceeded && canSend {
+			panicapi.ThrowWarning(p.timeoutStatusCode, panicapi.SCRequestTimeout, "Request timed out", fmt.Sprintf("METHOD:%s URL:%s did not complete within %s", httpRequest.Method, httpRequest.URL.Path, timeout))
+		}
+		/*
+			Client has gone away or the response was partially sent. Nothing more can be sent.
+		*/
+		if p.logger.IsWarn() {
+			p.logger.LogWarnf("ID: %s. Request abandoned: %s", response.GetTransactionID(), ctx.Err().Error())
+		}
+		response.Close()
+	}
+}
+
 /*
 invokeAllHandlersInList
 Invoke ALL handlers in the list UNTIL a handler returns a response.
@@ -692,8 +1106,10 @@ func (p *ServerInstanceData) invokeAllVetoHandlersInList(httpRequest *http.Reque
 	Define DEBUG and ACCESS to see the request and headers in the logs
 */
 func (p *ServerInstanceData) logRequest(r *http.Request, txid string) {
-	if logging.IsAccess() {
-		p.logger.LogAccessf("ID: %s >>> METHOD=%s: REQUEST=%s", txid, r.Method, r.URL.Path)
+	if p.logger.IsAccess() {
+		if p.accessLog == nil {
+			p.logger.LogAccessf("ID: %s >>> METHOD=%s: REQUEST=%s", txid, r.Method, r.URL.Path)
+		}
 		p.LogHeaderMap(txid, r.Header, ">->")
 	}
 }
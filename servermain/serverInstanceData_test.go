@@ -1,8 +1,17 @@
 package servermain
 
 import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/stuartdd/webServerBase/logging"
+	"github.com/stuartdd/webServerBase/panicapi"
 	"github.com/stuartdd/webServerBase/test"
 )
 
@@ -36,3 +45,78 @@ func TestOsScriptsConfigEmptyMapData(t *testing.T) {
 	defer test.AssertPanicAndRecover(t, "data for script [empty]")
 	server.SetOsScriptsData("/", m)
 }
+
+func TestRequestTimeout(t *testing.T) {
+	logging.CreateTestLogger("TestTimeout")
+	server := NewServerInstanceData("ServerName", "utf-8")
+	hasDeadline := make(chan bool, 2)
+	server.AddMappedHandler("/slow", http.MethodGet, func(r *http.Request, response *Response) {
+		_, ok := NewRequestHandlerHelper(r, response).GetDeadline()
+		hasDeadline <- ok
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+		response.SetResponse(200, "Too Late", "")
+	})
+	server.AddMappedHandler("/fast", http.MethodGet, func(r *http.Request, response *Response) {
+		response.AddHeader("X-Fast", []string{"yes"})
+		response.SetResponse(200, "Fast", "")
+	})
+	server.AddMappedHandler("/panic", http.MethodGet, func(r *http.Request, response *Response) {
+		panic("Handler Panic")
+	})
+	server.SetRouteTimeout("/slow", 50*time.Millisecond)
+	server.SetRequestTimeout(time.Second)
+
+	test.AssertIntEqual(t, "", int(server.GetRequestTimeout("/slow")/time.Millisecond), 50)
+	test.AssertIntEqual(t, "", int(server.GetRequestTimeout("/fast")/time.Millisecond), 1000)
+
+	rec := serveTestRequest(server, "/slow")
+	test.AssertBoolTrue(t, "Handler should have a deadline", <-hasDeadline)
+	test.AssertIntEqual(t, "", rec.Code, 504)
+	test.AssertStringContains(t, "", rec.Body.String(), "\"Code\":"+strconv.Itoa(panicapi.SCRequestTimeout), "Request timed out")
+	test.AssertStringDoesNotContain(t, "", rec.Body.String(), "Too Late")
+
+	rec = serveTestRequest(server, "/fast")
+	test.AssertIntEqual(t, "", rec.Code, 200)
+	test.AssertStringEquals(t, "", rec.Body.String(), "Fast")
+	test.AssertStringEquals(t, "", rec.Header().Get("X-Fast"), "yes")
+
+	rec = serveTestRequest(server, "/panic")
+	test.AssertIntEqual(t, "", rec.Code, 500)
+	test.AssertStringContains(t, "", rec.Body.String(), "\"Code\":"+strconv.Itoa(panicapi.SCRuntimeError), "Handler Panic")
+
+	server.SetTimeoutStatusCode(503)
+	rec = serveTestRequest(server, "/slow")
+	test.AssertIntEqual(t, "", rec.Code, 503)
+}
+
+func TestRequestTimeoutPanicStack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "panic")
+	test.AssertErrorIsNil(t, "", err)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "error.log")
+	test.AssertErrorIsNil(t, "", logging.CreateLogWithFilenameAndAppID("", "TestLogger", -1, map[string]string{"ERROR": logFile}))
+	defer logging.CreateTestLogger("TestTimeout")
+
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.AddMappedHandler("/panic", http.MethodGet, timeoutPanicHandler)
+	server.SetRequestTimeout(time.Second)
+	rec := serveTestRequest(server, "/panic")
+	test.AssertIntEqual(t, "", rec.Code, 500)
+	/*
+		The stack trace is from the handler go routine
+	*/
+	test.AssertFileContains(t, "", logFile, "Handler Panic", "servermain.timeoutPanicHandler")
+}
+
+func timeoutPanicHandler(r *http.Request, response *Response) {
+	panic("Handler Panic")
+}
+
+func serveTestRequest(server *ServerInstanceData, url string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	return rec
+}
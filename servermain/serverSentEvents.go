@@ -0,0 +1,189 @@
+package servermain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+DefaultEventStreamHeartbeat - The interval between heartbeats if SetEventStreamHeartbeat is not called
+*/
+const DefaultEventStreamHeartbeat = 15 * time.Second
+
+/*
+ErrEventStreamClosed is returned when sending to an event stream that has ended
+*/
+var ErrEventStreamClosed = errors.New("Event stream closed")
+
+/*
+EventStream - A server sent event (text/event-stream) connection. See Response.ServerSentEvents.
+
+The stream ends (Done is closed) when the client disconnects, the server is STOPPING or a write fails.
+Heartbeat comments are sent so proxies do not close an idle connection and a disconnect is detected.
+*/
+type EventStream struct {
+	mutex       sync.Mutex
+	writer      io.Writer
+	lastEventID string
+	done        chan struct{}
+	closed      bool
+}
+
+/*
+ServerSentEvents set a server sent event response. The handler is called AFTER the 'after' handlers have run (see Stream)
+and the connection is held open until the handler returns. The handler should return when Done is closed. Example:
+
+	response.ServerSentEvents(request, func(events *EventStream) error {
+		for {
+			select {
+			case <-events.Done():
+				return nil
+			case status := <-updates:
+				events.Send("status", "", status)
+			}
+		}
+	})
+*/
+func (p *Response) ServerSentEvents(request *http.Request, handler func(events *EventStream) error) *Response {
+	ctx := p.requestContext
+	if ctx == nil {
+		ctx = request.Context()
+	}
+	lastEventID := request.Header.Get("Last-Event-ID")
+	heartbeat := DefaultEventStreamHeartbeat
+	var stopping chan struct{}
+	server := p.GetWrappedServer()
+	if server != nil {
+		heartbeat = server.eventHeartbeat
+		stopping = server.stopping
+	}
+	p.AddHeader("Cache-Control", []string{"no-cache"})
+	p.AddHeader("X-Accel-Buffering", []string{"no"})
+	return p.Stream("text/event-stream", func(w io.Writer) error {
+		events := &EventStream{
+			writer:      w,
+			lastEventID: lastEventID,
+			done:        make(chan struct{}),
+			closed:      false,
+		}
+		finished := make(chan struct{})
+		defer close(finished)
+		go events.watch(ctx, stopping, heartbeat, finished)
+		err := handler(events)
+		events.close()
+		return err
+	})
+}
+
+/*
+GetLastEventID returns the Last-Event-ID header sent by the client when it reconnects. Empty if not sent.
+Use this to resend the events the client missed.
+*/
+func (p *EventStream) GetLastEventID() string {
+	return p.lastEventID
+}
+
+/*
+Done is closed when the stream has ended
+*/
+func (p *EventStream) Done() <-chan struct{} {
+	return p.done
+}
+
+/*
+Send an event. event and id are optional (empty). Multi line data is sent as multiple data lines.
+Returns ErrEventStreamClosed if the stream has ended.
+*/
+func (p *EventStream) Send(event string, id string, data string) error {
+	var frame bytes.Buffer
+	if id != "" {
+		frame.WriteString("id: " + singleLine(id) + "\n")
+	}
+	if event != "" {
+		frame.WriteString("event: " + singleLine(event) + "\n")
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		frame.WriteString("data: " + line + "\n")
+	}
+	frame.WriteString("\n")
+	return p.write(frame.Bytes())
+}
+
+/*
+SendData send an event with data only
+*/
+func (p *EventStream) SendData(data string) error {
+	return p.Send("", "", data)
+}
+
+/*
+SetRetry tell the client how long to wait before reconnecting
+*/
+func (p *EventStream) SetRetry(retry time.Duration) error {
+	return p.write([]byte("retry: " + strconv.FormatInt(int64(retry/time.Millisecond), 10) + "\n\n"))
+}
+
+func (p *EventStream) write(frame []byte) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return ErrEventStreamClosed
+	}
+	_, err := p.writer.Write(frame)
+	if err != nil {
+		p.closeLocked()
+	}
+	return err
+}
+
+/*
+watch ends the stream when the client disconnects or the server is stopping and sends heartbeats until finished
+*/
+func (p *EventStream) watch(ctx context.Context, stopping chan struct{}, heartbeat time.Duration, finished chan struct{}) {
+	var tick <-chan time.Time
+	if heartbeat > 0 {
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-finished:
+			return
+		case <-ctx.Done():
+			p.close()
+			return
+		case <-stopping:
+			p.close()
+			return
+		case <-tick:
+			if p.write([]byte(": heartbeat\n\n")) != nil {
+				return
+			}
+		}
+	}
+}
+
+func (p *EventStream) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.closeLocked()
+}
+
+func (p *EventStream) closeLocked() {
+	if !p.closed {
+		p.closed = true
+		close(p.done)
+	}
+}
+
+func singleLine(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
@@ -0,0 +1,63 @@
+package servermain
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stuartdd/webServerBase/logging"
+	"github.com/stuartdd/webServerBase/test"
+)
+
+func TestServerSentEvents(t *testing.T) {
+	logging.CreateTestLogger("TestSSE")
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.SetEventStreamHeartbeat(5 * time.Millisecond)
+	var sendAfterDone error
+	server.AddMappedHandler("/events", http.MethodGet, func(r *http.Request, response *Response) {
+		response.ServerSentEvents(r, func(events *EventStream) error {
+			events.SetRetry(2 * time.Second)
+			events.Send("greeting", "1", "hello\nworld")
+			events.SendData("since " + events.GetLastEventID())
+			<-events.Done()
+			sendAfterDone = events.SendData("too late")
+			return nil
+		})
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/events", nil).WithContext(ctx)
+	req.Header.Set("Last-Event-ID", "41")
+	rec := httptest.NewRecorder()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	server.ServeHTTP(rec, req)
+	test.AssertStringContains(t, "", rec.Header().Get(ContentTypeName), "text/event-stream")
+	test.AssertStringEquals(t, "", rec.Header().Get("Cache-Control"), "no-cache")
+	test.AssertStringContains(t, "", rec.Body.String(), "retry: 2000\n\nid: 1\nevent: greeting\ndata: hello\ndata: world\n\ndata: since 41\n\n", ": heartbeat\n\n")
+	test.AssertBoolTrue(t, "Closed on disconnect", sendAfterDone == ErrEventStreamClosed)
+}
+
+func TestServerSentEventsStopping(t *testing.T) {
+	logging.CreateTestLogger("TestSSE")
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.SetEventStreamHeartbeat(0)
+	server.AddMappedHandler("/events", http.MethodGet, func(r *http.Request, response *Response) {
+		response.ServerSentEvents(r, func(events *EventStream) error {
+			events.SendData("started")
+			<-events.Done()
+			return nil
+		})
+	})
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		server.setStopping()
+	}()
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events", nil))
+	test.AssertStringEquals(t, "", rec.Body.String(), "data: started\n\n")
+	test.AssertStringEquals(t, "", server.GetStatusData().State, "STOPPING")
+}
@@ -0,0 +1,420 @@
+package servermain
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stuartdd/webServerBase/logging"
+	"github.com/stuartdd/webServerBase/panicapi"
+)
+
+/*
+DefaultSessionCookieName - The name of the session cookie if SetCookieName is not called
+*/
+const DefaultSessionCookieName = "SESSIONID"
+
+const (
+	defaultSessionIdleTimeout     = 30 * time.Minute
+	defaultSessionAbsoluteTimeout = 8 * time.Hour
+	sessionSweepInterval          = time.Minute
+	/*
+		The accessed time is saved when it is older than idle timeout / sessionAccessedFraction.
+		So a session can expire up to 1/sessionAccessedFraction of the idle timeout early.
+	*/
+	sessionAccessedFraction = 10
+)
+
+type sessionContextKey struct{}
+
+/*
+Session - The session for a request. Values are saved to the store when they are changed.
+*/
+type Session struct {
+	mutex       sync.Mutex
+	data        *SessionData
+	manager     *SessionManager
+	response    *Response
+	invalidated bool
+}
+
+/*
+SessionManager - Cookie based sessions. The cookie contains the session ID signed with a secret (HMAC SHA256).
+The cookie is HttpOnly and SameSite (Lax by default). The session data is held in a SessionStore.
+
+A session expires if it is not used for the idle timeout or when it is older than the absolute timeout.
+The store is not written on every request. The accessed time is only saved when it is older than a tenth of the idle timeout.
+Expired sessions are removed from the store by a background go routine (at most once a minute).
+
+Add SessionManager.BeforeHandler to the server using AddBeforeHandler so it runs before ALL mapped handlers.
+Handlers get the session using RequestHandlerHelper.GetSession(). Template data providers use GetSessionFromRequest.
+*/
+type SessionManager struct {
+	mutex           sync.Mutex
+	store           SessionStore
+	secret          []byte
+	cookieName      string
+	cookiePath      string
+	secure          bool
+	sameSite        http.SameSite
+	idleTimeout     time.Duration
+	absoluteTimeout time.Duration
+	lastSweep       time.Time
+	sweeping        bool
+	sweeps          sync.WaitGroup
+	logger          *logging.LoggerDataReference
+	now             func() time.Time
+}
+
+/*
+NewSessionManager create a session manager. The secret is used to sign the session cookie.
+*/
+func NewSessionManager(store SessionStore, secret []byte) *SessionManager {
+	if store == nil {
+		panic("NewSessionManager: A session store is required")
+	}
+	if len(secret) == 0 {
+		panic("NewSessionManager: A secret is required to sign the session cookie")
+	}
+	return &SessionManager{
+		store:           store,
+		secret:          secret,
+		cookieName:      DefaultSessionCookieName,
+		cookiePath:      "/",
+		secure:          false,
+		sameSite:        http.SameSiteLaxMode,
+		idleTimeout:     defaultSessionIdleTimeout,
+		absoluteTimeout: defaultSessionAbsoluteTimeout,
+		lastSweep:       time.Now(),
+		sweeping:        false,
+		logger:          logging.NewLogger("Session"),
+		now:             time.Now,
+	}
+}
+
+/*
+SetCookieName set the name of the session cookie. Default is SESSIONID
+*/
+func (p *SessionManager) SetCookieName(name string) {
+	p.cookieName = name
+}
+
+/*
+SetCookiePath set the path of the session cookie. Default is /
+*/
+func (p *SessionManager) SetCookiePath(path string) {
+	p.cookiePath = path
+}
+
+/*
+SetSecure if true the cookie is only sent over https
+*/
+func (p *SessionManager) SetSecure(secure bool) {
+	p.secure = secure
+}
+
+/*
+SetSameSite set the SameSite attribute of the cookie. Default is http.SameSiteLaxMode
+*/
+func (p *SessionManager) SetSameSite(sameSite http.SameSite) {
+	p.sameSite = sameSite
+}
+
+/*
+SetIdleTimeout the session expires if it is not used for this duration. Default is 30 minutes
+*/
+func (p *SessionManager) SetIdleTimeout(timeout time.Duration) {
+	p.idleTimeout = timeout
+}
+
+/*
+SetAbsoluteTimeout the session expires when it is older than this duration. Default is 8 hours
+*/
+func (p *SessionManager) SetAbsoluteTimeout(timeout time.Duration) {
+	p.absoluteTimeout = timeout
+}
+
+/*
+BeforeHandler finds the session for the cookie (if there is one) and adds it to the response.
+Sessions are NOT created here. RequestHandlerHelper.GetSession() creates a session if required.
+*/
+func (p *SessionManager) BeforeHandler(request *http.Request, response *Response) {
+	response.sessions = p
+	now := p.now()
+	p.sweep(now)
+	cookie, err := request.Cookie(p.cookieName)
+	if err != nil {
+		return
+	}
+	id, ok := p.verifyCookieValue(cookie.Value)
+	if !ok {
+		return
+	}
+	data, err := p.store.Load(id)
+	if err != nil {
+		panicapi.ThrowError(500, panicapi.SCSessionError, "Session could not be loaded", "SessionManager: Session load failed: "+err.Error())
+	}
+	if data == nil {
+		return
+	}
+	if p.isExpired(data, now) {
+		p.store.Delete(id)
+		return
+	}
+	session := &Session{data: data, manager: p, response: response}
+	if p.idleTimeout > 0 && now.Sub(data.Accessed) >= p.idleTimeout/sessionAccessedFraction {
+		data.Accessed = now
+		session.save()
+	}
+	response.SetSession(session)
+}
+
+/*
+createSession creates a new session and adds the cookie to the response headers
+*/
+func (p *SessionManager) createSession(response *Response) *Session {
+	now := p.now()
+	session := &Session{
+		data: &SessionData{
+			ID:       newSessionID(),
+			Created:  now,
+			Accessed: now,
+			Values:   make(map[string]string),
+		},
+		manager:     p,
+		response:    response,
+		invalidated: false,
+	}
+	session.save()
+	p.setCookie(response, p.signCookieValue(session.data.ID), int(p.absoluteTimeout/time.Second))
+	response.SetSession(session)
+	return session
+}
+
+/*
+setCookie replaces the session cookie in the response headers. A maxAge < 0 expires the cookie in the browser.
+*/
+func (p *SessionManager) setCookie(response *Response, value string, maxAge int) {
+	cookies := []string{}
+	for _, cookie := range response.GetHeaders()["Set-Cookie"] {
+		if !strings.HasPrefix(cookie, p.cookieName+"=") {
+			cookies = append(cookies, cookie)
+		}
+	}
+	response.AddHeader("Set-Cookie", cookies)
+	response.addCookie(&http.Cookie{
+		Name:     p.cookieName,
+		Value:    value,
+		Path:     p.cookiePath,
+		MaxAge:   maxAge,
+		Secure:   p.secure,
+		HttpOnly: true,
+		SameSite: p.sameSite,
+	})
+}
+
+/*
+newSessionID returns a random session ID
+*/
+func newSessionID() string {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		panicapi.ThrowError(500, panicapi.SCSessionError, "Session could not be created", "SessionManager: Random ID failed: "+err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (p *SessionManager) isExpired(data *SessionData, now time.Time) bool {
+	if p.idleTimeout > 0 && now.Sub(data.Accessed) > p.idleTimeout {
+		return true
+	}
+	if p.absoluteTimeout > 0 && now.Sub(data.Created) > p.absoluteTimeout {
+		return true
+	}
+	return false
+}
+
+/*
+sweep - Remove expired sessions from the store in a background go routine so the request is not delayed.
+Only one sweep runs at a time.
+*/
+func (p *SessionManager) sweep(now time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.sweeping || now.Sub(p.lastSweep) < sessionSweepInterval {
+		return
+	}
+	p.lastSweep = now
+	p.sweeping = true
+	p.sweeps.Add(1)
+	go func() {
+		defer func() {
+			p.mutex.Lock()
+			p.sweeping = false
+			p.mutex.Unlock()
+			p.sweeps.Done()
+		}()
+		_, err := p.store.DeleteIf(func(data *SessionData) bool {
+			return p.isExpired(data, now)
+		})
+		if err != nil && p.logger.IsError() {
+			p.logger.LogErrorf("SessionManager: Sweep of expired sessions failed: %s", err.Error())
+		}
+	}()
+}
+
+func (p *SessionManager) signCookieValue(id string) string {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(id))
+	return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (p *SessionManager) verifyCookieValue(value string) (string, bool) {
+	pos := strings.LastIndex(value, ".")
+	if pos < 1 {
+		return "", false
+	}
+	id := value[:pos]
+	if !hmac.Equal([]byte(value), []byte(p.signCookieValue(id))) {
+		return "", false
+	}
+	return id, true
+}
+
+/*
+GetID returns the session ID
+*/
+func (p *Session) GetID() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.data.ID
+}
+
+/*
+Get returns a session value. Empty if not found
+*/
+func (p *Session) Get(name string) string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.data.Values[name]
+}
+
+/*
+GetValues returns a copy of the session values
+*/
+func (p *Session) GetValues() map[string]string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.data.copy().Values
+}
+
+/*
+Set a session value and save the session
+*/
+func (p *Session) Set(name string, value string) {
+	p.mutex.Lock()
+	p.data.Values[name] = value
+	p.mutex.Unlock()
+	p.save()
+}
+
+/*
+Remove a session value and save the session
+*/
+func (p *Session) Remove(name string) {
+	p.mutex.Lock()
+	delete(p.data.Values, name)
+	p.mutex.Unlock()
+	p.save()
+}
+
+/*
+Invalidate removes the session from the store. For example on logout.
+
+The session is removed from the response and the cookie is expired. The session can no longer be saved so
+changes after Invalidate are discarded. RequestHandlerHelper.GetSession() will create a new session.
+*/
+func (p *Session) Invalidate() {
+	p.mutex.Lock()
+	p.data.Values = make(map[string]string)
+	p.invalidated = true
+	id := p.data.ID
+	p.mutex.Unlock()
+	err := p.manager.store.Delete(id)
+	if err != nil {
+		panicapi.ThrowError(500, panicapi.SCSessionError, "Session could not be deleted", "Session: Delete failed: "+err.Error())
+	}
+	if p.response != nil {
+		if p.response.GetSession() == p {
+			p.response.SetSession(nil)
+		}
+		p.manager.setCookie(p.response, "", -1)
+	}
+}
+
+/*
+Regenerate gives the session a new ID and removes the old ID from the store. The values are retained.
+Call this when the privilege level changes (for example after login) to prevent session fixation.
+*/
+func (p *Session) Regenerate() {
+	p.mutex.Lock()
+	if p.invalidated {
+		p.mutex.Unlock()
+		panicapi.ThrowError(500, panicapi.SCSessionError, "Session could not be regenerated", "Session: Regenerate called after Invalidate")
+	}
+	oldID := p.data.ID
+	p.data.ID = newSessionID()
+	p.data.Accessed = p.manager.now()
+	p.mutex.Unlock()
+	p.save()
+	err := p.manager.store.Delete(oldID)
+	if err != nil {
+		panicapi.ThrowError(500, panicapi.SCSessionError, "Session could not be deleted", "Session: Delete failed: "+err.Error())
+	}
+	if p.response != nil {
+		p.manager.setCookie(p.response, p.manager.signCookieValue(p.GetID()), int(p.manager.absoluteTimeout/time.Second))
+	}
+}
+
+/*
+save the session to the store. An invalidated session is never saved.
+*/
+func (p *Session) save() {
+	p.mutex.Lock()
+	if p.invalidated {
+		p.mutex.Unlock()
+		return
+	}
+	data := p.data.copy()
+	p.mutex.Unlock()
+	err := p.manager.store.Save(data)
+	if err != nil {
+		panicapi.ThrowError(500, panicapi.SCSessionError, "Session could not be saved", "Session: Save failed: "+err.Error())
+	}
+}
+
+/*
+GetSessionFromRequest returns the session for a request. Use this in template data providers.
+Returns nil if the request does not have a session.
+*/
+func GetSessionFromRequest(request *http.Request) *Session {
+	session, _ := request.Context().Value(sessionContextKey{}).(*Session)
+	return session
+}
+
+/*
+withSession returns a copy of the request with the session in it's context. See GetSessionFromRequest
+*/
+func withSession(request *http.Request, session *Session) *http.Request {
+	if session == nil {
+		return request
+	}
+	return request.WithContext(context.WithValue(request.Context(), sessionContextKey{}, session))
+}
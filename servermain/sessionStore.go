@@ -0,0 +1,237 @@
+package servermain
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+SessionData - The persisted state of a session. Stores save and load copies of this.
+*/
+type SessionData struct {
+	ID       string
+	Created  time.Time
+	Accessed time.Time
+	Values   map[string]string
+}
+
+/*
+SessionStore - Persist sessions. Implementations MUST be safe for concurrent use.
+
+Load returns nil, nil if the session does not exist.
+DeleteIf removes ALL sessions for which the function returns true and returns the number removed.
+*/
+type SessionStore interface {
+	Load(id string) (*SessionData, error)
+	Save(data *SessionData) error
+	Delete(id string) error
+	DeleteIf(func(*SessionData) bool) (int, error)
+}
+
+/*
+MemorySessionStore - Sessions held in memory. Sessions are lost when the server stops.
+*/
+type MemorySessionStore struct {
+	mutex    sync.Mutex
+	sessions map[string]*SessionData
+}
+
+/*
+FileSessionStore - Sessions held as JSON files (one per session) in a directory.
+*/
+type FileSessionStore struct {
+	mutex sync.Mutex
+	path  string
+}
+
+/*
+NewMemorySessionStore create an empty in memory session store
+*/
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions: make(map[string]*SessionData),
+	}
+}
+
+/*
+Load a copy of the session
+*/
+func (p *MemorySessionStore) Load(id string) (*SessionData, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	data, found := p.sessions[id]
+	if !found {
+		return nil, nil
+	}
+	return data.copy(), nil
+}
+
+/*
+Save a copy of the session
+*/
+func (p *MemorySessionStore) Save(data *SessionData) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.sessions[data.ID] = data.copy()
+	return nil
+}
+
+/*
+Delete the session
+*/
+func (p *MemorySessionStore) Delete(id string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.sessions, id)
+	return nil
+}
+
+/*
+DeleteIf delete sessions for which the function returns true
+*/
+func (p *MemorySessionStore) DeleteIf(remove func(*SessionData) bool) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	count := 0
+	for id, data := range p.sessions {
+		if remove(data) {
+			delete(p.sessions, id)
+			count++
+		}
+	}
+	return count, nil
+}
+
+/*
+NewFileSessionStore create a file session store. The path must exist.
+*/
+func NewFileSessionStore(path string) *FileSessionStore {
+	stat, err := os.Stat(path)
+	if err != nil {
+		panic("NewFileSessionStore: The path [" + path + "] for session files could not be found: " + err.Error())
+	}
+	if !stat.IsDir() {
+		panic("NewFileSessionStore: The path [" + path + "] for session files is not a directory")
+	}
+	return &FileSessionStore{
+		path: path,
+	}
+}
+
+/*
+Load the session from it's file
+*/
+func (p *FileSessionStore) Load(id string) (*SessionData, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	fileName, err := p.fileName(id)
+	if err != nil {
+		return nil, err
+	}
+	return readSessionFile(fileName)
+}
+
+/*
+Save the session to it's file
+*/
+func (p *FileSessionStore) Save(data *SessionData) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	fileName, err := p.fileName(data.ID)
+	if err != nil {
+		return err
+	}
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, bytes, 0600)
+}
+
+/*
+Delete the session file
+*/
+func (p *FileSessionStore) Delete(id string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	fileName, err := p.fileName(id)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(fileName)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+/*
+DeleteIf delete session files for which the function returns true
+*/
+func (p *FileSessionStore) DeleteIf(remove func(*SessionData) bool) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	fileNames, err := filepath.Glob(filepath.Join(p.path, "*"+sessionFileExtension))
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, fileName := range fileNames {
+		data, err := readSessionFile(fileName)
+		if err != nil || data == nil || remove(data) {
+			os.Remove(fileName)
+			count++
+		}
+	}
+	return count, nil
+}
+
+const sessionFileExtension = ".session"
+
+/*
+fileName - Session IDs are base64 (url) encoded. Reject anything else so the ID cannot escape the path.
+*/
+func (p *FileSessionStore) fileName(id string) (string, error) {
+	if id == "" || strings.Trim(id, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_") != "" {
+		return "", fmt.Errorf("Session ID [%s] is invalid", id)
+	}
+	return filepath.Join(p.path, id+sessionFileExtension), nil
+}
+
+func readSessionFile(fileName string) (*SessionData, error) {
+	bytes, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	data := &SessionData{}
+	err = json.Unmarshal(bytes, data)
+	if err != nil {
+		return nil, fmt.Errorf("Session file [%s] is invalid: %s", fileName, err.Error())
+	}
+	if data.Values == nil {
+		data.Values = make(map[string]string)
+	}
+	return data, nil
+}
+
+func (p *SessionData) copy() *SessionData {
+	values := make(map[string]string)
+	for name, value := range p.Values {
+		values[name] = value
+	}
+	return &SessionData{
+		ID:       p.ID,
+		Created:  p.Created,
+		Accessed: p.Accessed,
+		Values:   values,
+	}
+}
@@ -0,0 +1,237 @@
+package servermain
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stuartdd/webServerBase/logging"
+	"github.com/stuartdd/webServerBase/panicapi"
+	"github.com/stuartdd/webServerBase/test"
+)
+
+func TestSessionCreateAndReuse(t *testing.T) {
+	logging.CreateTestLogger("TestSession")
+	sessions := NewSessionManager(NewMemorySessionStore(), []byte("secret"))
+	server := sessionTestServer(sessions)
+
+	rec := sessionTest(server, "/login/fred", "")
+	test.AssertIntEqual(t, "", rec.Code, 200)
+	setCookie := rec.Header().Get("Set-Cookie")
+	test.AssertStringContains(t, "", setCookie, DefaultSessionCookieName+"=", "Path=/", "HttpOnly", "SameSite=Lax", "Max-Age=28800")
+	cookie := strings.SplitN(setCookie, ";", 2)[0]
+
+	rec = sessionTest(server, "/who", cookie)
+	test.AssertStringEquals(t, "Same session", rec.Body.String(), "fred")
+	test.AssertStringEquals(t, "No new cookie", rec.Header().Get("Set-Cookie"), "")
+	/*
+		A tampered cookie is ignored so a new session is created
+	*/
+	rec = sessionTest(server, "/who", cookie+"x")
+	test.AssertStringEquals(t, "Tampered", rec.Body.String(), "")
+	test.AssertStringContains(t, "New cookie", rec.Header().Get("Set-Cookie"), DefaultSessionCookieName+"=")
+
+	rec = sessionTest(server, "/logout", cookie)
+	test.AssertStringContains(t, "Expired cookie", rec.Header().Get("Set-Cookie"), DefaultSessionCookieName+"=;", "Max-Age=0")
+	rec = sessionTest(server, "/who", cookie)
+	test.AssertStringEquals(t, "Logged out", rec.Body.String(), "")
+}
+
+func TestSessionInvalidateNotRevived(t *testing.T) {
+	logging.CreateTestLogger("TestSession")
+	store := NewMemorySessionStore()
+	sessions := NewSessionManager(store, []byte("secret"))
+	server := sessionTestServer(sessions)
+	cookie := strings.SplitN(sessionTest(server, "/login/fred", "").Header().Get("Set-Cookie"), ";", 2)[0]
+	/*
+		A Set after Invalidate does not save the old ID. The session created by GetSession replaces the expired cookie
+	*/
+	rec := sessionTest(server, "/logout/set", cookie)
+	test.AssertIntEqual(t, "", len(rec.Header()["Set-Cookie"]), 1)
+	test.AssertStringDoesNotContain(t, "New session cookie", rec.Header().Get("Set-Cookie"), "Max-Age=0")
+	test.AssertIntEqual(t, "Only the new session", len(store.sessions), 1)
+	test.AssertStringEquals(t, "Not revived", sessionTest(server, "/who", cookie).Body.String(), "")
+	newCookie := strings.SplitN(rec.Header().Get("Set-Cookie"), ";", 2)[0]
+	test.AssertStringEquals(t, "New session", sessionTest(server, "/who", newCookie).Body.String(), "guest")
+}
+
+func TestSessionRegenerate(t *testing.T) {
+	logging.CreateTestLogger("TestSession")
+	store := NewMemorySessionStore()
+	sessions := NewSessionManager(store, []byte("secret"))
+	server := sessionTestServer(sessions)
+	cookie := strings.SplitN(sessionTest(server, "/login/fred", "").Header().Get("Set-Cookie"), ";", 2)[0]
+
+	rec := sessionTest(server, "/regenerate", cookie)
+	test.AssertIntEqual(t, "", len(rec.Header()["Set-Cookie"]), 1)
+	newCookie := strings.SplitN(rec.Header().Get("Set-Cookie"), ";", 2)[0]
+	test.AssertBoolTrue(t, "New ID", newCookie != cookie)
+	test.AssertIntEqual(t, "Old ID removed", len(store.sessions), 1)
+	test.AssertStringEquals(t, "Old cookie", sessionTest(server, "/who", cookie).Body.String(), "")
+	test.AssertStringEquals(t, "Values retained", sessionTest(server, "/who", newCookie).Body.String(), "fred")
+}
+
+func TestSessionExpiry(t *testing.T) {
+	logging.CreateTestLogger("TestSession")
+	now := time.Now()
+	store := NewMemorySessionStore()
+	sessions := NewSessionManager(store, []byte("secret"))
+	sessions.now = func() time.Time { return now }
+	sessions.SetIdleTimeout(10 * time.Minute)
+	sessions.SetAbsoluteTimeout(time.Hour)
+	server := sessionTestServer(sessions)
+
+	cookie := strings.SplitN(sessionTest(server, "/login/fred", "").Header().Get("Set-Cookie"), ";", 2)[0]
+	/*
+		Keep the session alive with requests inside the idle timeout until the absolute timeout
+	*/
+	for i := 0; i < 6; i++ {
+		now = now.Add(9 * time.Minute)
+		test.AssertStringEquals(t, "Idle "+strconv.Itoa(i), sessionTest(server, "/who", cookie).Body.String(), "fred")
+	}
+	now = now.Add(7 * time.Minute)
+	test.AssertStringEquals(t, "Absolute", sessionTest(server, "/who", cookie).Body.String(), "")
+
+	cookie = strings.SplitN(sessionTest(server, "/login/bob", "").Header().Get("Set-Cookie"), ";", 2)[0]
+	now = now.Add(11 * time.Minute)
+	test.AssertStringEquals(t, "Idle", sessionTest(server, "/who", cookie).Body.String(), "")
+	/*
+		Expired sessions are swept from the store
+	*/
+	sessionTest(server, "/login/joe", "")
+	sessions.sweeps.Wait()
+	test.AssertBoolTrue(t, "Sessions", len(store.sessions) > 1)
+	now = now.Add(2 * time.Hour)
+	sessionTest(server, "/who", "")
+	sessions.sweeps.Wait()
+	test.AssertIntEqual(t, "", len(store.sessions), 1)
+}
+
+func TestSessionAccessedNotSavedEveryRequest(t *testing.T) {
+	logging.CreateTestLogger("TestSession")
+	now := time.Now()
+	store := &countingSessionStore{SessionStore: NewMemorySessionStore()}
+	sessions := NewSessionManager(store, []byte("secret"))
+	sessions.now = func() time.Time { return now }
+	sessions.SetIdleTimeout(10 * time.Minute)
+	server := sessionTestServer(sessions)
+
+	cookie := strings.SplitN(sessionTest(server, "/login/fred", "").Header().Get("Set-Cookie"), ";", 2)[0]
+	saves := store.saves
+	for i := 0; i < 5; i++ {
+		now = now.Add(10 * time.Second)
+		test.AssertStringEquals(t, "", sessionTest(server, "/who", cookie).Body.String(), "fred")
+	}
+	test.AssertIntEqual(t, "Not saved", store.saves, saves)
+	/*
+		The accessed time is saved once it is older than a tenth of the idle timeout
+	*/
+	now = now.Add(time.Minute)
+	sessionTest(server, "/who", cookie)
+	test.AssertIntEqual(t, "Saved", store.saves, saves+1)
+}
+
+type countingSessionStore struct {
+	SessionStore
+	saves int
+}
+
+func (p *countingSessionStore) Save(data *SessionData) error {
+	p.saves++
+	return p.SessionStore.Save(data)
+}
+
+func TestSessionFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "session")
+	test.AssertErrorIsNil(t, "", err)
+	defer os.RemoveAll(dir)
+	store := NewFileSessionStore(dir)
+
+	data, err := store.Load("abc")
+	test.AssertErrorIsNil(t, "", err)
+	test.AssertNil(t, "Not found", data)
+
+	now := time.Now()
+	test.AssertErrorIsNil(t, "", store.Save(&SessionData{ID: "abc", Created: now, Accessed: now, Values: map[string]string{"user": "fred"}}))
+	test.AssertErrorIsNil(t, "", store.Save(&SessionData{ID: "def", Created: now, Accessed: now, Values: nil}))
+	data, err = store.Load("abc")
+	test.AssertErrorIsNil(t, "", err)
+	test.AssertStringEquals(t, "", data.Values["user"], "fred")
+	test.AssertBoolTrue(t, "", data.Created.Equal(now))
+
+	count, err := store.DeleteIf(func(data *SessionData) bool { return data.ID == "def" })
+	test.AssertErrorIsNil(t, "", err)
+	test.AssertIntEqual(t, "", count, 1)
+	test.AssertErrorIsNil(t, "", store.Delete("abc"))
+	test.AssertErrorIsNil(t, "Delete twice", store.Delete("abc"))
+	data, _ = store.Load("abc")
+	test.AssertNil(t, "Deleted", data)
+
+	test.AssertErrorTextContains(t, "", store.Save(&SessionData{ID: "../x"}), "is invalid")
+	_, err = store.Load("")
+	test.AssertErrorTextContains(t, "", err, "is invalid")
+}
+
+func TestSessionNotConfigured(t *testing.T) {
+	logging.CreateTestLogger("TestSession")
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.AddMappedHandler("/who", http.MethodGet, sessionWhoHandler)
+	rec := sessionTest(server, "/who", "")
+	test.AssertIntEqual(t, "", rec.Code, 500)
+	test.AssertStringContains(t, "", rec.Body.String(), "\"Code\":"+strconv.Itoa(panicapi.SCSessionError))
+}
+
+func TestSessionFromRequest(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	test.AssertBoolTrue(t, "No session", GetSessionFromRequest(req) == nil)
+	test.AssertBoolTrue(t, "Same request", withSession(req, nil) == req)
+	session := &Session{data: &SessionData{ID: "abc"}}
+	test.AssertStringEquals(t, "", GetSessionFromRequest(withSession(req, session)).GetID(), "abc")
+}
+
+func sessionTestServer(sessions *SessionManager) *ServerInstanceData {
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.AddBeforeHandler(sessions.BeforeHandler)
+	server.AddMappedHandlerWithNames("/login/?", http.MethodGet, func(r *http.Request, response *Response) {
+		h := NewRequestHandlerHelper(r, response)
+		h.GetSession().Set("user", h.GetNamedURLPart("user", ""))
+		response.SetResponse(200, "OK", "")
+	}, []string{"user"})
+	server.AddMappedHandler("/logout", http.MethodGet, func(r *http.Request, response *Response) {
+		NewRequestHandlerHelper(r, response).GetSession().Invalidate()
+		response.SetResponse(200, "OK", "")
+	})
+	server.AddMappedHandler("/logout/set", http.MethodGet, func(r *http.Request, response *Response) {
+		h := NewRequestHandlerHelper(r, response)
+		session := h.GetSession()
+		session.Invalidate()
+		session.Set("user", "revived")
+		h.GetSession().Set("user", "guest")
+		response.SetResponse(200, "OK", "")
+	})
+	server.AddMappedHandler("/regenerate", http.MethodGet, func(r *http.Request, response *Response) {
+		NewRequestHandlerHelper(r, response).GetSession().Regenerate()
+		response.SetResponse(200, "OK", "")
+	})
+	server.AddMappedHandler("/who", http.MethodGet, sessionWhoHandler)
+	return server
+}
+
+func sessionWhoHandler(r *http.Request, response *Response) {
+	response.SetResponse(200, NewRequestHandlerHelper(r, response).GetSession().Get("user"), "")
+}
+
+func sessionTest(server *ServerInstanceData, url string, cookie string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	if cookie != "" {
+		req.Header.Set("Cookie", cookie)
+	}
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	return rec
+}
@@ -0,0 +1,240 @@
+package servermain
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+unmappedRouteName - The route name used in the statistics when a request did not match any mapping
+*/
+const unmappedRouteName = "UNMAPPED"
+
+/*
+otherMethodName - The method name used in the statistics and metrics for an unmapped request with a non standard method
+*/
+const otherMethodName = "OTHER"
+
+/*
+standardMethods - The methods of an unmapped request that are counted by name. The method is sent by the client so
+any other value is counted as otherMethodName to limit the number of entries.
+*/
+var standardMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+/*
+defaultErrorHistorySize - The number of error records retained if SetErrorHistorySize is not called
+*/
+const defaultErrorHistorySize = 10
+
+/*
+ErrorRecord - The details of a single error response. The last N of these are retained.
+*/
+type ErrorRecord struct {
+	Time    string
+	TxID    string
+	Method  string
+	Route   string
+	Status  int
+	SubCode int
+	Message string
+}
+
+/*
+RouteStatistics - Counters for a single mapped route (method + route pattern)
+*/
+type RouteStatistics struct {
+	Requests int64
+	Errors   int64
+	Panics   int64
+}
+
+/*
+StatisticsData - A snapshot of the request statistics. This is returned as part of StatusData
+*/
+type StatisticsData struct {
+	Requests      int64
+	Errors        int64
+	Routes        map[string]*RouteStatistics
+	StatusClasses map[string]int64
+	SubCodes      map[int]int64
+	LastErrors    []*ErrorRecord
+}
+
+/*
+serverStatistics - The live statistics. ALL access is via the mutex as requests are concurrent
+*/
+type serverStatistics struct {
+	mutex            sync.Mutex
+	panics           int
+	errorHistorySize int
+	data             *StatisticsData
+}
+
+func newServerStatistics() *serverStatistics {
+	return &serverStatistics{
+		panics:           0,
+		errorHistorySize: defaultErrorHistorySize,
+		data: &StatisticsData{
+			Requests:      0,
+			Errors:        0,
+			Routes:        make(map[string]*RouteStatistics),
+			StatusClasses: make(map[string]int64),
+			SubCodes:      make(map[int]int64),
+			LastErrors:    []*ErrorRecord{},
+		},
+	}
+}
+
+/*
+setErrorHistorySize - Change the number of error records retained. Existing records are trimmed if required.
+*/
+func (p *serverStatistics) setErrorHistorySize(size int) {
+	if size < 0 {
+		size = 0
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.errorHistorySize = size
+	p.trimErrors()
+}
+
+/*
+recordPanic - Count an UNHANDLED panic against the route
+*/
+func (p *serverStatistics) recordPanic(method string, route string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.panics++
+	p.getRouteStatistics(method, route).Panics++
+}
+
+/*
+recordRequest - Count the request against the route, the status class and the sub code.
+If the status is NOT a 2xx or 3xx then it is added to the error history
+*/
+func (p *serverStatistics) recordRequest(method string, route string, status int, subCode int, txid string, message string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	routeStats := p.getRouteStatistics(method, route)
+	routeStats.Requests++
+	p.data.Requests++
+	p.data.StatusClasses[statusClass(status)]++
+	if subCode != 0 {
+		p.data.SubCodes[subCode]++
+	}
+	if status >= 400 || status < 200 {
+		routeStats.Errors++
+		p.data.Errors++
+		if p.errorHistorySize > 0 {
+			p.data.LastErrors = append(p.data.LastErrors, &ErrorRecord{
+				Time:    time.Now().Format("2006-01-02 15:04:05.000"),
+				TxID:    txid,
+				Method:  method,
+				Route:   routeName(route),
+				Status:  status,
+				SubCode: subCode,
+				Message: message,
+			})
+			p.trimErrors()
+		}
+	}
+}
+
+/*
+getPanics - Return the number of UNHANDLED panics
+*/
+func (p *serverStatistics) getPanics() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.panics
+}
+
+/*
+snapshot - Return a deep copy of the statistics so they can be marshaled without holding the lock
+*/
+func (p *serverStatistics) snapshot() *StatisticsData {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	snap := &StatisticsData{
+		Requests:      p.data.Requests,
+		Errors:        p.data.Errors,
+		Routes:        make(map[string]*RouteStatistics),
+		StatusClasses: make(map[string]int64),
+		SubCodes:      make(map[int]int64),
+		LastErrors:    make([]*ErrorRecord, len(p.data.LastErrors)),
+	}
+	for name, value := range p.data.Routes {
+		routeStats := *value
+		snap.Routes[name] = &routeStats
+	}
+	for name, value := range p.data.StatusClasses {
+		snap.StatusClasses[name] = value
+	}
+	for code, value := range p.data.SubCodes {
+		snap.SubCodes[code] = value
+	}
+	for index, value := range p.data.LastErrors {
+		errorRecord := *value
+		snap.LastErrors[index] = &errorRecord
+	}
+	return snap
+}
+
+/*
+getRouteStatistics - Must be called with the mutex locked!
+*/
+func (p *serverStatistics) getRouteStatistics(method string, route string) *RouteStatistics {
+	key := methodName(method, route) + " " + routeName(route)
+	routeStats, found := p.data.Routes[key]
+	if !found {
+		routeStats = &RouteStatistics{}
+		p.data.Routes[key] = routeStats
+	}
+	return routeStats
+}
+
+/*
+trimErrors - Must be called with the mutex locked!
+*/
+func (p *serverStatistics) trimErrors() {
+	over := len(p.data.LastErrors) - p.errorHistorySize
+	if over > 0 {
+		p.data.LastErrors = p.data.LastErrors[over:]
+	}
+}
+
+func routeName(route string) string {
+	if route == "" {
+		return unmappedRouteName
+	}
+	return route
+}
+
+/*
+methodName returns the method for a mapped route. For an unmapped route only standard methods are returned, otherwise OTHER
+*/
+func methodName(method string, route string) string {
+	if route == "" && !standardMethods[method] {
+		return otherMethodName
+	}
+	return method
+}
+
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "other"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
@@ -0,0 +1,86 @@
+package servermain
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stuartdd/webServerBase/panicapi"
+	"github.com/stuartdd/webServerBase/test"
+)
+
+func TestStatisticsCounters(t *testing.T) {
+	stats := newServerStatistics()
+	stats.recordRequest("GET", "/calc/?/div/?", 200, 0, "TX1", "")
+	stats.recordRequest("GET", "/calc/?/div/?", 400, panicapi.SCParamValidation, "TX2", "invalid number")
+	stats.recordPanic("GET", "/calc/?/div/?")
+	stats.recordRequest("GET", "/calc/?/div/?", 500, panicapi.SCRuntimeError, "TX3", "divide by zero")
+	stats.recordRequest("GET", "", 404, panicapi.SCPathNotFound, "TX4", "not mapped")
+
+	snap := stats.snapshot()
+	test.AssertInt64Equal(t, "Requests", snap.Requests, 4)
+	test.AssertInt64Equal(t, "Errors", snap.Errors, 3)
+	test.AssertIntEqual(t, "Panics", stats.getPanics(), 1)
+	route := snap.Routes["GET /calc/?/div/?"]
+	test.AssertNilNot(t, "Route", route)
+	test.AssertInt64Equal(t, "Route Requests", route.Requests, 3)
+	test.AssertInt64Equal(t, "Route Errors", route.Errors, 2)
+	test.AssertInt64Equal(t, "Route Panics", route.Panics, 1)
+	test.AssertInt64Equal(t, "Unmapped", snap.Routes["GET "+unmappedRouteName].Requests, 1)
+	test.AssertInt64Equal(t, "2xx", snap.StatusClasses["2xx"], 1)
+	test.AssertInt64Equal(t, "4xx", snap.StatusClasses["4xx"], 2)
+	test.AssertInt64Equal(t, "5xx", snap.StatusClasses["5xx"], 1)
+	test.AssertInt64Equal(t, "SubCode", snap.SubCodes[panicapi.SCRuntimeError], 1)
+	test.AssertIntEqual(t, "LastErrors", len(snap.LastErrors), 3)
+	test.AssertStringEquals(t, "LastErrors[0]", snap.LastErrors[0].TxID, "TX2")
+	test.AssertStringEquals(t, "LastErrors[2]", snap.LastErrors[2].Route, unmappedRouteName)
+}
+
+func TestStatisticsUnmappedMethods(t *testing.T) {
+	stats := newServerStatistics()
+	stats.recordRequest("FOO1", "", 404, panicapi.SCPathNotFound, "TX1", "not mapped")
+	stats.recordRequest("FOO2", "", 404, panicapi.SCPathNotFound, "TX2", "not mapped")
+	stats.recordRequest("DELETE", "", 404, panicapi.SCPathNotFound, "TX3", "not mapped")
+	stats.recordRequest("PROPFIND", "/dav", 200, 0, "TX4", "")
+	snap := stats.snapshot()
+	test.AssertIntEqual(t, "Routes", len(snap.Routes), 3)
+	test.AssertInt64Equal(t, "Other", snap.Routes[otherMethodName+" "+unmappedRouteName].Requests, 2)
+	test.AssertInt64Equal(t, "Standard", snap.Routes["DELETE "+unmappedRouteName].Requests, 1)
+	test.AssertInt64Equal(t, "Mapped", snap.Routes["PROPFIND /dav"].Requests, 1)
+}
+
+func TestStatisticsErrorHistoryIsTrimmed(t *testing.T) {
+	stats := newServerStatistics()
+	stats.setErrorHistorySize(3)
+	for i := 0; i < 5; i++ {
+		stats.recordRequest("GET", "/x", 404, panicapi.SCPathNotFound, "TX"+strconv.Itoa(i), "")
+	}
+	snap := stats.snapshot()
+	test.AssertIntEqual(t, "LastErrors", len(snap.LastErrors), 3)
+	test.AssertStringEquals(t, "LastErrors[0]", snap.LastErrors[0].TxID, "TX2")
+	test.AssertStringEquals(t, "LastErrors[2]", snap.LastErrors[2].TxID, "TX4")
+	stats.setErrorHistorySize(1)
+	snap = stats.snapshot()
+	test.AssertIntEqual(t, "LastErrors", len(snap.LastErrors), 1)
+	test.AssertStringEquals(t, "LastErrors[0]", snap.LastErrors[0].TxID, "TX4")
+}
+
+func TestStatisticsConcurrent(t *testing.T) {
+	server := NewServerInstanceData("ServerName", "utf-8")
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				server.statistics.recordRequest("GET", "/status", 200, 0, "TX", "")
+				server.statistics.recordPanic("GET", "/status")
+				server.GetStatusData()
+			}
+		}()
+	}
+	wg.Wait()
+	status := server.GetStatusData()
+	test.AssertIntEqual(t, "Panics", status.Panics, 1000)
+	test.AssertInt64Equal(t, "Requests", status.Statistics.Requests, 1000)
+}
@@ -0,0 +1,512 @@
+package servermain
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stuartdd/webServerBase/panicapi"
+)
+
+/*
+WebSocket message types (RFC 6455 opcodes)
+*/
+const (
+	WebSocketTextMessage   = 1
+	WebSocketBinaryMessage = 2
+	WebSocketCloseMessage  = 8
+	WebSocketPingMessage   = 9
+	WebSocketPongMessage   = 10
+)
+
+/*
+WebSocket close status codes (RFC 6455 section 7.4.1)
+*/
+const (
+	WebSocketCloseNormal           = 1000
+	WebSocketCloseGoingAway        = 1001
+	WebSocketCloseProtocolError    = 1002
+	WebSocketCloseNoStatus         = 1005
+	WebSocketCloseInvalidData      = 1007
+	WebSocketCloseMessageTooBig    = 1009
+	WebSocketCloseInternalError    = 1011
+	webSocketContinuationFrame     = 0
+	webSocketGUID                  = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	webSocketMaxControlPayload     = 125
+	defaultWebSocketMaxMessageSize = 1024 * 1024
+)
+
+/*
+ErrWebSocketClosed is returned when reading or writing a connection that has been closed
+*/
+var ErrWebSocketClosed = errors.New("WebSocket connection closed")
+
+/*
+WebSocketCloseError is returned by ReadMessage when the client closes the connection
+*/
+type WebSocketCloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *WebSocketCloseError) Error() string {
+	return fmt.Sprintf("WebSocket closed: CODE=%d REASON=%s", e.Code, e.Reason)
+}
+
+/*
+WebSocketConn - A server side WebSocket connection. See AddWebSocketHandler.
+
+ReadMessage must be called from one go routine only. Write methods can be called from any go routine.
+Ping frames from the client are answered automatically.
+*/
+type WebSocketConn struct {
+	writeMutex     sync.Mutex
+	conn           net.Conn
+	reader         *bufio.Reader
+	request        *http.Request
+	txid           string
+	maxMessageSize int64
+	closeSent      bool
+	closeCode      int
+}
+
+/*
+AddWebSocketHandler creates a GET route that is upgraded to a WebSocket connection.
+
+The before handlers and the role check (see IsAuthorized) are applied to the upgrade request in the same way as any other route.
+The handler is called once the connection is upgraded and the connection is closed when it returns. Example:
+
+	server.AddWebSocketHandler("/echo", func(conn *WebSocketConn) {
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(messageType, data)
+		}
+	})
+
+The route timeout is set to 0 so a global request timeout does not apply to the connection.
+The Origin header must match the Host header or one of the origins defined by SetWebSocketOrigins.
+*/
+func (p *ServerInstanceData) AddWebSocketHandler(path string, handler func(conn *WebSocketConn), roles ...string) {
+	if handler == nil {
+		panic("AddWebSocketHandler: A handler is required")
+	}
+	p.SetRouteTimeout(path, 0)
+	p.AddMappedHandler(path, http.MethodGet, func(request *http.Request, response *Response) {
+		p.upgradeWebSocket(request, response, handler)
+	}, roles...)
+}
+
+/*
+SetWebSocketOrigins set the origins (for example https://example.com:8443) allowed to connect to a WebSocket route
+in addition to the origin that matches the Host header. An origin of * allows ANY origin.
+
+Browsers always send an Origin header. This prevents other sites using the browsers cookies to open a connection (cross-site WebSocket hijacking).
+Requests without an Origin header (not from a browser) are allowed.
+*/
+func (p *ServerInstanceData) SetWebSocketOrigins(origins []string) {
+	list := []string{}
+	for _, origin := range origins {
+		origin = strings.TrimSpace(origin)
+		if origin != "" {
+			list = append(list, strings.TrimSuffix(origin, "/"))
+		}
+	}
+	p.webSocketOrigins = list
+}
+
+/*
+isWebSocketOriginAllowed returns true if there is no Origin header, the Origin host matches the Host header or the Origin is in the allowed list
+*/
+func (p *ServerInstanceData) isWebSocketOriginAllowed(request *http.Request) bool {
+	origin := request.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range p.webSocketOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	originURL, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return originURL.Host != "" && strings.EqualFold(originURL.Host, request.Host)
+}
+
+/*
+upgradeWebSocket validates the handshake, hijacks the connection and runs the handler.
+The response is closed so the server does not write anything more to the connection.
+*/
+func (p *ServerInstanceData) upgradeWebSocket(request *http.Request, response *Response, handler func(conn *WebSocketConn)) {
+	txid := response.GetTransactionID()
+	if !headerHasToken(request.Header, "Connection", "upgrade") || !headerHasToken(request.Header, "Upgrade", "websocket") {
+		panicapi.ThrowWarning(400, panicapi.SCWebSocketHandshake, "WebSocket upgrade required", fmt.Sprintf("URL:%s Connection:%s Upgrade:%s", request.URL.Path, request.Header.Get("Connection"), request.Header.Get("Upgrade")))
+	}
+	if request.Header.Get("Sec-WebSocket-Version") != "13" {
+		response.AddHeader("Sec-WebSocket-Version", []string{"13"})
+		response.SetErrorResponse(http.StatusUpgradeRequired, panicapi.SCWebSocketHandshake, fmt.Sprintf("Unsupported WebSocket version '%s'", request.Header.Get("Sec-WebSocket-Version")))
+		return
+	}
+	if !p.isWebSocketOriginAllowed(request) {
+		response.SetErrorResponse(http.StatusForbidden, panicapi.SCForbidden, fmt.Sprintf("WebSocket Origin '%s' is not allowed", request.Header.Get("Origin")))
+		return
+	}
+	key := strings.TrimSpace(request.Header.Get("Sec-WebSocket-Key"))
+	decodedKey, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(decodedKey) != 16 {
+		panicapi.ThrowWarning(400, panicapi.SCWebSocketHandshake, "Invalid Sec-WebSocket-Key", fmt.Sprintf("URL:%s Sec-WebSocket-Key:%s", request.URL.Path, key))
+	}
+	netConn, rw, err := response.GetWrappedWriter().Hijack()
+	if err != nil {
+		panicapi.ThrowError(500, panicapi.SCWebSocketHandshake, "WebSocket upgrade failed", fmt.Sprintf("URL:%s Hijack failed: %s", request.URL.Path, err.Error()))
+	}
+	response.Close()
+	defer netConn.Close()
+	var handshake strings.Builder
+	handshake.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	handshake.WriteString("Sec-WebSocket-Accept: " + webSocketAccept(key) + "\r\n")
+	for name, values := range response.GetHeaders() {
+		for _, value := range values {
+			handshake.WriteString(name + ": " + singleLine(value) + "\r\n")
+		}
+	}
+	handshake.WriteString("\r\n")
+	_, err = rw.WriteString(handshake.String())
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		if p.logger.IsWarn() {
+			p.logger.LogWarnf("ID: %s. WebSocket handshake failed: %s", txid, err.Error())
+		}
+		return
+	}
+	conn := &WebSocketConn{
+		conn:           netConn,
+		reader:         rw.Reader,
+		request:        request,
+		txid:           txid,
+		maxMessageSize: defaultWebSocketMaxMessageSize,
+		closeSent:      false,
+		closeCode:      0,
+	}
+	start := time.Now()
+	if p.logger.IsAccess() {
+		p.logger.LogAccessf("ID: %s <<< WEBSOCKET CONNECTED: REQUEST=%s REMOTE=%s", txid, request.URL.Path, request.RemoteAddr)
+	}
+	defer func() {
+		rec := recover()
+		if rec != nil {
+			panicState := panicapi.GetPanicData(rec, txid)
+			p.logger.LogErrorWithStackTrace(txid, "!!!", fmt.Sprintf("ID: %s WEBSOCKET:%s MESSAGE:%s", txid, request.URL.Path, panicState.String()))
+			conn.Close(WebSocketCloseInternalError, "")
+		} else {
+			conn.Close(WebSocketCloseNormal, "")
+		}
+		if p.logger.IsAccess() {
+			p.logger.LogAccessf("ID: %s <<< WEBSOCKET DISCONNECTED: REQUEST=%s CODE=%d DURATION=%s", txid, request.URL.Path, conn.closeCode, time.Since(start))
+		}
+	}()
+	handler(conn)
+}
+
+/*
+webSocketAccept returns the Sec-WebSocket-Accept value for a Sec-WebSocket-Key
+*/
+func webSocketAccept(key string) string {
+	hash := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+/*
+headerHasToken returns true if the comma separated header contains the token (case is ignored)
+*/
+func headerHasToken(header http.Header, name string, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*
+GetRequest returns the upgrade request
+*/
+func (p *WebSocketConn) GetRequest() *http.Request {
+	return p.request
+}
+
+/*
+GetTransactionID returns the transaction ID of the upgrade request
+*/
+func (p *WebSocketConn) GetTransactionID() string {
+	return p.txid
+}
+
+/*
+SetMaxMessageSize sets the largest message that can be read. Default is 1MB.
+If a larger message is received the connection is closed (1009 Message Too Big).
+*/
+func (p *WebSocketConn) SetMaxMessageSize(size int64) {
+	p.maxMessageSize = size
+}
+
+/*
+SetReadDeadline sets the time after which ReadMessage will fail. A zero value means no deadline.
+*/
+func (p *WebSocketConn) SetReadDeadline(t time.Time) error {
+	return p.conn.SetReadDeadline(t)
+}
+
+/*
+ReadMessage returns the next text or binary message. Fragmented messages are joined.
+Ping and Pong frames are handled here so ReadMessage must be called for pings to be answered.
+
+When the client closes the connection the close is acknowledged and a *WebSocketCloseError is returned.
+*/
+func (p *WebSocketConn) ReadMessage() (int, []byte, error) {
+	messageType := 0
+	message := []byte{}
+	for {
+		fin, opcode, payload, err := p.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch opcode {
+		case WebSocketPingMessage:
+			p.writeFrame(WebSocketPongMessage, payload)
+			continue
+		case WebSocketPongMessage:
+			continue
+		case WebSocketCloseMessage:
+			return 0, nil, p.closeReceived(payload)
+		case webSocketContinuationFrame:
+			if messageType == 0 {
+				return 0, nil, p.fail(WebSocketCloseProtocolError, "Unexpected continuation frame")
+			}
+		case WebSocketTextMessage, WebSocketBinaryMessage:
+			if messageType != 0 {
+				return 0, nil, p.fail(WebSocketCloseProtocolError, "Expected continuation frame")
+			}
+			messageType = opcode
+		default:
+			return 0, nil, p.fail(WebSocketCloseProtocolError, fmt.Sprintf("Unknown opcode %d", opcode))
+		}
+		if int64(len(message)+len(payload)) > p.maxMessageSize {
+			return 0, nil, p.fail(WebSocketCloseMessageTooBig, "Message too big")
+		}
+		message = append(message, payload...)
+		if fin {
+			if messageType == WebSocketTextMessage && !utf8.Valid(message) {
+				return 0, nil, p.fail(WebSocketCloseInvalidData, "Invalid UTF-8 in text message")
+			}
+			return messageType, message, nil
+		}
+	}
+}
+
+/*
+WriteMessage sends a text or binary message in a single frame
+*/
+func (p *WebSocketConn) WriteMessage(messageType int, data []byte) error {
+	if messageType != WebSocketTextMessage && messageType != WebSocketBinaryMessage {
+		return fmt.Errorf("WriteMessage: invalid message type %d", messageType)
+	}
+	return p.writeFrame(messageType, data)
+}
+
+/*
+WriteText sends a text message
+*/
+func (p *WebSocketConn) WriteText(text string) error {
+	return p.writeFrame(WebSocketTextMessage, []byte(text))
+}
+
+/*
+WriteBinary sends a binary message
+*/
+func (p *WebSocketConn) WriteBinary(data []byte) error {
+	return p.writeFrame(WebSocketBinaryMessage, data)
+}
+
+/*
+Ping sends a ping. The Pong reply is consumed by ReadMessage.
+*/
+func (p *WebSocketConn) Ping(data []byte) error {
+	if len(data) > webSocketMaxControlPayload {
+		return fmt.Errorf("Ping: payload larger than %d bytes", webSocketMaxControlPayload)
+	}
+	return p.writeFrame(WebSocketPingMessage, data)
+}
+
+/*
+Close sends a close frame (if not already sent) and closes the connection.
+Any go routine blocked in ReadMessage will return ErrWebSocketClosed.
+*/
+func (p *WebSocketConn) Close(code int, reason string) error {
+	if !p.sendClose(code, reason) {
+		return nil
+	}
+	return p.conn.Close()
+}
+
+/*
+sendClose sends the close frame. Returns false if a close frame has already been sent.
+*/
+func (p *WebSocketConn) sendClose(code int, reason string) bool {
+	p.writeMutex.Lock()
+	defer p.writeMutex.Unlock()
+	if p.closeSent {
+		return false
+	}
+	payload := []byte{}
+	if code != WebSocketCloseNoStatus {
+		payload = make([]byte, 2, 2+len(reason))
+		binary.BigEndian.PutUint16(payload, uint16(code))
+		payload = append(payload, reason...)
+		if len(payload) > webSocketMaxControlPayload {
+			payload = payload[:webSocketMaxControlPayload]
+		}
+	}
+	p.writeFrameLocked(WebSocketCloseMessage, payload)
+	p.closeSent = true
+	p.closeCode = code
+	return true
+}
+
+/*
+closeReceived replies to a close frame from the client and closes the connection
+*/
+func (p *WebSocketConn) closeReceived(payload []byte) error {
+	code := WebSocketCloseNoStatus
+	reason := ""
+	if len(payload) >= 2 {
+		code = int(binary.BigEndian.Uint16(payload))
+		reason = string(payload[2:])
+	}
+	p.sendClose(code, "")
+	p.conn.Close()
+	return &WebSocketCloseError{Code: code, Reason: reason}
+}
+
+/*
+fail closes the connection with an error status and returns the error
+*/
+func (p *WebSocketConn) fail(code int, reason string) error {
+	p.sendClose(code, reason)
+	p.conn.Close()
+	return &WebSocketCloseError{Code: code, Reason: reason}
+}
+
+/*
+readFrame reads a single frame and unmasks the payload. Client frames MUST be masked.
+*/
+func (p *WebSocketConn) readFrame() (bool, int, []byte, error) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(p.reader, header)
+	if err != nil {
+		return false, 0, nil, p.readError(err)
+	}
+	fin := header[0]&0x80 != 0
+	opcode := int(header[0] & 0x0f)
+	masked := header[1]&0x80 != 0
+	length := int64(header[1] & 0x7f)
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, p.fail(WebSocketCloseProtocolError, "Reserved bits set")
+	}
+	if !masked {
+		return false, 0, nil, p.fail(WebSocketCloseProtocolError, "Client frames must be masked")
+	}
+	if opcode >= WebSocketCloseMessage && (!fin || length > webSocketMaxControlPayload) {
+		return false, 0, nil, p.fail(WebSocketCloseProtocolError, "Invalid control frame")
+	}
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		_, err = io.ReadFull(p.reader, extended)
+		length = int64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		_, err = io.ReadFull(p.reader, extended)
+		length = int64(binary.BigEndian.Uint64(extended) & 0x7fffffffffffffff)
+	}
+	if err != nil {
+		return false, 0, nil, p.readError(err)
+	}
+	if length > p.maxMessageSize {
+		return false, 0, nil, p.fail(WebSocketCloseMessageTooBig, "Message too big")
+	}
+	mask := make([]byte, 4)
+	_, err = io.ReadFull(p.reader, mask)
+	if err != nil {
+		return false, 0, nil, p.readError(err)
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(p.reader, payload)
+	if err != nil {
+		return false, 0, nil, p.readError(err)
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+func (p *WebSocketConn) readError(err error) error {
+	p.writeMutex.Lock()
+	closeSent := p.closeSent
+	p.writeMutex.Unlock()
+	if closeSent || err == io.EOF {
+		return ErrWebSocketClosed
+	}
+	return err
+}
+
+func (p *WebSocketConn) writeFrame(opcode int, payload []byte) error {
+	p.writeMutex.Lock()
+	defer p.writeMutex.Unlock()
+	if p.closeSent {
+		return ErrWebSocketClosed
+	}
+	return p.writeFrameLocked(opcode, payload)
+}
+
+/*
+writeFrameLocked must be called with the write mutex locked! Server frames are not masked.
+*/
+func (p *WebSocketConn) writeFrameLocked(opcode int, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|byte(opcode))
+	length := len(payload)
+	switch {
+	case length <= 125:
+		frame = append(frame, byte(length))
+	case length <= 0xffff:
+		frame = append(frame, 126, byte(length>>8), byte(length))
+	default:
+		extended := make([]byte, 8)
+		binary.BigEndian.PutUint64(extended, uint64(length))
+		frame = append(append(frame, 127), extended...)
+	}
+	frame = append(frame, payload...)
+	_, err := p.conn.Write(frame)
+	return err
+}
@@ -0,0 +1,303 @@
+package servermain
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stuartdd/webServerBase/logging"
+	"github.com/stuartdd/webServerBase/panicapi"
+	"github.com/stuartdd/webServerBase/test"
+)
+
+const testWebSocketKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+func TestWebSocketAccept(t *testing.T) {
+	/*
+		Example from RFC 6455 section 1.3
+	*/
+	test.AssertStringEquals(t, "", webSocketAccept(testWebSocketKey), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+}
+
+func TestWebSocketEcho(t *testing.T) {
+	logging.CreateTestLogger("TestWebSocket")
+	server, finished := webSocketTestServer()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	conn, reader, resp := webSocketDial(t, httpServer, "/ws", "secret")
+	defer conn.Close()
+	test.AssertIntEqual(t, "", resp.StatusCode, http.StatusSwitchingProtocols)
+	test.AssertStringEquals(t, "", resp.Header.Get("Sec-WebSocket-Accept"), webSocketAccept(testWebSocketKey))
+	test.AssertStringEquals(t, "Before handler headers", resp.Header.Get("X-Before"), "yes")
+
+	webSocketClientWrite(conn, true, WebSocketTextMessage, []byte("hello"))
+	opcode, payload := webSocketClientRead(t, reader)
+	test.AssertIntEqual(t, "", opcode, WebSocketTextMessage)
+	test.AssertStringEquals(t, "", string(payload), "hello")
+	/*
+		Fragmented binary message with a ping in the middle
+	*/
+	webSocketClientWrite(conn, false, WebSocketBinaryMessage, []byte{1, 2})
+	webSocketClientWrite(conn, true, WebSocketPingMessage, []byte("p"))
+	webSocketClientWrite(conn, true, webSocketContinuationFrame, []byte{3})
+	opcode, payload = webSocketClientRead(t, reader)
+	test.AssertIntEqual(t, "", opcode, WebSocketPongMessage)
+	test.AssertStringEquals(t, "", string(payload), "p")
+	opcode, payload = webSocketClientRead(t, reader)
+	test.AssertIntEqual(t, "", opcode, WebSocketBinaryMessage)
+	test.AssertIntEqual(t, "", len(payload), 3)
+	/*
+		Large message uses the 16 bit length
+	*/
+	large := strings.Repeat("x", 300)
+	webSocketClientWrite(conn, true, WebSocketTextMessage, []byte(large))
+	_, payload = webSocketClientRead(t, reader)
+	test.AssertStringEquals(t, "", string(payload), large)
+	/*
+		Client closes. The server replies with the same code
+	*/
+	webSocketClientWrite(conn, true, WebSocketCloseMessage, []byte{0x03, 0xe8})
+	opcode, payload = webSocketClientRead(t, reader)
+	test.AssertIntEqual(t, "", opcode, WebSocketCloseMessage)
+	test.AssertIntEqual(t, "", int(binary.BigEndian.Uint16(payload)), WebSocketCloseNormal)
+	_, err := reader.ReadByte()
+	test.AssertBoolTrue(t, "Connection closed", err == io.EOF)
+	test.AssertIntEqual(t, "", len(<-finished), 8)
+}
+
+func TestWebSocketServerClose(t *testing.T) {
+	logging.CreateTestLogger("TestWebSocket")
+	server, finished := webSocketTestServer()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	conn, reader, _ := webSocketDial(t, httpServer, "/ws", "secret")
+	defer conn.Close()
+	webSocketClientWrite(conn, true, WebSocketTextMessage, []byte("bye"))
+	opcode, payload := webSocketClientRead(t, reader)
+	test.AssertIntEqual(t, "", opcode, WebSocketCloseMessage)
+	test.AssertIntEqual(t, "", int(binary.BigEndian.Uint16(payload)), WebSocketCloseGoingAway)
+	test.AssertStringEquals(t, "", string(payload[2:]), "goodbye")
+	<-finished
+}
+
+func TestWebSocketProtocolError(t *testing.T) {
+	logging.CreateTestLogger("TestWebSocket")
+	server, finished := webSocketTestServer()
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	conn, reader, _ := webSocketDial(t, httpServer, "/ws", "secret")
+	defer conn.Close()
+	/*
+		Client frames must be masked
+	*/
+	conn.Write([]byte{0x81, 0x01, 'x'})
+	opcode, payload := webSocketClientRead(t, reader)
+	test.AssertIntEqual(t, "", opcode, WebSocketCloseMessage)
+	test.AssertIntEqual(t, "", int(binary.BigEndian.Uint16(payload)), WebSocketCloseProtocolError)
+	<-finished
+}
+
+func TestWebSocketHandshakeRejected(t *testing.T) {
+	logging.CreateTestLogger("TestWebSocket")
+	server, _ := webSocketTestServer()
+	/*
+		Vetoed by the before handler
+	*/
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, webSocketRequest("/ws", "wrong"))
+	test.AssertIntEqual(t, "", rec.Code, http.StatusUnauthorized)
+	/*
+		Wrong version
+	*/
+	req := webSocketRequest("/ws", "secret")
+	req.Header.Set("Sec-WebSocket-Version", "8")
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	test.AssertIntEqual(t, "", rec.Code, http.StatusUpgradeRequired)
+	test.AssertStringEquals(t, "", strings.Join(rec.Header()["Sec-WebSocket-Version"], ","), "13")
+	/*
+		Not an upgrade request
+	*/
+	req = httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req.Header.Set("X-Token", "secret")
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	test.AssertIntEqual(t, "", rec.Code, http.StatusBadRequest)
+	test.AssertStringContains(t, "", rec.Body.String(), "WebSocket upgrade required")
+	/*
+		The recorder does not support Hijack
+	*/
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, webSocketRequest("/ws", "secret"))
+	test.AssertIntEqual(t, "", rec.Code, http.StatusInternalServerError)
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	logging.CreateTestLogger("TestWebSocket")
+	server, _ := webSocketTestServer()
+	/*
+		Origin from another site is rejected
+	*/
+	req := webSocketRequest("/ws", "secret")
+	req.Header.Set("Origin", "http://other.com")
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	test.AssertIntEqual(t, "", rec.Code, http.StatusForbidden)
+	test.AssertStringContains(t, "", rec.Body.String(), "http://other.com")
+	/*
+		Origin matches the Host. The recorder does not support Hijack so the handshake passed
+	*/
+	req = webSocketRequest("/ws", "secret")
+	req.Header.Set("Origin", "https://"+req.Host)
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	test.AssertIntEqual(t, "", rec.Code, http.StatusInternalServerError)
+	/*
+		Origin in the allowed list
+	*/
+	server.SetWebSocketOrigins([]string{"http://other.com/"})
+	req = webSocketRequest("/ws", "secret")
+	req.Header.Set("Origin", "http://other.com")
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	test.AssertIntEqual(t, "", rec.Code, http.StatusInternalServerError)
+	req.Header.Set("Origin", "http://other.com:8080")
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	test.AssertIntEqual(t, "", rec.Code, http.StatusForbidden)
+}
+
+func TestWebSocketNoTimeout(t *testing.T) {
+	server, _ := webSocketTestServer()
+	server.SetRequestTimeout(time.Millisecond)
+	test.AssertInt64Equal(t, "WebSocket route", int64(server.GetRequestTimeout("/ws")), 0)
+	test.AssertInt64Equal(t, "Other route", int64(server.GetRequestTimeout("/other")), int64(time.Millisecond))
+}
+
+func TestWebSocketHandshakeCodes(t *testing.T) {
+	server := NewServerInstanceData("ServerName", "utf-8")
+	req := webSocketRequest("/ws", "")
+	req.Header.Set("Sec-WebSocket-Key", "short")
+	defer func() {
+		panicState := panicapi.GetPanicData(recover(), "tx")
+		test.AssertIntEqual(t, "", panicState.StatusCode, http.StatusBadRequest)
+		test.AssertIntEqual(t, "", panicState.SubCode, panicapi.SCWebSocketHandshake)
+	}()
+	server.upgradeWebSocket(req, NewResponse(NewResponseWriterWrapper(httptest.NewRecorder()), server, "tx"), func(conn *WebSocketConn) {})
+}
+
+/*
+webSocketTestServer returns a server with an echo WebSocket at /ws. The channel receives the transaction ID
+from an after handler once the WebSocket handler has returned (and the disconnect is logged).
+*/
+func webSocketTestServer() (*ServerInstanceData, chan string) {
+	finished := make(chan string, 1)
+	server := NewServerInstanceData("ServerName", "utf-8")
+	server.AddBeforeHandler(func(r *http.Request, response *Response) {
+		if r.Header.Get("X-Token") != "secret" {
+			response.SetErrorResponse(http.StatusUnauthorized, panicapi.SCUnauthorized, "Authentication required")
+			return
+		}
+		response.AddHeader("X-Before", []string{"yes"})
+	})
+	server.AddWebSocketHandler("/ws", func(conn *WebSocketConn) {
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if string(data) == "bye" {
+				conn.Close(WebSocketCloseGoingAway, "goodbye")
+				return
+			}
+			conn.WriteMessage(messageType, data)
+		}
+	})
+	server.AddAfterHandler(func(r *http.Request, response *Response) {
+		finished <- response.GetTransactionID()
+	})
+	return server, finished
+}
+
+func webSocketRequest(url string, token string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", testWebSocketKey)
+	req.Header.Set("X-Token", token)
+	return req
+}
+
+func webSocketDial(t *testing.T, httpServer *httptest.Server, url string, token string) (net.Conn, *bufio.Reader, *http.Response) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(httpServer.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := webSocketRequest(url, token)
+	req.RequestURI = ""
+	err = req.Write(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, reader, resp
+}
+
+/*
+webSocketClientWrite writes a masked (client) frame
+*/
+func webSocketClientWrite(conn net.Conn, fin bool, opcode int, payload []byte) {
+	first := byte(opcode)
+	if fin {
+		first = first | 0x80
+	}
+	frame := []byte{first}
+	if len(payload) < 126 {
+		frame = append(frame, 0x80|byte(len(payload)))
+	} else {
+		frame = append(frame, 0x80|126, byte(len(payload)>>8), byte(len(payload)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	conn.Write(frame)
+}
+
+/*
+webSocketClientRead reads an unmasked (server) frame
+*/
+func webSocketClientRead(t *testing.T, reader *bufio.Reader) (int, []byte) {
+	header := make([]byte, 2)
+	_, err := io.ReadFull(reader, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	length := int(header[1] & 0x7f)
+	if length == 126 {
+		extended := make([]byte, 2)
+		io.ReadFull(reader, extended)
+		length = int(binary.BigEndian.Uint16(extended))
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(reader, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int(header[0] & 0x0f), payload
+}